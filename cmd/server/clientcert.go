@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+	"github.com/tafli/CallingParents/internal/auth"
+	"github.com/tafli/CallingParents/internal/tlscert"
+)
+
+// certIssuer issues client certificates (mTLS) for fixed devices such as
+// wall-mounted tablets.
+type certIssuer struct {
+	ca      *tlscert.Local
+	devices *auth.Registry
+	// dir is where the certificates and keys are written.
+	dir string
+}
+
+// issue enrolls a device with a client certificate and writes the
+// certificate and key to c.dir.
+func (c *certIssuer) issue(name string, role auth.Role) (d auth.Device, certPath, keyPath string, err error) {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return auth.Device{}, "", "", err
+	}
+	d, err = c.devices.EnrollCertificate(name, role)
+	if err != nil {
+		return auth.Device{}, "", "", err
+	}
+	certPath = filepath.Join(c.dir, "device-"+d.ID+".pem")
+	keyPath = filepath.Join(c.dir, "device-"+d.ID+"-key.pem")
+	certPEM, keyPEM, err := c.ca.IssueClient(auth.ClientCertSubject(d))
+	if err == nil {
+		err = atomicfile.WriteFile(keyPath, keyPEM, 0600)
+	}
+	if err == nil {
+		err = atomicfile.WriteFile(certPath, certPEM, 0644)
+	}
+	if err != nil {
+		// Without its certificate the device cannot log in.
+		c.devices.Revoke(d.ID)
+		return auth.Device{}, "", "", err
+	}
+	return d, certPath, keyPath, nil
+}
+
+// printIssue issues a client certificate and prints how to install it.
+func (c *certIssuer) printIssue(name string, role auth.Role) {
+	d, certPath, keyPath, err := c.issue(name, role)
+	if err != nil {
+		fmt.Printf("Issuing client certificate failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Issued client certificate for %q (%s, device %s):\n  %s\n  %s\n", d.Name, d.Role, d.ID, certPath, keyPath)
+	fmt.Println("Phones and tablets install it as a PKCS#12 file, e.g.:")
+	fmt.Printf("  openssl pkcs12 -export -in %s -inkey %s -out %s\n", certPath, keyPath, strings.TrimSuffix(certPath, ".pem")+".p12")
+	fmt.Println("Revoking the device revokes the certificate.")
+	fmt.Println()
+}
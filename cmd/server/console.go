@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+// consoleHelp lists the commands runConsole understands.
+const consoleHelp = `Commands:
+  rotate  replace the auth token and print the new QR code
+  pair    print a new pairing code for a device without a camera
+  cert <role> <name>
+          issue a client certificate for a fixed device (tls_client_certs)
+  help    show this help`
+
+// runConsole reads commands typed into the server's terminal until in is
+// closed. When the server runs as a service without a terminal, in is empty
+// and runConsole returns at once. certs is nil without client certificates.
+func runConsole(in io.Reader, shared *auth.SharedToken, devices *auth.Registry, certs *certIssuer) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		cmd, args, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch cmd {
+		case "":
+		case "rotate":
+			if _, _, err := shared.Rotate(); err != nil {
+				log.Printf("WARNING: rotating auth token: %v", err)
+			}
+		case "pair":
+			printPairingCode(devices)
+		case "cert":
+			if certs == nil {
+				fmt.Println("Client certificates are off; set tls_auto and tls_client_certs in config.toml.")
+				continue
+			}
+			roleArg, name, _ := strings.Cut(strings.TrimSpace(args), " ")
+			role, err := auth.ParseRole(roleArg)
+			if err != nil || strings.TrimSpace(name) == "" {
+				fmt.Println("Usage: cert <viewer|worker|admin> <device name>")
+				continue
+			}
+			certs.printIssue(strings.TrimSpace(name), role)
+		case "help":
+			fmt.Println(consoleHelp)
+		default:
+			fmt.Println("Unknown command.")
+			fmt.Println(consoleHelp)
+		}
+	}
+}
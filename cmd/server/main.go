@@ -1,12 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	qrterminal "github.com/mdp/qrterminal/v3"
 
@@ -16,7 +22,10 @@ import (
 	"github.com/tafli/CallingParents/internal/config"
 	"github.com/tafli/CallingParents/internal/message"
 	"github.com/tafli/CallingParents/internal/network"
+	"github.com/tafli/CallingParents/internal/sanitize"
+	"github.com/tafli/CallingParents/internal/tlscert"
 	"github.com/tafli/CallingParents/internal/version"
+	"github.com/tafli/CallingParents/internal/wordfilter"
 )
 
 //go:embed all:web
@@ -25,6 +34,26 @@ var webFS embed.FS
 func main() {
 	log.Printf("calling-parents %s", version.Info())
 
+	// "migrate [config.toml]" copies the files into the database and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		configPath := "config.toml"
+		if len(os.Args) > 2 {
+			configPath = os.Args[2]
+		}
+		if err := runMigrate(configPath); err != nil {
+			log.Fatalf("migration failed: %v", err)
+		}
+		return
+	}
+
+	// "hash-pin" prints the admin_pin_hash for a PIN and exits.
+	if len(os.Args) > 1 && os.Args[1] == "hash-pin" {
+		if err := runHashPIN(); err != nil {
+			log.Fatalf("hash-pin failed: %v", err)
+		}
+		return
+	}
+
 	// Determine config file path: flag > default "config.toml".
 	configPath := "config.toml"
 	if len(os.Args) > 1 {
@@ -42,18 +71,69 @@ func main() {
 		log.Printf("Config updated: added new keys %v (backup: %s)", result.MergedKeys, result.BackupPath)
 	}
 
-	// Resolve auth token: use env var or generate a random one.
-	token := cfg.AuthToken
-	if token == "" {
-		var err error
-		token, err = auth.GenerateToken()
+	// Resolve auth token: use the configured one, the saved one, or
+	// generate a random one.
+	grace := time.Duration(cfg.TokenGraceMinutes) * time.Minute
+	var shared *auth.SharedToken
+	switch {
+	case cfg.AuthToken != "":
+		shared = auth.NewSharedToken(cfg.AuthToken, grace)
+	case cfg.TokenFile != "":
+		var created bool
+		shared, created, err = auth.LoadSharedToken(cfg.TokenFile, grace)
+		if err != nil {
+			log.Fatalf("failed to load auth token: %v", err)
+		}
+		if created {
+			log.Printf("Generated random auth token (saved to %s)", cfg.TokenFile)
+		}
+	default:
+		token, err := auth.GenerateToken()
 		if err != nil {
 			log.Fatalf("failed to generate auth token: %v", err)
 		}
-		log.Println("Generated random auth token (set auth_token in config.toml to use a fixed one)")
+		shared = auth.NewSharedToken(token, grace)
+		log.Println("Generated random auth token (set auth_token or token_file in config.toml to keep it across restarts)")
 	}
 
-	lanURL := network.LanURL(cfg.ListenAddr) + "#token=" + token
+	// HTTPS: the configured certificate, or one from a generated local CA.
+	certFile, keyFile := cfg.TLSCert, cfg.TLSKey
+	if (certFile == "") != (keyFile == "") {
+		log.Fatal("tls_cert and tls_key must be set together")
+	}
+	var localCA *tlscert.Local
+	if certFile == "" && cfg.TLSAuto {
+		hosts := tlsHosts()
+		localCA, err = tlscert.Ensure(cfg.TLSDir, hosts)
+		if err != nil {
+			log.Fatalf("failed to create TLS certificate: %v", err)
+		}
+		if localCA.Renewed {
+			log.Printf("Created server certificate for %v (%s)", hosts, cfg.TLSDir)
+		}
+		certFile, keyFile = localCA.CertFile, localCA.KeyFile
+	}
+	useTLS := certFile != ""
+
+	// Client certificates (mTLS) from the local CA, for fixed devices.
+	if cfg.TLSClientCerts && localCA == nil {
+		log.Println("Note: tls_client_certs is ignored without tls_auto (the local CA issues the certificates)")
+	}
+	if !useTLS && cfg.HTTPRedirectAddr != "" {
+		log.Println("Note: http_redirect_addr is ignored without HTTPS (set tls_cert and tls_key, or tls_auto)")
+	}
+
+	baseURL := network.LanURL(cfg.ListenAddr)
+	if useTLS {
+		baseURL = network.SecureLanURL(cfg.ListenAddr)
+	}
+	shared.SetBaseURL(baseURL)
+	shared.SetAnnouncer(func(url string) {
+		printQR("Token rotated. Open this URL on the phone:", url)
+		if cfg.AuthToken != "" {
+			log.Println("Note: the rotated token is not saved; a restart returns to auth_token from config.toml")
+		}
+	})
 
 	log.Printf("ProPresenter API: %s", cfg.ProPresenterURL())
 	log.Printf("Message template: %s", cfg.MessageName)
@@ -62,58 +142,183 @@ func main() {
 	} else {
 		log.Println("Auto-clear disabled")
 	}
-	log.Printf("Listening on %s", cfg.ListenAddr)
+	if useTLS {
+		log.Printf("Listening on %s (HTTPS)", cfg.ListenAddr)
+	} else {
+		log.Printf("Listening on %s", cfg.ListenAddr)
+	}
 
-	fmt.Println()
-	fmt.Println("Open this URL on the phone:")
-	fmt.Println(lanURL)
-	fmt.Println()
-	qrterminal.GenerateWithConfig(lanURL, qrterminal.Config{
-		Level:          qrterminal.L,
-		Writer:         os.Stdout,
-		HalfBlocks:     true,
-		BlackChar:      qrterminal.BLACK_BLACK,
-		WhiteBlackChar: qrterminal.WHITE_BLACK,
-		WhiteChar:      qrterminal.WHITE_WHITE,
-		BlackWhiteChar: qrterminal.BLACK_WHITE,
-		QuietZone:      1,
+	// Device registry: devices enrolled with their own, revocable tokens.
+	devices, err := auth.NewRegistry(cfg.DevicesFile)
+	if err != nil {
+		log.Fatalf("failed to load devices: %v", err)
+	}
+	devices.SetBaseURL(baseURL)
+	devices.SetAnnouncer(func(name, url string) {
+		printQR(fmt.Sprintf("Scan within %d minutes to enroll %q:", int(auth.EnrollmentTTL.Minutes()), name), url)
 	})
-	fmt.Println()
+	log.Printf("Enrolled devices: %d (%s)", len(devices.Devices()), cfg.DevicesFile)
+	var certs *certIssuer
+	if cfg.TLSClientCerts && localCA != nil {
+		certs = &certIssuer{ca: localCA, devices: devices, dir: filepath.Join(cfg.TLSDir, "clients")}
+		log.Printf("Client certificates enabled; issue them with \"cert <role> <name>\" (%s)", certs.dir)
+	}
+
+	if localCA != nil {
+		printCAInstall(localCA, baseURL, cfg.HTTPRedirectAddr)
+	}
+	printQR("Open this URL on the phone:", shared.URL())
+	printPairingCode(devices)
+
+	// Signer for expiring tokens, e.g. for visiting volunteers.
+	signer, created, err := auth.LoadSigner(cfg.SigningKeyFile)
+	if err != nil {
+		log.Fatalf("failed to load signing key: %v", err)
+	}
+	if created {
+		log.Printf("Generated signing key for expiring tokens: %s", cfg.SigningKeyFile)
+	}
+	signer.SetBaseURL(baseURL)
+
+	// Sessions: HttpOnly cookies the PWA gets in exchange for its token.
+	sessions, err := auth.NewSessions(cfg.SessionsFile, time.Duration(cfg.SessionDays)*24*time.Hour)
+	if err != nil {
+		log.Fatalf("failed to load sessions: %v", err)
+	}
+	log.Printf("Active sessions: %d (%s)", sessions.Len(), cfg.SessionsFile)
 
-	// Activity logger (optional)
-	var logger *activitylog.Logger
-	if cfg.ActivityLog != "" {
-		var err error
-		logger, err = activitylog.New(cfg.ActivityLog)
+	// Admin PIN: admin actions need a short-lived elevated token.
+	var elevator *auth.Elevator
+	if cfg.AdminPINHash != "" {
+		elevator, err = auth.NewElevator(cfg.AdminPINHash, signer, time.Duration(cfg.ElevationMinutes)*time.Minute)
 		if err != nil {
-			log.Fatalf("failed to open activity log: %v", err)
+			log.Fatalf("invalid admin_pin_hash: %v", err)
 		}
-		defer logger.Close()
+		log.Printf("Admin PIN enabled: admin actions stay unlocked for %d minutes", cfg.ElevationMinutes)
+	}
+
+	// Storage backends; the activity logger is optional with file storage.
+	store, err := openStorage(cfg)
+	if err != nil {
+		log.Fatalf("failed to open storage: %v", err)
+	}
+	defer store.Close()
+	logger := store.logger
+	if store.db != nil {
+		log.Printf("Storage: %s", cfg.StoragePath)
+	} else if logger != nil {
 		log.Printf("Activity log: %s", cfg.ActivityLog)
 	}
 
 	// Children store
-	childStore, err := children.NewStore(cfg.ChildrenFile)
+	childStore, err := children.NewStoreWithBackend(store.children)
 	if err != nil {
 		log.Fatalf("failed to load children: %v", err)
 	}
-	log.Printf("Loaded %d children from %s", len(childStore.Names()), cfg.ChildrenFile)
+	if err := childStore.SetLocale(cfg.Locale); err != nil {
+		log.Fatalf("failed to set locale: %v", err)
+	}
+	if err := childStore.SetPrivacyMode(cfg.PrivacyMode); err != nil {
+		log.Fatalf("failed to assign pickup codes: %v", err)
+	}
+	if cfg.PrivacyMode {
+		log.Printf("Privacy mode: pickup codes are shown instead of names")
+	}
+	// Names are cleaned the same way when stored and when sent.
+	sanitizer, err := sanitize.New(sanitize.Options{MaxLength: cfg.MaxDisplayLength, Charset: cfg.DisplayCharset})
+	if err != nil {
+		log.Fatalf("invalid display settings: %v", err)
+	}
+	childStore.SetSanitizer(sanitizer)
+	childStore.SetRetention(time.Duration(cfg.RetentionWeeks) * 7 * 24 * time.Hour)
+	log.Printf("Loaded %d children from %s", len(childStore.Names()), store.children)
+	if err := childStore.Watch(); err != nil {
+		log.Fatalf("failed to watch children file: %v", err)
+	}
+	defer childStore.Close()
+	// Save pending changes, such as batched last-call days, when stopped
+	// with Ctrl+C or by the service manager.
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		childStore.Close()
+		store.Close()
+		os.Exit(0)
+	}()
 
 	mux := http.NewServeMux()
 
 	// Version endpoint (no auth required)
 	mux.HandleFunc("/version", version.HandleVersion())
 
-	// Children endpoint
+	// Children endpoints: list/add/remove, rename, batch, search, file status, change events
+	childStore.SetLogger(logger)
 	mux.Handle("/children", childStore)
+	mux.HandleFunc("PATCH /children/{id}", childStore.HandleEdit)
+	mux.HandleFunc("POST /children/batch", childStore.HandleBatch)
+	mux.HandleFunc("GET /children/search", childStore.HandleSearch)
+	mux.HandleFunc("GET /children/status", childStore.HandleStatus)
+	mux.HandleFunc("GET /children/events", childStore.HandleEvents)
+	mux.HandleFunc("GET /children/families", childStore.HandleFamilies)
+	mux.HandleFunc("PUT /children/families/{id}", childStore.HandleSetFamily)
+	mux.HandleFunc("GET /children/codes", childStore.HandleCodes)
+	mux.HandleFunc("POST /children/{id}/erase", childStore.HandleErase)
+	mux.HandleFunc("GET /children/history", childStore.HandleHistory)
+	mux.HandleFunc("POST /children/undo", childStore.HandleUndo)
+	mux.HandleFunc("POST /children/restore", childStore.HandleRestore)
 
 	// Message endpoints: send, clear, test connection
 	msgHandler := message.New(cfg.ProPresenterURL(), cfg.MessageName, cfg.AutoClearSeconds, logger)
+	msgHandler.SetChildren(childStore)
+	msgHandler.SetSanitizer(sanitizer)
+	msgHandler.SetStrictNames(cfg.StrictNames)
+	if cfg.WordFilterDir != "" {
+		filter, err := wordfilter.Load(cfg.WordFilterDir)
+		if err != nil {
+			log.Fatalf("failed to load word filter: %v", err)
+		}
+		log.Printf("Word filter: %d rules from %v", filter.Len(), filter.Files())
+		msgHandler.SetWordFilter(filter)
+	}
+	if cfg.StrictNames {
+		log.Println("Strict names: only children from the list can be called")
+	}
 	mux.HandleFunc("/message/send", msgHandler.HandleSend)
+	mux.HandleFunc("POST /message/preview", msgHandler.HandlePreview)
+	mux.HandleFunc("POST /message/send-text", msgHandler.HandleSendText)
 	mux.HandleFunc("/message/clear", msgHandler.HandleClear)
 	mux.HandleFunc("/message/test", msgHandler.HandleTest)
 	mux.HandleFunc("/message/config", msgHandler.HandleConfig)
 
+	// Device enrollment: the admin issues codes, devices redeem them.
+	mux.HandleFunc("POST /auth/enrollments", devices.HandleCreateEnrollment)
+	mux.HandleFunc("POST /auth/enroll", devices.HandleEnroll)
+	mux.HandleFunc("GET /auth/devices", devices.HandleDevices)
+	mux.HandleFunc("DELETE /auth/devices/{id}", devices.HandleRevoke)
+	mux.HandleFunc("POST /auth/pairings", devices.HandleCreatePairing)
+	mux.HandleFunc("POST /auth/pair", devices.HandlePair)
+	mux.HandleFunc("GET /auth/me", auth.HandleMe)
+	mux.HandleFunc("POST /auth/token/rotate", shared.HandleRotate)
+	mux.HandleFunc("POST /auth/tokens", signer.HandleIssue)
+	mux.HandleFunc("POST /auth/session", sessions.HandleCreate)
+	mux.HandleFunc("DELETE /auth/session", sessions.HandleDelete)
+	if elevator != nil {
+		mux.HandleFunc("POST /auth/elevate", elevator.HandleElevate)
+	}
+
+	// Install page and download for the generated local CA.
+	if localCA != nil {
+		mux.HandleFunc("GET /ca", localCA.HandlePage)
+		mux.HandleFunc("GET /ca.crt", localCA.HandleCA)
+	}
+
+	// GDPR retention: prune old children and pseudonymise old log entries.
+	if cfg.RetentionWeeks > 0 || cfg.LogRetentionMonths > 0 {
+		log.Printf("Retention: children %d weeks, activity log %d months (0 = keep)", cfg.RetentionWeeks, cfg.LogRetentionMonths)
+		go runRetention(childStore, logger, cfg.LogRetentionMonths)
+	}
+
 	// Static PWA files
 	webContent, err := fs.Sub(webFS, "web")
 	if err != nil {
@@ -121,11 +326,200 @@ func main() {
 	}
 	mux.Handle("/", http.FileServer(http.FS(webContent)))
 
-	// Wrap mux with auth middleware: protect /message/ and /children.
-	protectedPrefixes := []string{"/message/", "/children"}
-	handler := auth.Middleware(token, protectedPrefixes)(mux)
+	// Brute-force protection: lock out addresses after failed logins, also
+	// with wrong enrollment or pairing codes or admin PINs, and rate limit
+	// each token.
+	rates, err := auth.ParseRateLimits(cfg.RateLimits)
+	if err != nil {
+		log.Fatalf("invalid rate_limits: %v", err)
+	}
+	if len(rates) > 0 {
+		log.Printf("Rate limits per token: %s", cfg.RateLimits)
+	}
+	limiter := auth.NewLimiter(auth.Limits{
+		LockoutAttempts: cfg.LockoutAttempts,
+		Rates:           rates,
+		CodePaths:       []string{"/auth/enroll", "/auth/pair", "/auth/elevate"},
+		OnLockout: func(addr string, until time.Time) {
+			log.Printf("WARNING: %s locked out until %s after %d failed logins", addr, until.Format(time.TimeOnly), cfg.LockoutAttempts)
+			logger.LogLockout(addr, until)
+		},
+	})
+
+	// Wrap mux with auth middleware: every route in permissions needs a
+	// token or session cookie with at least the listed role.
+	creds := auth.Credentials{Shared: shared, AdminToken: cfg.AdminToken, Devices: devices, Signer: signer, Sessions: sessions, Elevator: elevator}
+	handler := auth.Middleware(creds, permissions, limiter)(mux)
 
-	if err := http.ListenAndServe(cfg.ListenAddr, handler); err != nil {
+	// Commands typed into the terminal, e.g. "rotate".
+	go runConsole(os.Stdin, shared, devices, certs)
+
+	if !useTLS {
+		if err := http.ListenAndServe(cfg.ListenAddr, handler); err != nil {
+			log.Fatalf("server error: %v", err)
+		}
+		return
+	}
+	if cfg.HTTPRedirectAddr != "" {
+		_, port, _ := net.SplitHostPort(cfg.ListenAddr)
+		log.Printf("Redirecting HTTP on %s to HTTPS", cfg.HTTPRedirectAddr)
+		go func() {
+			if err := http.ListenAndServe(cfg.HTTPRedirectAddr, tlscert.Redirect(port, localCA)); err != nil {
+				log.Fatalf("HTTP redirect server error: %v", err)
+			}
+		}()
+	}
+	// The certificate is reloaded when its files change, so a renewed one
+	// is used without a restart.
+	reloader, err := tlscert.NewReloader(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS certificate: %v", err)
+	}
+	if localCA != nil {
+		go renewCertificate(cfg.TLSDir)
+	}
+	server := &http.Server{Addr: cfg.ListenAddr, Handler: handler, TLSConfig: &tls.Config{GetCertificate: reloader.GetCertificate}}
+	if certs != nil {
+		// Ask for a certificate, but let phones without one use tokens.
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		server.TLSConfig.ClientCAs = localCA.ClientCAs()
+	}
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+// permissions maps routes to the role they need. The most specific match
+// wins; paths not listed (the PWA shell, /version, /auth/enroll, /auth/pair,
+// /ca) are public.
+var permissions = []auth.Permission{
+	// Status, for viewers such as a lobby display.
+	{Method: http.MethodGet, Prefix: "/message/test", Role: auth.RoleViewer},
+	{Method: http.MethodGet, Prefix: "/message/config", Role: auth.RoleViewer},
+	{Method: http.MethodGet, Prefix: "/auth/me", Role: auth.RoleViewer},
+	// A session is exchanged for a token; a certificate is already ambient.
+	{Prefix: "/auth/session", Role: auth.RoleViewer, Auth: auth.AuthBearer},
+	{Prefix: "/auth/elevate", Role: auth.RoleWorker},
+
+	// Calling parents and reading the list.
+	{Prefix: "/message/", Role: auth.RoleWorker},
+	{Method: http.MethodGet, Prefix: "/children", Role: auth.RoleWorker},
+
+	// Free text, list changes and device management. With an admin PIN,
+	// these also need an elevated token.
+	{Prefix: "/message/send-text", Role: auth.RoleAdmin},
+	{Prefix: "/children", Role: auth.RoleAdmin},
+	// The history holds every name ever on the list, including removed
+	// children, so reading it is not part of reading the list.
+	{Prefix: "/children/history", Role: auth.RoleAdmin},
+	// The status shows the storage backend and file paths, for admins.
+	{Method: http.MethodGet, Prefix: "/children/status", Role: auth.RoleAdmin},
+	{Prefix: "/auth/enrollments", Role: auth.RoleAdmin},
+	{Prefix: "/auth/pairings", Role: auth.RoleAdmin},
+	{Prefix: "/auth/devices", Role: auth.RoleAdmin},
+	{Prefix: "/auth/token", Role: auth.RoleAdmin},
+}
+
+// printQR prints a URL and its QR code on the terminal.
+func printQR(title, url string) {
+	fmt.Println()
+	fmt.Println(title)
+	fmt.Println(url)
+	fmt.Println()
+	qrterminal.GenerateWithConfig(url, qrterminal.Config{
+		Level:          qrterminal.L,
+		Writer:         os.Stdout,
+		HalfBlocks:     true,
+		BlackChar:      qrterminal.BLACK_BLACK,
+		WhiteBlackChar: qrterminal.WHITE_BLACK,
+		WhiteChar:      qrterminal.WHITE_WHITE,
+		BlackWhiteChar: qrterminal.BLACK_WHITE,
+		QuietZone:      1,
+	})
+	fmt.Println()
+}
+
+// printCAInstall prints where to install the local CA, and its fingerprint.
+// The install page is on the HTTP listener if there is one, since phones do
+// not trust the HTTPS server yet.
+func printCAInstall(ca *tlscert.Local, baseURL, redirectAddr string) {
+	url := baseURL + "/ca"
+	if redirectAddr != "" {
+		url = network.LanURL(redirectAddr) + "/ca"
+	}
+	printQR("Install the certificate on each phone once:", url)
+	fmt.Printf("CA fingerprint (SHA-256): %s\n\n", ca.Fingerprint)
+}
+
+// tlsHosts returns the names the generated server certificate is for: the
+// LAN addresses, localhost and the host name.
+func tlsHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	for _, ip := range network.LanIPs() {
+		hosts = append(hosts, ip.String())
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name, name+".local")
+	}
+	return hosts
+}
+
+// printPairingCode issues a worker pairing code and prints it below the QR
+// code, for devices that cannot scan it.
+func printPairingCode(devices *auth.Registry) {
+	code, expires, err := devices.Pair("", auth.RoleWorker)
+	if err != nil {
+		log.Printf("WARNING: creating pairing code: %v", err)
+		return
+	}
+	fmt.Printf("No camera? Enter pairing code %s on the device (valid until %s; type \"pair\" for a new code).\n\n",
+		auth.FormatPairingCode(code), expires.Format("15:04"))
+}
+
+// certRenewInterval is how often renewCertificate checks the local server
+// certificate.
+const certRenewInterval = 24 * time.Hour
+
+// renewCertificate renews the server certificate from the local CA once a
+// day if it expires soon or the LAN addresses changed. The server's
+// tlscert.Reloader picks up the new files.
+func renewCertificate(dir string) {
+	for {
+		time.Sleep(certRenewInterval)
+		hosts := tlsHosts()
+		l, err := tlscert.Ensure(dir, hosts)
+		if err != nil {
+			log.Printf("WARNING: renewing TLS certificate: %v", err)
+		} else if l.Renewed {
+			log.Printf("Renewed server certificate for %v (%s)", hosts, dir)
+		}
+	}
+}
+
+// retentionInterval is how often runRetention applies the retention policy.
+const retentionInterval = 24 * time.Hour
+
+// runRetention applies the retention policy at startup and then once a day.
+// Names are not logged, since the point is to forget them.
+func runRetention(store *children.Store, logger *activitylog.Logger, logMonths int) {
+	for {
+		now := time.Now()
+		removed, err := store.Prune(now)
+		if err != nil {
+			log.Printf("WARNING: retention: %v", err)
+		} else if len(removed) > 0 {
+			log.Printf("Retention: removed %d children not called within the retention period", len(removed))
+		}
+
+		if logMonths > 0 {
+			n, err := logger.Pseudonymise(now.AddDate(0, -logMonths, 0))
+			if err != nil {
+				log.Printf("WARNING: retention: %v", err)
+			} else if n > 0 {
+				log.Printf("Retention: pseudonymised %d activity log entries", n)
+			}
+		}
+
+		time.Sleep(retentionInterval)
+	}
+}
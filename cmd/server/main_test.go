@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+func TestPermissions(t *testing.T) {
+	t.Parallel()
+
+	creds := auth.Credentials{Shared: auth.NewSharedToken("worker", 0), AdminToken: "admin"}
+	h := auth.Middleware(creds, permissions, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{"worker reads the list", http.MethodGet, "/children", "worker", http.StatusOK},
+		{"worker reads the status", http.MethodGet, "/children/status", "worker", http.StatusForbidden},
+		{"admin reads the status", http.MethodGet, "/children/status", "admin", http.StatusOK},
+		{"worker reads the history", http.MethodGet, "/children/history", "worker", http.StatusForbidden},
+		{"worker changes the list", http.MethodPost, "/children", "worker", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Errorf("expected %d, got %d", tc.wantCode, rec.Code)
+			}
+		})
+	}
+}
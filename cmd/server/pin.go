@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+// runHashPIN implements "calling-parents hash-pin": it reads an admin PIN
+// and prints the admin_pin_hash line for config.toml. On a terminal the PIN
+// is read twice without echo; otherwise it is the first line of stdin.
+func runHashPIN() error {
+	pin, err := readPIN("Admin PIN: ")
+	if err != nil {
+		return err
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		again, err := readPIN("Repeat PIN: ")
+		if err != nil {
+			return err
+		}
+		if again != pin {
+			return errors.New("the PINs do not match")
+		}
+	}
+	hash, err := auth.HashPIN(pin)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Add this line to config.toml:")
+	fmt.Printf("admin_pin_hash = %q\n", hash)
+	return nil
+}
+
+// readPIN reads a line from stdin, without echo on a terminal.
+func readPIN(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading PIN: %w", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading PIN: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+	"github.com/tafli/CallingParents/internal/children"
+	"github.com/tafli/CallingParents/internal/config"
+)
+
+// storage holds the backends selected by the storage config key.
+type storage struct {
+	children children.Backend
+	// logger is nil when activity logging is disabled.
+	logger *activitylog.Logger
+	db     *bolt.DB
+}
+
+// openBolt opens the bbolt database at path, creating it if needed.
+func openBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// openStorage opens the backends for cfg.Storage. With "file", the activity
+// log is only written if activity_log is set; with "bolt" it is always kept
+// in the database.
+func openStorage(cfg config.Config) (*storage, error) {
+	switch cfg.Storage {
+	case "", "file":
+		s := &storage{children: children.NewFileBackend(cfg.ChildrenFile)}
+		if cfg.ActivityLog != "" {
+			logger, err := activitylog.New(cfg.ActivityLog)
+			if err != nil {
+				return nil, fmt.Errorf("opening activity log: %w", err)
+			}
+			s.logger = logger
+		}
+		return s, nil
+	case "bolt":
+		db, err := openBolt(cfg.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		logBackend, err := activitylog.NewBoltBackend(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		return &storage{
+			children: children.NewBoltBackend(db),
+			logger:   activitylog.NewWithBackend(logBackend),
+			db:       db,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q (want \"file\" or \"bolt\")", cfg.Storage)
+	}
+}
+
+// Close closes the activity log and the database.
+func (s *storage) Close() error {
+	err := s.logger.Close()
+	if s.db != nil {
+		err = errors.Join(err, s.db.Close())
+	}
+	return err
+}
+
+// runMigrate implements "calling-parents migrate [config.toml]": it copies
+// children_file and activity_log into the database at storage_path. The
+// children list in the database is replaced; the activity log is only
+// copied into an empty database so running the command twice does not
+// duplicate entries.
+func runMigrate(configPath string) error {
+	cfg, _, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	db, err := openBolt(cfg.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := children.Migrate(children.NewFileBackend(cfg.ChildrenFile), children.NewBoltBackend(db))
+	if err != nil {
+		return fmt.Errorf("migrating children: %w", err)
+	}
+	log.Printf("Copied %d children from %s to %s", n, cfg.ChildrenFile, cfg.StoragePath)
+
+	if cfg.ActivityLog == "" {
+		log.Printf("No activity_log configured, skipping activity log")
+		return nil
+	}
+	if _, err := os.Stat(cfg.ActivityLog); os.IsNotExist(err) {
+		log.Printf("Activity log %s does not exist, skipping", cfg.ActivityLog)
+		return nil
+	}
+
+	to, err := activitylog.NewBoltBackend(db)
+	if err != nil {
+		return err
+	}
+	// errStop ends the scan at the first entry; any other error means the
+	// database could not be read.
+	errStop := errors.New("stop")
+	empty := true
+	err = to.Each(func(activitylog.Entry) error {
+		empty = false
+		return errStop
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return fmt.Errorf("reading activity entries: %w", err)
+	}
+	if !empty {
+		return fmt.Errorf("database %s already contains activity entries; not copying %s again", cfg.StoragePath, cfg.ActivityLog)
+	}
+
+	from, err := activitylog.NewFileBackend(cfg.ActivityLog)
+	if err != nil {
+		return fmt.Errorf("opening activity log: %w", err)
+	}
+	defer from.Close()
+	n, err = activitylog.Migrate(from, to)
+	if err != nil {
+		return fmt.Errorf("migrating activity log: %w", err)
+	}
+	log.Printf("Copied %d activity entries from %s to %s", n, cfg.ActivityLog, cfg.StoragePath)
+	log.Printf("Set storage = \"bolt\" in %s to use the database", configPath)
+	return nil
+}
@@ -1,6 +1,8 @@
 // === Storage Keys ===
 const STORAGE_CHILDREN = "calling_parents_children";
 const STORAGE_TOKEN = "calling_parents_token";
+// Why the token was rejected, kept across the reload that follows a 401.
+const STORAGE_AUTH_REASON = "calling_parents_auth_reason";
 
 // === State ===
 let children = [];
@@ -11,43 +13,186 @@ let autoClearTimer = null;
 let countdownTimer = null;
 let countdownRemaining = 0;
 let isConnected = false;
+let childrenETag = "";
+let searchResults = null;
+let searchTimer = null;
+let families = [];
+let selectedFamily = null;
+let previewTimer = null;
+let pickupCodes = {};
+let userRole = "worker";
+// A session cookie authenticates requests instead of authToken.
+let sessionActive = false;
+// Admin PIN: admin actions need a short-lived elevated token, kept in
+// memory only so a reload locks them again.
+let pinRequired = false;
+let elevatedToken = "";
+let elevationTimer = null;
 
 // === Auth Token ===
 // Extract token from URL hash fragment (#token=...) and persist in localStorage.
-function initToken() {
+// An enrollment link (#enroll=...) is exchanged for this device's own token.
+async function initToken() {
     const hash = window.location.hash;
     if (hash.startsWith("#token=")) {
         authToken = hash.substring(7);
         localStorage.setItem(STORAGE_TOKEN, authToken);
         // Remove token from URL bar so it's not visible/shared accidentally.
         history.replaceState(null, "", window.location.pathname);
+    } else if (hash.startsWith("#enroll=")) {
+        // The code works once, so remove it before anything else.
+        history.replaceState(null, "", window.location.pathname);
+        await enrollDevice(hash.substring(8));
     } else {
         authToken = localStorage.getItem(STORAGE_TOKEN) || "";
     }
 }
 
-// Build headers object with auth token included.
+// Redeem a one-time enrollment code. On failure the previous token, if any,
+// is kept.
+async function enrollDevice(code) {
+    authToken = localStorage.getItem(STORAGE_TOKEN) || "";
+    try {
+        const resp = await fetch("/auth/enroll", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ code }),
+        });
+        if (!resp.ok) {
+            showToast(t("toast.enrollFailed"), "error");
+            return;
+        }
+        const data = await resp.json();
+        authToken = data.token;
+        localStorage.setItem(STORAGE_TOKEN, authToken);
+        showToast(t("toast.enrolled", { name: data.device.name }), "success");
+    } catch {
+        showToast(t("toast.enrollFailed"), "error");
+    }
+}
+
+// Redeem a pairing code typed in on a device without a camera. On success
+// the app starts with the new device token.
+async function pairDevice(e) {
+    e.preventDefault();
+    const code = inputPairCode.value.replace(/[\s-]/g, "");
+    if (!/^\d{6}$/.test(code)) {
+        showToast(t("pair.invalid"), "error");
+        return;
+    }
+    try {
+        const resp = await fetch("/auth/pair", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ code, name: inputPairName.value.trim() }),
+        });
+        if (!resp.ok) {
+            showToast(t(resp.status === 429 ? "pair.locked" : "pair.invalid"), "error");
+            inputPairCode.value = "";
+            return;
+        }
+        const data = await resp.json();
+        authToken = data.token;
+        localStorage.setItem(STORAGE_TOKEN, authToken);
+        await startSession();
+        document.getElementById("auth-error").classList.add("hidden");
+        showToast(t("toast.enrolled", { name: data.device.name }), "success");
+        await startApp();
+    } catch {
+        showToast(t("toast.serverUnreachable"), "error");
+    }
+}
+
+// Exchange the token for an HttpOnly session cookie, which scripts cannot
+// read, and forget the token once the cookie works. Browsers only keep the
+// Secure cookie in a secure context (HTTPS); elsewhere the token is kept.
+async function startSession() {
+    if (!window.isSecureContext) return;
+    try {
+        const resp = await fetch("/auth/session", { method: "POST", headers: authHeaders() });
+        if (!resp.ok || !(await hasSession())) return;
+        localStorage.removeItem(STORAGE_TOKEN);
+        authToken = "";
+        sessionActive = true;
+    } catch {
+        // Keep using the token.
+    }
+}
+
+// Check whether the browser has a working session cookie.
+async function hasSession() {
+    try {
+        const resp = await fetch("/auth/me");
+        return resp.ok;
+    } catch {
+        return false;
+    }
+}
+
+// Build headers object with auth token included. While admin actions are
+// unlocked, the elevated token is sent instead.
 function authHeaders(extra = {}) {
     const headers = { ...extra };
-    if (authToken) {
-        headers["Authorization"] = "Bearer " + authToken;
+    const token = elevatedToken || authToken;
+    if (token) {
+        headers["Authorization"] = "Bearer " + token;
     }
     return headers;
 }
 
 // Wrapper around fetch that handles 401 by clearing credentials and reloading.
+// An ended session is cleared by the server.
+// A token that only works at certain times is kept, but the app is locked.
+// An expired elevated token only locks the admin actions again.
 async function authFetch(url, options = {}) {
     const resp = await fetch(url, options);
+    const reason = resp.headers.get("X-Auth-Error");
+    if (resp.status === 401 && elevatedToken && options.headers?.Authorization === "Bearer " + elevatedToken) {
+        showToast(t("toast.pinExpired"), "error");
+        await lockAdmin();
+        return new Promise(() => {});
+    }
+    if (resp.status === 403 && reason === "elevation_required") {
+        showToast(t("toast.pinRequired"), "error");
+        await lockAdmin();
+        return new Promise(() => {});
+    }
     if (resp.status === 401) {
         localStorage.removeItem(STORAGE_TOKEN);
         authToken = "";
+        if (reason) sessionStorage.setItem(STORAGE_AUTH_REASON, reason);
         window.location.reload();
         // Return a never-resolving promise so callers don't continue.
         return new Promise(() => {});
     }
+    if (resp.status === 403 && reason === "outside_window") {
+        showAuthError(reason);
+        return new Promise(() => {});
+    }
     return resp;
 }
 
+// Show the full-screen auth overlay. reason is "expired", "outside_window"
+// or empty for a missing or wrong token.
+const AUTH_ERROR_KEYS = {
+    expired: ["auth.expiredTitle", "auth.expiredMessage"],
+    outside_window: ["auth.outsideWindowTitle", "auth.outsideWindowMessage"],
+};
+
+function showAuthError(reason) {
+    const [titleKey, messageKey] = AUTH_ERROR_KEYS[reason] || ["auth.title", "auth.message"];
+    const overlay = document.getElementById("auth-error");
+    const title = overlay.querySelector("h2");
+    const message = overlay.querySelector("p");
+    title.dataset.i18n = titleKey;
+    title.textContent = t(titleKey);
+    message.dataset.i18n = messageKey;
+    message.textContent = t(messageKey);
+    // A token that only works at other times is kept, so no pairing.
+    pairForm.classList.toggle("hidden", reason === "outside_window");
+    overlay.classList.remove("hidden");
+}
+
 // === DOM Elements ===
 const viewMain = document.getElementById("view-main");
 const viewSettings = document.getElementById("view-settings");
@@ -63,30 +208,62 @@ const inputAddChild = document.getElementById("input-add-child");
 const btnAddChild = document.getElementById("btn-add-child");
 const childrenList = document.getElementById("children-list");
 const btnReloadChildren = document.getElementById("btn-reload-children");
+const btnUndoChildren = document.getElementById("btn-undo-children");
 const toast = document.getElementById("toast");
 const headerTitle = document.getElementById("header-title");
 const statusDot = document.getElementById("status-dot");
 const btnClearInput = document.getElementById("btn-clear-input");
+const sendPreview = document.getElementById("send-preview");
+const pairForm = document.getElementById("pair-form");
+const inputPairCode = document.getElementById("input-pair-code");
+const inputPairName = document.getElementById("input-pair-name");
+const btnPairingCode = document.getElementById("btn-pairing-code");
+const pairingCode = document.getElementById("pairing-code");
+const pinForm = document.getElementById("pin-form");
+const inputPin = document.getElementById("input-pin");
+const pinStatus = document.getElementById("pin-status");
+const btnPinLock = document.getElementById("btn-pin-lock");
 
 // === Initialization ===
 async function init() {
     await initI18n();
     applyI18nToDOM();
-    initToken();
+    await initToken();
+    if (authToken) {
+        await startSession();
+    } else {
+        sessionActive = window.isSecureContext && (await hasSession());
+    }
 
-    // If no token is available, show auth error and block all interaction.
-    if (!authToken) {
-        document.getElementById("auth-error").classList.remove("hidden");
+    // If neither a token nor a session is available, show auth error and
+    // block all interaction until the device is paired.
+    if (!authToken && !sessionActive) {
+        showAuthError(sessionStorage.getItem(STORAGE_AUTH_REASON));
+        sessionStorage.removeItem(STORAGE_AUTH_REASON);
+        pairForm.addEventListener("submit", pairDevice);
         return;
     }
 
+    await startApp();
+}
+
+// Start the app once a token is available.
+async function startApp() {
+    await fetchRole();
+
     loadData();
     renderChildrenGrid();
     renderChildrenList();
     renderLanguagePicker();
 
-    // Fetch server-side children list, then merge
-    fetchServerChildren();
+    // Viewers cannot read the list.
+    if (userRole !== "viewer") {
+        // Fetch server-side children list, then merge
+        fetchServerChildren();
+
+        // Merge again whenever the server-side list changes
+        subscribeChildrenEvents();
+    }
 
     // Fetch server config (auto-clear timer)
     fetchConfig();
@@ -105,7 +282,14 @@ async function init() {
     btnTestConnection.addEventListener("click", testConnection);
     btnAddChild.addEventListener("click", addChild);
     btnReloadChildren.addEventListener("click", reloadChildren);
-    inputName.addEventListener("input", onNameInput);
+    btnUndoChildren.addEventListener("click", undoChildrenChange);
+    btnPairingCode.addEventListener("click", createPairingCode);
+    pinForm.addEventListener("submit", unlockAdmin);
+    btnPinLock.addEventListener("click", lockAdmin);
+    inputName.addEventListener("input", () => {
+        onNameInput();
+        scheduleSearch();
+    });
     btnClearInput.addEventListener("click", () => {
         inputName.value = "";
         onNameInput();
@@ -137,10 +321,16 @@ function saveChildren() {
 // === Server Children Sync ===
 async function fetchServerChildren() {
     try {
+        const headers = childrenETag ? { "If-None-Match": childrenETag } : {};
         const resp = await authFetch("/children", {
-            headers: authHeaders(),
+            headers: authHeaders(headers),
         });
+        // 304: the server list has not changed since the last fetch.
         if (!resp.ok) return;
+        childrenETag = resp.headers.get("ETag") || "";
+
+        fetchFamilies();
+        fetchPickupCodes();
 
         const serverNames = await resp.json();
         if (!Array.isArray(serverNames) || serverNames.length === 0) return;
@@ -164,6 +354,68 @@ async function fetchServerChildren() {
     }
 }
 
+// Families group siblings so their parents can be called with one message.
+async function fetchFamilies() {
+    try {
+        const resp = await authFetch("/children/families", {
+            headers: authHeaders(),
+        });
+        if (!resp.ok) return;
+        const list = await resp.json();
+        if (!Array.isArray(list)) return;
+        families = list;
+        renderChildrenGrid();
+    } catch (_) {
+        // Offline or server unreachable — keep known families
+    }
+}
+
+// Pickup codes of private children. The screen shows the code; workers see
+// it next to the name so they can match the two.
+async function fetchPickupCodes() {
+    try {
+        const resp = await authFetch("/children/codes", {
+            headers: authHeaders(),
+        });
+        if (!resp.ok) return;
+        const codes = await resp.json();
+        if (!codes || typeof codes !== "object") return;
+        pickupCodes = codes;
+        renderChildrenGrid();
+        renderChildrenList();
+    } catch (_) {
+        // Offline or server unreachable — keep known codes
+    }
+}
+
+// Listen for server-side list changes. Uses fetch instead of EventSource
+// because EventSource cannot send the Authorization header.
+async function subscribeChildrenEvents() {
+    try {
+        const resp = await authFetch("/children/events", {
+            headers: authHeaders(),
+        });
+        if (!resp.ok || !resp.body) throw new Error(`HTTP ${resp.status}`);
+
+        const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+        let buffer = "";
+        for (;;) {
+            const { value, done } = await reader.read();
+            if (done) break;
+            buffer += value;
+            let end;
+            while ((end = buffer.indexOf("\n\n")) >= 0) {
+                const block = buffer.slice(0, end);
+                buffer = buffer.slice(end + 2);
+                if (block.startsWith("event: children")) fetchServerChildren();
+            }
+        }
+    } catch (_) {
+        // Offline or server unreachable — retry below
+    }
+    setTimeout(subscribeChildrenEvents, 5000);
+}
+
 // Full replace of local list with server list.
 async function reloadChildren() {
     try {
@@ -180,6 +432,7 @@ async function reloadChildren() {
             showToast(t("toast.serverInvalidResponse"), "error");
             return;
         }
+        childrenETag = resp.headers.get("ETag") || "";
 
         children = serverNames;
         saveChildren();
@@ -191,6 +444,104 @@ async function reloadChildren() {
     }
 }
 
+// Revert the most recent change to the server list (e.g. an accidental
+// delete). Repeated taps step further back through the history.
+async function undoChildrenChange() {
+    try {
+        const resp = await authFetch("/children/undo", {
+            method: "POST",
+            headers: authHeaders(),
+        });
+        if (resp.status === 404) {
+            showToast(t("toast.nothingToUndo"), "error");
+            return;
+        }
+        if (!resp.ok) {
+            showToast(t("toast.undoFailed"), "error");
+            return;
+        }
+        childrenETag = resp.headers.get("ETag") || "";
+        const serverNames = await resp.json();
+        if (Array.isArray(serverNames)) {
+            children = serverNames;
+            saveChildren();
+            renderChildrenGrid();
+            renderChildrenList();
+        }
+        showToast(t("toast.undone"), "success");
+    } catch (_) {
+        showToast(t("toast.serverUnreachable"), "error");
+    }
+}
+
+// === Device Pairing (admin) ===
+// Show a short-lived code that a device without a camera can type in.
+async function createPairingCode() {
+    try {
+        const resp = await authFetch("/auth/pairings", {
+            method: "POST",
+            headers: authHeaders(),
+        });
+        if (!resp.ok) {
+            showToast(t("toast.sendFailed", { error: await resp.text() }), "error");
+            return;
+        }
+        const data = await resp.json();
+        const until = new Date(data.expires).toLocaleTimeString(currentLang, { hour: "2-digit", minute: "2-digit" });
+        pairingCode.textContent = data.code.slice(0, 3) + " " + data.code.slice(3);
+        const hint = document.createElement("small");
+        hint.textContent = t("settings.pairValidUntil", { time: until });
+        pairingCode.appendChild(hint);
+        pairingCode.classList.remove("hidden");
+    } catch (_) {
+        showToast(t("toast.serverUnreachable"), "error");
+    }
+}
+
+// === Admin PIN ===
+// Exchange the admin PIN for an elevated token that unlocks admin actions
+// until it expires.
+async function unlockAdmin(e) {
+    e.preventDefault();
+    const pin = inputPin.value.trim();
+    if (!pin) return;
+    try {
+        // Plain fetch: a wrong PIN answers 401, which must not log out.
+        const resp = await fetch("/auth/elevate", {
+            method: "POST",
+            headers: authHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify({ pin }),
+        });
+        if (resp.status === 429) {
+            showToast(t("toast.pinLocked"), "error");
+            return;
+        }
+        if (!resp.ok) {
+            showToast(t("toast.pinWrong"), "error");
+            return;
+        }
+        const data = await resp.json();
+        inputPin.value = "";
+        elevatedToken = data.token;
+        const expires = new Date(data.expires);
+        clearTimeout(elevationTimer);
+        elevationTimer = setTimeout(lockAdmin, expires - Date.now());
+        const until = expires.toLocaleTimeString(currentLang, { hour: "2-digit", minute: "2-digit" });
+        pinStatus.textContent = t("settings.pinUnlockedUntil", { time: until });
+        await fetchRole();
+        showToast(t("toast.pinUnlocked"), "success");
+    } catch (_) {
+        showToast(t("toast.serverUnreachable"), "error");
+    }
+}
+
+// Forget the elevated token, e.g. when it expires.
+async function lockAdmin() {
+    clearTimeout(elevationTimer);
+    elevatedToken = "";
+    await fetchRole();
+}
+
 // === View Switching ===
 function showSettings() {
     viewMain.classList.add("hidden");
@@ -210,44 +561,142 @@ function showMain() {
 // === Children Grid (Main View) ===
 function renderChildrenGrid() {
     childrenGrid.innerHTML = "";
-    if (children.length === 0) {
+    const names = searchResults ?? children;
+    if (names.length === 0) {
         const empty = document.createElement("div");
         empty.className = "children-grid-empty";
-        empty.textContent = t("grid.empty");
+        empty.textContent = searchResults ? t("grid.noMatches") : t("grid.empty");
         childrenGrid.appendChild(empty);
         return;
     }
-    children.forEach((name) => {
+    if (!searchResults) {
+        families.forEach((family) => {
+            const btn = document.createElement("button");
+            btn.className = "child-btn family-btn";
+            btn.textContent = family.display;
+            btn.dataset.name = family.display;
+            btn.addEventListener("click", () => selectChild(family.display, family.id));
+            childrenGrid.appendChild(btn);
+        });
+    }
+    names.forEach((name) => {
         const btn = document.createElement("button");
         btn.className = "child-btn";
         btn.textContent = name;
+        btn.dataset.name = name;
+        if (pickupCodes[name]) {
+            const code = document.createElement("span");
+            code.className = "pickup-code";
+            code.textContent = pickupCodes[name];
+            btn.appendChild(code);
+        }
         btn.addEventListener("click", () => selectChild(name));
         childrenGrid.appendChild(btn);
     });
 }
 
-function selectChild(name) {
+function selectChild(name, familyId = null) {
     inputName.value = name;
     onNameInput();
+    selectedFamily = familyId ? { id: familyId, display: name } : null;
 
     // Highlight the selected button
     document.querySelectorAll(".child-btn").forEach((btn) => {
-        btn.classList.toggle("selected", btn.textContent === name);
+        btn.classList.toggle("selected", btn.dataset.name === name);
     });
 }
 
 function onNameInput() {
     const hasText = !!inputName.value.trim();
+    if (selectedFamily && inputName.value !== selectedFamily.display) {
+        selectedFamily = null;
+    }
+    if (!hasText && searchResults) {
+        searchResults = null;
+        renderChildrenGrid();
+    }
     btnSend.disabled = !hasText || !isConnected;
     btnClearInput.classList.toggle("hidden", !hasText);
+    schedulePreview();
 
     // Update button highlights based on current input
     const currentName = inputName.value.trim();
     document.querySelectorAll(".child-btn").forEach((btn) => {
-        btn.classList.toggle("selected", btn.textContent === currentName);
+        btn.classList.toggle("selected", btn.dataset.name === currentName);
     });
 }
 
+// === Preview ===
+// Show the exact wording that will appear on screen ("Eltern von Paul"),
+// rendered by the server from the ProPresenter template.
+function schedulePreview() {
+    clearTimeout(previewTimer);
+    if (!inputName.value.trim()) {
+        sendPreview.classList.add("hidden");
+        return;
+    }
+    previewTimer = setTimeout(fetchPreview, 300);
+}
+
+async function fetchPreview() {
+    const name = inputName.value.trim();
+    if (!name) return;
+    const payload = selectedFamily ? { family: selectedFamily.id } : { name };
+    try {
+        const resp = await authFetch("/message/preview", {
+            method: "POST",
+            headers: authHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify(payload),
+        });
+        // The input may have changed while the request was running.
+        if (!resp.ok || inputName.value.trim() !== name) return;
+        const preview = await resp.json();
+        const warnings = (preview.warnings || []).map((w) =>
+            t(`preview.${w.code}`, { token: w.detail || "" }));
+        sendPreview.textContent = [t("preview.label", { text: preview.text }), ...warnings].join(" · ");
+        sendPreview.classList.toggle("warning", warnings.length > 0);
+        sendPreview.classList.remove("hidden");
+    } catch (_) {
+        // The preview is optional; sending still works without it.
+    }
+}
+
+// === Search ===
+// Filter the grid while typing. The server ranks fuzzy and diacritic-insensitive
+// matches; names that only exist locally are matched by substring.
+function scheduleSearch() {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(searchChildren, 150);
+}
+
+async function searchChildren() {
+    const query = inputName.value.trim();
+    if (!query) return;
+
+    const lower = query.toLocaleLowerCase(currentLang);
+    const localMatches = children.filter((n) => n.toLocaleLowerCase(currentLang).includes(lower));
+    let results = localMatches;
+    try {
+        const resp = await authFetch("/children/search?q=" + encodeURIComponent(query) + "&limit=50", {
+            headers: authHeaders(),
+        });
+        if (resp.ok) {
+            const serverMatches = await resp.json();
+            if (Array.isArray(serverMatches)) {
+                results = serverMatches.concat(localMatches.filter((n) => !serverMatches.includes(n)));
+            }
+        }
+    } catch (_) {
+        // Offline — local matches only
+    }
+
+    // Ignore results for a query the worker has already changed.
+    if (inputName.value.trim() !== query) return;
+    searchResults = results;
+    renderChildrenGrid();
+    onNameInput();
+}
+
 // === Children List (Settings View) ===
 function renderChildrenList() {
     childrenList.innerHTML = "";
@@ -256,6 +705,15 @@ function renderChildrenList() {
 
         const span = document.createElement("span");
         span.textContent = name;
+        span.className = "child-name";
+        span.title = t("aria.renameChild", { name });
+        span.addEventListener("click", () => renameChild(index));
+
+        const privateBtn = document.createElement("button");
+        privateBtn.className = "btn-private";
+        privateBtn.textContent = pickupCodes[name] ? "🔒 " + pickupCodes[name] : "🔓";
+        privateBtn.setAttribute("aria-label", t("aria.togglePrivate", { name }));
+        privateBtn.addEventListener("click", () => togglePrivate(name));
 
         const removeBtn = document.createElement("button");
         removeBtn.className = "btn-remove";
@@ -264,15 +722,21 @@ function renderChildrenList() {
         removeBtn.addEventListener("click", () => removeChild(index));
 
         li.appendChild(span);
+        li.appendChild(privateBtn);
         li.appendChild(removeBtn);
         childrenList.appendChild(li);
     });
 }
 
+// Same duplicate rule as the server: ignore case, spacing and Unicode form.
+function hasChild(name) {
+    return children.some((c) => c.localeCompare(name, currentLang, { sensitivity: "accent" }) === 0);
+}
+
 function addChild() {
-    const name = inputAddChild.value.trim();
+    const name = inputAddChild.value.trim().replace(/\s+/g, " ").normalize("NFC");
     if (!name) return;
-    if (children.includes(name)) {
+    if (hasChild(name)) {
         showToast(t("toast.childExists", { name }), "error");
         return;
     }
@@ -288,23 +752,102 @@ function addChild() {
         method: "POST",
         headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({ name }),
+    }).then(async (resp) => {
+        if (!resp.ok) return;
+        childrenETag = resp.headers.get("ETag") || "";
+        if (!resp.headers.get("X-Sanitized")) return;
+        // The server stored a cleaned-up name (e.g. without emoji); swap the
+        // typed name for the stored one.
+        const serverNames = await resp.json();
+        if (!Array.isArray(serverNames)) return;
+        children = children.filter((n) => n !== name);
+        for (const n of serverNames) {
+            if (!children.includes(n)) children.push(n);
+        }
+        saveChildren();
+        renderChildrenList();
+        renderChildrenGrid();
+        showToast(t("toast.nameCleaned"), "success");
     }).catch(() => {
         // Server sync is best-effort; localStorage is the primary store.
     });
 }
 
+// Rename keeps the child's history: the server records the rename in the
+// activity log instead of a delete and a new add.
+function renameChild(index) {
+    if (userRole !== "admin") return;
+    const oldName = children[index];
+    const input = prompt(t("settings.renamePrompt", { name: oldName }), oldName);
+    if (input === null) return;
+    const newName = input.trim();
+    if (!newName || newName === oldName) return;
+    if (children.includes(newName)) {
+        showToast(t("toast.childExists", { name: newName }), "error");
+        return;
+    }
+
+    children[index] = newName;
+    saveChildren();
+    renderChildrenList();
+
+    authFetch("/children/" + encodeURIComponent(oldName), {
+        method: "PATCH",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ name: newName }),
+    }).then((resp) => {
+        if (resp.ok) childrenETag = resp.headers.get("ETag") || "";
+    }).catch(() => {
+        // Server sync is best-effort.
+    });
+}
+
+// Private children are shown on screen by their pickup code. The server
+// assigns the code, so the list is refreshed afterwards.
+async function togglePrivate(name) {
+    try {
+        const resp = await authFetch("/children/" + encodeURIComponent(name), {
+            method: "PATCH",
+            headers: authHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify({ private: !pickupCodes[name] }),
+        });
+        if (!resp.ok) throw new Error(`HTTP ${resp.status}`);
+        childrenETag = resp.headers.get("ETag") || "";
+        await fetchPickupCodes();
+    } catch (_) {
+        showToast(t("toast.serverUnreachable"), "error");
+    }
+}
+
 function removeChild(index) {
     const name = children[index];
     children.splice(index, 1);
     saveChildren();
     renderChildrenList();
 
-    // Sync deletion to server (fire-and-forget).
+    // Sync deletion to server. If-Match makes the server reject the delete
+    // when another device changed the list since we last saw it.
     if (name) {
+        const headers = { "Content-Type": "application/json" };
+        if (childrenETag) headers["If-Match"] = childrenETag;
         authFetch("/children", {
             method: "DELETE",
-            headers: authHeaders({ "Content-Type": "application/json" }),
+            headers: authHeaders(headers),
             body: JSON.stringify({ name }),
+        }).then(async (resp) => {
+            if (resp.status === 409) {
+                childrenETag = resp.headers.get("ETag") || "";
+                const serverNames = await resp.json();
+                if (Array.isArray(serverNames)) {
+                    children = serverNames;
+                    saveChildren();
+                    renderChildrenGrid();
+                    renderChildrenList();
+                }
+                showToast(t("toast.childrenConflict"), "error");
+                return;
+            }
+            if (resp.ok) childrenETag = resp.headers.get("ETag") || "";
         }).catch(() => {
             // Server sync is best-effort.
         });
@@ -318,20 +861,53 @@ async function sendMessage() {
 
     btnSend.disabled = true;
 
+    // A selected family is sent by ID so each child is logged on the server.
+    const payload = selectedFamily ? { family: selectedFamily.id } : { name };
+
     try {
         const resp = await authFetch("/message/send", {
             method: "POST",
             headers: authHeaders({ "Content-Type": "application/json" }),
-            body: JSON.stringify({ name }),
+            body: JSON.stringify(payload),
         });
 
+        if (resp.status === 422) {
+            // Strict mode: the name is not in the children list.
+            showToast(t("toast.unknownChild", { name }), "error");
+            showStatus(t("status.sendFailed"), "error");
+            return;
+        }
+        if (resp.status === 429) {
+            // Rate limit: this token sent too many messages in a short time.
+            const seconds = resp.headers.get("Retry-After") || "?";
+            showToast(t("toast.rateLimited", { seconds }), "error");
+            showStatus(t("status.sendFailed"), "error");
+            return;
+        }
+        if (resp.status === 403) {
+            // Blocked by the word filter; an admin can still send it.
+            showToast(t("toast.blocked"), "error");
+            showStatus(t("status.sendFailed"), "error");
+            return;
+        }
         if (!resp.ok && resp.status !== 204) {
             throw new Error(`HTTP ${resp.status}`);
         }
 
+        // The server reports the text that went on screen after cleaning
+        // (emoji removed, length limit, font charset).
+        const result = resp.status === 204 ? {} : await resp.json().catch(() => ({}));
+        const onScreen = result.text || name;
+        const changed = Array.isArray(result.changes) && result.changes.length > 0;
+
         activeMessage = true;
-        showStatus(t("status.showing", { name }), "active");
-        showToast(t("toast.sent", { name }), "success");
+        const shown = !selectedFamily && pickupCodes[name] ? `${pickupCodes[name]} (${name})` : onScreen;
+        showStatus(t("status.showing", { name: shown }), "active");
+        if (changed) {
+            showToast(t("toast.sentAs", { name: onScreen }), "success");
+        } else {
+            showToast(t("toast.sent", { name }), "success");
+        }
 
         // Haptic feedback
         if (navigator.vibrate) navigator.vibrate(100);
@@ -420,6 +996,27 @@ function showToast(message, type) {
 }
 
 // === Server Config ===
+// Ask the server what this token may do. Only admins can change the list;
+// viewers can only see the connection status. With an admin PIN, admins
+// must enter it first.
+async function fetchRole() {
+    try {
+        const resp = await authFetch("/auth/me", { headers: authHeaders() });
+        if (resp.ok) {
+            const me = await resp.json();
+            userRole = me.role || "worker";
+            pinRequired = !!me.pin;
+            if (pinRequired && !me.elevated && userRole === "admin") userRole = "worker";
+        }
+    } catch (_) {
+        // Keep the default; the server enforces the role anyway.
+    }
+    document.body.classList.toggle("read-only", userRole !== "admin");
+    document.body.classList.toggle("viewer", userRole === "viewer");
+    document.body.classList.toggle("pin-locked", pinRequired && !elevatedToken && userRole === "worker");
+    document.body.classList.toggle("pin-unlocked", pinRequired && !!elevatedToken);
+}
+
 async function fetchConfig() {
     try {
         const resp = await authFetch("/message/config", {
@@ -1,4 +1,4 @@
-const CACHE_NAME = "calling-parents-v9";
+const CACHE_NAME = "calling-parents-v26";
 const ASSETS = ["/", "/index.html", "/css/style.css", "/js/i18n.js", "/js/app.js", "/manifest.json", "/lang/de.json", "/lang/en.json"];
 
 // Install: cache app shell
@@ -28,7 +28,7 @@ self.addEventListener("fetch", (event) => {
     const url = new URL(event.request.url);
 
     // API calls: always go to network (they need the server to be reachable)
-    if (url.pathname.startsWith("/message/") || url.pathname.startsWith("/children")) {
+    if (url.pathname.startsWith("/message/") || url.pathname.startsWith("/children") || url.pathname.startsWith("/auth/")) {
         event.respondWith(fetch(event.request));
         return;
     }
@@ -5,7 +5,9 @@ go 1.25.0
 require (
 	github.com/BurntSushi/toml v1.6.0
 	github.com/mdp/qrterminal/v3 v3.2.1
-	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/term v0.13.0 // indirect
-	rsc.io/qr v0.2.0 // indirect
+	go.etcd.io/bbolt v1.5.0
+	golang.org/x/sys v0.45.0
+	golang.org/x/term v0.13.0
+	golang.org/x/text v0.30.0
+	rsc.io/qr v0.2.0
 )
@@ -1,8 +1,6 @@
 package activitylog
 
 import (
-	"encoding/json"
-	"os"
 	"sync"
 	"time"
 )
@@ -12,42 +10,84 @@ type Entry struct {
 	Time   string `json:"time"`
 	Action string `json:"action"`
 	Name   string `json:"name,omitempty"`
+	// NewName is set for "rename" entries so reports can follow a child
+	// across name changes.
+	NewName string `json:"newName,omitempty"`
+	// Code is the pickup code shown on screen instead of the name when the
+	// child is private.
+	Code string `json:"code,omitempty"`
+	// Device names the enrolled device or client address that made the
+	// request.
+	Device string `json:"device,omitempty"`
+	// Reason is the rule that rejected the text, e.g. "de.txt:12", or when
+	// a lockout ends.
+	Reason string `json:"reason,omitempty"`
 }
 
-// Logger appends activity entries as JSON lines to a file.
-// It is safe for concurrent use.
+// Logger appends activity entries to a Backend, by default as JSON lines to
+// a file. It is safe for concurrent use.
 type Logger struct {
-	mu   sync.Mutex
-	file *os.File
+	mu      sync.Mutex
+	backend Backend
 }
 
 // New opens (or creates) the log file for appending and returns a Logger.
 func New(path string) (*Logger, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	b, err := NewFileBackend(path)
 	if err != nil {
 		return nil, err
 	}
-	return &Logger{file: f}, nil
+	return NewWithBackend(b), nil
+}
+
+// NewWithBackend returns a Logger that writes to the given backend. Closing
+// the Logger closes the backend.
+func NewWithBackend(b Backend) *Logger {
+	return &Logger{backend: b}
 }
 
 // Log writes a timestamped entry to the log file.
 func (l *Logger) Log(action, name string) {
+	l.write(Entry{Action: action, Name: name})
+}
+
+// LogSend records that the parents of a child were called. code is the
+// pickup code shown instead of the name, or empty if the name was shown.
+// device identifies the sender.
+func (l *Logger) LogSend(name, code, device string) {
+	l.write(Entry{Action: "send", Name: name, Code: code, Device: device})
+}
+
+// LogClear records that device cleared the message.
+func (l *Logger) LogClear(device string) {
+	l.write(Entry{Action: "clear", Device: device})
+}
+
+// LogRejected records that text was blocked before it reached the screen.
+// device identifies the sender and reason the rule that matched.
+func (l *Logger) LogRejected(text, device, reason string) {
+	l.write(Entry{Action: "rejected", Name: text, Device: device, Reason: reason})
+}
+
+// LogRename records that device renamed a child from oldName to newName.
+func (l *Logger) LogRename(oldName, newName, device string) {
+	l.write(Entry{Action: "rename", Name: oldName, NewName: newName, Device: device})
+}
+
+// LogLockout records that a client address was locked out after too many
+// failed logins, until the given time.
+func (l *Logger) LogLockout(addr string, until time.Time) {
+	l.write(Entry{Action: "lockout", Device: addr, Reason: "locked until " + until.Format(time.RFC3339)})
+}
+
+func (l *Logger) write(e Entry) {
 	if l == nil {
 		return
 	}
-	e := Entry{
-		Time:   time.Now().Format(time.RFC3339),
-		Action: action,
-		Name:   name,
-	}
+	e.Time = time.Now().Format(time.RFC3339)
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	data, err := json.Marshal(e)
-	if err != nil {
-		return
-	}
-	data = append(data, '\n')
-	l.file.Write(data)
+	l.backend.Append(e)
 }
 
 // Close closes the underlying file.
@@ -55,5 +95,5 @@ func (l *Logger) Close() error {
 	if l == nil {
 		return nil
 	}
-	return l.file.Close()
+	return l.backend.Close()
 }
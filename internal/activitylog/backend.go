@@ -0,0 +1,373 @@
+package activitylog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// Backend stores activity entries in the order they were logged. Logger
+// serialises all calls, so implementations need not be safe for concurrent
+// use.
+type Backend interface {
+	// Append adds an entry at the end.
+	Append(e Entry) error
+	// AppendAll adds entries at the end in one write, for Migrate.
+	AppendAll(entries []Entry) error
+	// Each calls fn for every entry in order and stops at the first error.
+	Each(fn func(Entry) error) error
+	// Rewrite applies fn to every entry; fn returns false to drop the entry.
+	// It returns the number of entries changed or dropped.
+	Rewrite(fn func(e *Entry) (keep bool)) (int, error)
+	// PseudonymKey returns the secret key for pseudonyms, creating it on
+	// first use. It is kept with the log, so a name gets the same pseudonym
+	// in every run.
+	PseudonymKey() ([]byte, error)
+	// SetPseudonymKey replaces the key, so Migrate can keep pseudonyms
+	// stable across backends.
+	SetPseudonymKey(key []byte) error
+	// Close releases the backend.
+	Close() error
+}
+
+// FileBackend appends entries as JSON lines to a file.
+type FileBackend struct {
+	path string
+	file *os.File
+}
+
+// NewFileBackend opens (or creates) the JSONL file at path for appending.
+func NewFileBackend(path string) (*FileBackend, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileBackend{path: path, file: f}, nil
+}
+
+// Append writes e as one JSON line.
+func (b *FileBackend) Append(e Entry) error {
+	return b.AppendAll([]Entry{e})
+}
+
+// AppendAll writes entries as JSON lines in a single write.
+func (b *FileBackend) AppendAll(entries []Entry) error {
+	var data []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	_, err := b.file.Write(data)
+	return err
+}
+
+// Each reads the file and calls fn for every line that is a valid entry.
+func (b *FileBackend) Each(fn func(Entry) error) error {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var e Entry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite replaces the file with the rewritten entries. Lines that are not
+// valid entries are kept unchanged. The file is replaced atomically and
+// reopened for appending.
+func (b *FileBackend) Rewrite(fn func(e *Entry) (keep bool)) (int, error) {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return 0, fmt.Errorf("reading activity log: %w", err)
+	}
+
+	var out bytes.Buffer
+	changed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			out.Write(line)
+			out.WriteByte('\n')
+			continue
+		}
+		before := e
+		if !fn(&e) {
+			changed++
+			continue
+		}
+		if e != before {
+			changed++
+			line, _ = json.Marshal(e)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("reading activity log: %w", err)
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if err := atomicfile.WriteFile(b.path, out.Bytes(), 0644); err != nil {
+		return 0, fmt.Errorf("rewriting activity log: %w", err)
+	}
+
+	f, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("reopening activity log: %w", err)
+	}
+	b.file.Close()
+	b.file = f
+	return changed, nil
+}
+
+// keyPath returns the path of the pseudonym key kept next to the log file.
+func (b *FileBackend) keyPath() string {
+	return b.path + ".key"
+}
+
+// PseudonymKey reads the key from the file next to the log, or creates it,
+// readable only by the owner.
+func (b *FileBackend) PseudonymKey() ([]byte, error) {
+	data, err := os.ReadFile(b.keyPath())
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid pseudonym key in %s", b.keyPath())
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.SetPseudonymKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SetPseudonymKey writes key to the file next to the log, readable only by
+// the owner. It is written atomically, so a crash cannot leave a truncated
+// key behind.
+func (b *FileBackend) SetPseudonymKey(key []byte) error {
+	return atomicfile.WriteFile(b.keyPath(), []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+// Close closes the file.
+func (b *FileBackend) Close() error {
+	return b.file.Close()
+}
+
+// boltActivity is the bucket BoltBackend stores entries in.
+var boltActivity = []byte("activity")
+
+// boltActivityKey is the bucket BoltBackend keeps the pseudonym key in,
+// under boltPseudonymKey.
+var (
+	boltActivityKey  = []byte("activity-key")
+	boltPseudonymKey = []byte("pseudonym")
+)
+
+// BoltBackend stores entries in a bbolt database, keyed by a big-endian
+// sequence number so iteration follows logging order.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend returns a Backend that uses the open database db. Close does
+// not close db; the caller owns it.
+func NewBoltBackend(db *bolt.DB) (*BoltBackend, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltActivity)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating activity bucket: %w", err)
+	}
+	return &BoltBackend{db: db}, nil
+}
+
+// Append stores e under the next sequence number.
+func (b *BoltBackend) Append(e Entry) error {
+	return b.AppendAll([]Entry{e})
+}
+
+// AppendAll stores entries under the next sequence numbers in a single
+// transaction, so a large migration is written with one sync.
+func (b *BoltBackend) AppendAll(entries []Entry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltActivity)
+		for _, e := range entries {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(binary.BigEndian.AppendUint64(nil, seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Each calls fn for every entry in logging order.
+func (b *BoltBackend) Each(fn func(Entry) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltActivity).ForEach(func(_, v []byte) error {
+			var e Entry
+			if json.Unmarshal(v, &e) != nil {
+				return nil
+			}
+			return fn(e)
+		})
+	})
+}
+
+// Rewrite updates or deletes entries in a single transaction.
+func (b *BoltBackend) Rewrite(fn func(e *Entry) (keep bool)) (int, error) {
+	changed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltActivity)
+		// Collect the changes first: modifying a bucket while iterating
+		// over it can skip keys.
+		updates := make(map[string][]byte)
+		var deletes [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var e Entry
+			if json.Unmarshal(v, &e) != nil {
+				return nil
+			}
+			before := e
+			if !fn(&e) {
+				deletes = append(deletes, bytes.Clone(k))
+				return nil
+			}
+			if e == before {
+				return nil
+			}
+			out, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = out
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range deletes {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, v := range updates {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		changed = len(deletes) + len(updates)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("rewriting activity log: %w", err)
+	}
+	return changed, nil
+}
+
+// PseudonymKey reads the key from the database, or creates it.
+func (b *BoltBackend) PseudonymKey() ([]byte, error) {
+	var key []byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(boltActivityKey)
+		if err != nil {
+			return err
+		}
+		if v := bucket.Get(boltPseudonymKey); v != nil {
+			key = bytes.Clone(v)
+			return nil
+		}
+		if key, err = newKey(); err != nil {
+			return err
+		}
+		return bucket.Put(boltPseudonymKey, key)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading pseudonym key: %w", err)
+	}
+	return key, nil
+}
+
+// SetPseudonymKey stores key in the database, replacing any existing key.
+func (b *BoltBackend) SetPseudonymKey(key []byte) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(boltActivityKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltPseudonymKey, key)
+	})
+	if err != nil {
+		return fmt.Errorf("storing pseudonym key: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op; the database is closed by its owner.
+func (b *BoltBackend) Close() error { return nil }
+
+// Migrate copies the pseudonym key of from to to, so names keep their
+// pseudonyms, then appends every entry of from to to in one write, keeping
+// the original times. It returns the number of entries copied.
+func Migrate(from, to Backend) (int, error) {
+	key, err := from.PseudonymKey()
+	if err != nil {
+		return 0, err
+	}
+	if err := to.SetPseudonymKey(key); err != nil {
+		return 0, err
+	}
+
+	var entries []Entry
+	err = from.Each(func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := to.AppendAll(entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,109 @@
+package activitylog
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltBackendLogger(t *testing.T) {
+	t.Parallel()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	defer db.Close()
+
+	b, err := NewBoltBackend(db)
+	if err != nil {
+		t.Fatalf("NewBoltBackend() error: %v", err)
+	}
+	logger := NewWithBackend(b)
+	logger.LogSend("Anna", "", "")
+	logger.LogRename("Anna", "Anna Lena", "")
+	logger.LogSend("Ben", "", "")
+
+	if n, err := logger.Erase("Anna Lena"); err != nil || n != 2 {
+		t.Fatalf("Erase() = %d, %v", n, err)
+	}
+
+	var names []string
+	b.Each(func(e Entry) error {
+		names = append(names, e.Name)
+		return nil
+	})
+	if len(names) != 1 || names[0] != "Ben" {
+		t.Errorf("unexpected remaining entries %v", names)
+	}
+}
+
+func TestMigrateFileToBolt(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	from, err := NewFileBackend(filepath.Join(dir, "activity.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileBackend() error: %v", err)
+	}
+	defer from.Close()
+	stamp := time.Date(2025, 1, 5, 10, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	from.Append(Entry{Time: stamp, Action: "send", Name: "Anna"})
+	from.Append(Entry{Time: stamp, Action: "clear"})
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	defer db.Close()
+	to, err := NewBoltBackend(db)
+	if err != nil {
+		t.Fatalf("NewBoltBackend() error: %v", err)
+	}
+
+	n, err := Migrate(from, to)
+	if err != nil || n != 2 {
+		t.Fatalf("Migrate() = %d, %v", n, err)
+	}
+	var entries []Entry
+	to.Each(func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if len(entries) != 2 || entries[0].Name != "Anna" || entries[0].Time != stamp {
+		t.Errorf("unexpected migrated entries %+v", entries)
+	}
+
+	// Pseudonyms stay the same after the switch.
+	fromKey, _ := from.PseudonymKey()
+	toKey, err := to.PseudonymKey()
+	if err != nil || !bytes.Equal(toKey, fromKey) {
+		t.Errorf("migrated key %x, %v; want %x", toKey, err, fromKey)
+	}
+}
+
+func TestBoltBackendPseudonymKey(t *testing.T) {
+	t.Parallel()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	defer db.Close()
+	b, err := NewBoltBackend(db)
+	if err != nil {
+		t.Fatalf("NewBoltBackend() error: %v", err)
+	}
+
+	key, err := b.PseudonymKey()
+	if err != nil || len(key) != keySize {
+		t.Fatalf("PseudonymKey() = %x, %v", key, err)
+	}
+	again, err := b.PseudonymKey()
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("expected the stored key, got %x, %v", again, err)
+	}
+}
@@ -0,0 +1,139 @@
+package activitylog
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pseudonymPrefix marks names that were replaced by Pseudonymise.
+const pseudonymPrefix = "anon-"
+
+// rewrite applies fn to every entry in the backend; see Backend.Rewrite.
+func (l *Logger) rewrite(fn func(e *Entry) (keep bool)) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.backend.Rewrite(fn)
+}
+
+// keySize is the length of the pseudonym key in bytes.
+const keySize = 32
+
+// newKey returns a random pseudonym key.
+func newKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating pseudonym key: %w", err)
+	}
+	return key, nil
+}
+
+// Pseudonymise replaces the names and pickup codes in entries logged before
+// the cutoff with pseudonyms, so old entries can still be counted and linked
+// to each other but no longer identify a child. The pseudonyms are keyed
+// HMACs; the key is kept with the log (see Backend.PseudonymKey), so a
+// child gets the same pseudonym in every run. It returns the number of
+// entries changed.
+func (l *Logger) Pseudonymise(before time.Time) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	key, err := l.backend.PseudonymKey()
+	l.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	pseudonym := func(name string) string {
+		if name == "" || strings.HasPrefix(name, pseudonymPrefix) {
+			return name
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(strings.ToLower(name)))
+		return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:6])
+	}
+
+	return l.rewrite(func(e *Entry) bool {
+		at, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil || !at.Before(before) {
+			return true
+		}
+		e.Name = pseudonym(e.Name)
+		e.NewName = pseudonym(e.NewName)
+		e.Code = ""
+		return true
+	})
+}
+
+// Erase removes every entry that mentions one of the names. Renames are
+// followed, so erasing a child's current name also removes the entries
+// logged under its earlier names. Names are compared case-insensitively.
+// It returns the number of entries removed.
+func (l *Logger) Erase(names ...string) (int, error) {
+	if l == nil || len(names) == 0 {
+		return 0, nil
+	}
+
+	linked, err := l.linkedNames(names)
+	if err != nil {
+		return 0, err
+	}
+	return l.rewrite(func(e *Entry) bool {
+		return !linked[strings.ToLower(e.Name)] && !linked[strings.ToLower(e.NewName)]
+	})
+}
+
+// LinkedNames returns name, lower-cased, and every name connected to it
+// through rename entries in the log, so a child's earlier names can be
+// erased elsewhere too. A nil Logger knows no renames.
+func (l *Logger) LinkedNames(name string) ([]string, error) {
+	if l == nil {
+		return []string{strings.ToLower(name)}, nil
+	}
+	linked, err := l.linkedNames([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(linked)), nil
+}
+
+// linkedNames returns the lower-cased names plus every name connected to
+// them through rename entries in the log.
+func (l *Logger) linkedNames(names []string) (map[string]bool, error) {
+	var renames [][2]string
+	l.mu.Lock()
+	err := l.backend.Each(func(e Entry) error {
+		if e.Action == "rename" {
+			renames = append(renames, [2]string{strings.ToLower(e.Name), strings.ToLower(e.NewName)})
+		}
+		return nil
+	})
+	l.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("reading activity log: %w", err)
+	}
+
+	linked := make(map[string]bool, len(names))
+	for _, name := range names {
+		linked[strings.ToLower(name)] = true
+	}
+	// Repeat until no new names are found; rename chains are short.
+	for grown := true; grown; {
+		grown = false
+		for _, r := range renames {
+			if linked[r[0]] != linked[r[1]] {
+				linked[r[0]], linked[r[1]] = true, true
+				grown = true
+			}
+		}
+	}
+	delete(linked, "")
+	return linked, nil
+}
@@ -0,0 +1,150 @@
+package activitylog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	var entries []Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("failed to parse %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestPseudonymiseOldEntries(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "activity.jsonl")
+	old := time.Now().AddDate(-1, 0, 0).Format(time.RFC3339)
+	os.WriteFile(path, []byte(
+		`{"time":"`+old+`","action":"send","name":"Anna","code":"K7M"}`+"\n"+
+			`{"time":"`+old+`","action":"send","name":"Anna"}`+"\n"), 0644)
+
+	logger, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.LogSend("Ben", "", "")
+
+	n, err := logger.Pseudonymise(time.Now().AddDate(0, -6, 0))
+	if err != nil {
+		t.Fatalf("Pseudonymise() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 changed entries, got %d", n)
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if !strings.HasPrefix(entries[0].Name, pseudonymPrefix) || entries[0].Code != "" {
+		t.Errorf("old entry not pseudonymised: %+v", entries[0])
+	}
+	if entries[0].Name != entries[1].Name {
+		t.Errorf("the same name must get the same pseudonym: %q != %q", entries[0].Name, entries[1].Name)
+	}
+	if entries[2].Name != "Ben" {
+		t.Errorf("recent entry must keep its name, got %q", entries[2].Name)
+	}
+
+	// The logger keeps appending to the rewritten file.
+	logger.Log("clear", "")
+	if entries := readEntries(t, path); len(entries) != 4 {
+		t.Errorf("expected 4 entries after appending, got %d", len(entries))
+	}
+}
+
+func TestPseudonymsStableAcrossRuns(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "activity.jsonl")
+	old := `{"time":"` + time.Now().AddDate(-1, 0, 0).Format(time.RFC3339) + `","action":"send","name":"Anna"}` + "\n"
+	os.WriteFile(path, []byte(old), 0644)
+
+	for run := 0; run < 2; run++ {
+		if run > 0 {
+			// An entry that only became old by the second run.
+			f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			f.WriteString(old)
+			f.Close()
+		}
+		logger, err := New(path)
+		if err != nil {
+			t.Fatalf("failed to create logger: %v", err)
+		}
+		if _, err := logger.Pseudonymise(time.Now()); err != nil {
+			t.Fatalf("run %d: Pseudonymise() error: %v", run, err)
+		}
+		logger.Close()
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 || entries[0].Name != entries[1].Name || !strings.HasPrefix(entries[0].Name, pseudonymPrefix) {
+		t.Errorf("expected the same pseudonym in both runs, got %+v", entries)
+	}
+	info, err := os.Stat(path + ".key")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("pseudonym key: %v, mode %v", err, info.Mode().Perm())
+	}
+}
+
+func TestEraseFollowsRenames(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "activity.jsonl")
+	logger, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	logger.LogSend("Jurgen", "", "")
+	logger.LogRename("Jurgen", "Jürgen", "")
+	logger.LogSend("Jürgen", "", "")
+	logger.LogSend("Ben", "", "")
+	logger.Log("clear", "")
+
+	n, err := logger.Erase("jürgen")
+	if err != nil {
+		t.Fatalf("Erase() error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 removed entries, got %d", n)
+	}
+	entries := readEntries(t, path)
+	if len(entries) != 2 || entries[0].Name != "Ben" || entries[1].Action != "clear" {
+		t.Errorf("unexpected remaining entries %+v", entries)
+	}
+}
+
+func TestRetentionNilLoggerIsSafe(t *testing.T) {
+	t.Parallel()
+
+	var logger *Logger
+	if n, err := logger.Erase("Anna"); n != 0 || err != nil {
+		t.Errorf("Erase() on nil logger = %d, %v", n, err)
+	}
+	if n, err := logger.Pseudonymise(time.Now()); n != 0 || err != nil {
+		t.Errorf("Pseudonymise() on nil logger = %d, %v", n, err)
+	}
+}
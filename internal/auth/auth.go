@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // GenerateToken creates a cryptographically random 32-byte hex token.
@@ -18,35 +22,300 @@ func GenerateToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// Middleware returns an HTTP middleware that validates a Bearer token.
-// Requests to paths not starting with any of the protected prefixes are
-// passed through without authentication (e.g. static PWA files).
-func Middleware(token string, protectedPrefixes []string) func(http.Handler) http.Handler {
+// Credentials are the tokens the middleware accepts and the roles they
+// grant.
+type Credentials struct {
+	// Shared is the token from the QR code; it grants RoleWorker.
+	Shared *SharedToken
+	// AdminToken grants RoleAdmin, as a Bearer token or in AdminHeader.
+	// Empty disables it.
+	AdminToken string
+	// Devices holds the enrolled devices, each with its own role. Nil
+	// accepts no device tokens or client certificates.
+	Devices *Registry
+	// Signer verifies expiring signed tokens. Nil accepts none.
+	Signer *Signer
+	// Sessions backs session cookies, used when a request has no token.
+	// Nil accepts no cookies.
+	Sessions *Sessions
+	// Elevator, if set, makes routes that need RoleAdmin also need a recent
+	// admin PIN entry; see Elevator.
+	Elevator *Elevator
+}
+
+// errNoCredentials is returned by identify for a request without a token or
+// session cookie.
+var errNoCredentials = errors.New("no credentials")
+
+// errCertRequired is returned by identify for a request without a client
+// certificate to a route that only accepts one.
+var errCertRequired = errors.New("client certificate required")
+
+// AdminHeader can carry the admin token for API clients that also send the
+// shared token in Authorization.
+const AdminHeader = "X-Admin-Token"
+
+// ErrorHeader tells the PWA why a valid-looking token was rejected, so it
+// can tell an expired token from a wrong one.
+const ErrorHeader = "X-Auth-Error"
+
+// Values of ErrorHeader.
+const (
+	// errorExpired: the signed token has expired (401); scan a new one.
+	errorExpired = "expired"
+	// errorOutsideWindow: the signed token only works at other times (403);
+	// keep it.
+	errorOutsideWindow = "outside_window"
+)
+
+// Middleware returns an HTTP middleware that enforces the permission table.
+// Requests to paths no permission matches are passed through without
+// authentication (e.g. static PWA files). Other requests without a valid
+// token get 401; requests whose token's role is too low, or whose signed
+// token is used outside its time windows, get 403. The caller's identity is
+// stored in the request context; see FromContext.
+//
+// Requests authenticated by session cookie or client certificate that change
+// something must come from the PWA's own origin (Origin header), or they get
+// 403; the browser sends both by itself, so this stops other sites from
+// using them (CSRF).
+//
+// Over HTTPS, a verified client certificate of a device from
+// EnrollCertificate counts like a token of that device, so every protected
+// route accepts either mTLS or a Bearer token.
+//
+// With creds.Elevator set, requests to routes that need RoleAdmin get 403
+// with ErrorHeader "elevation_required" unless they carry an elevated token
+// or the admin token.
+//
+// limiter, if not nil, locks out addresses after failed logins and rate
+// limits each token; both get 429 with Retry-After.
+func Middleware(creds Credentials, perms []Permission, limiter *Limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !isProtected(r.URL.Path, protectedPrefixes) {
-				next.ServeHTTP(w, r)
+			addr := clientAddr(r)
+			perm, ok := required(perms, r.Method, r.URL.Path)
+			if !ok {
+				if !limiter.isCodePath(r.URL.Path) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if wait := limiter.codeLockedFor(r.URL.Path, addr); wait > 0 {
+					tooManyRequests(w, "too many failed logins", wait)
+					return
+				}
+				serveCodePath(next, w, r, limiter, addr)
 				return
 			}
 
-			provided := extractBearerToken(r)
-			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			if wait := limiter.lockedFor(addr); wait > 0 {
+				tooManyRequests(w, "too many failed logins", wait)
+				return
+			}
+			need := perm.Role
+			id, err := creds.identify(r, perm.Auth)
+			switch {
+			case errors.Is(err, ErrTokenExpired):
+				w.Header().Set(ErrorHeader, errorExpired)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case errors.Is(err, ErrOutsideWindow):
+				w.Header().Set(ErrorHeader, errorOutsideWindow)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case errors.Is(err, errInvalidSession):
+				clearSessionCookie(w)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			case errors.Is(err, errNoCredentials):
+				// Not a guess, so not a failed login.
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			case errors.Is(err, errCertRequired):
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			case err != nil:
+				limiter.fail(addr)
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-
+			// Code paths check their own secret; the recorder below decides.
+			codePath := limiter.isCodePath(r.URL.Path)
+			if !codePath {
+				limiter.succeed(addr)
+			}
+			if id.ambient && !safeMethod(r.Method) && !sameOrigin(r) {
+				http.Error(w, "forbidden: cross-origin request", http.StatusForbidden)
+				return
+			}
+			if !id.Role.Allows(need) {
+				http.Error(w, fmt.Sprintf("forbidden: requires role %s", need), http.StatusForbidden)
+				return
+			}
+			id.pin = creds.Elevator != nil
+			if need == RoleAdmin && id.pin && !id.Elevated {
+				w.Header().Set(ErrorHeader, errorElevation)
+				http.Error(w, "forbidden: enter the admin PIN", http.StatusForbidden)
+				return
+			}
+			if ok, wait := limiter.allow(id.cred.key(), r.URL.Path); !ok {
+				tooManyRequests(w, "rate limit exceeded", wait)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+			if codePath {
+				if wait := limiter.codeLockedFor(r.URL.Path, addr); wait > 0 {
+					tooManyRequests(w, "too many wrong codes", wait)
+					return
+				}
+				serveCodePath(next, w, r, limiter, addr)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func isProtected(path string, prefixes []string) bool {
-	for _, p := range prefixes {
-		if strings.HasPrefix(path, p) {
-			return true
+// serveCodePath serves a request to one of the limiter's code paths and
+// counts a 401 or 429 from it as a wrong code from addr. Only a correct
+// code (200) clears the count.
+func serveCodePath(next http.Handler, w http.ResponseWriter, r *http.Request, limiter *Limiter, addr string) {
+	rec := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r)
+	switch rec.status {
+	case http.StatusUnauthorized, http.StatusTooManyRequests:
+		limiter.failCode(r.URL.Path, addr)
+	case 0, http.StatusOK:
+		limiter.succeedCode(r.URL.Path, addr)
+	}
+}
+
+// Identity is who made a request and what they may do.
+type Identity struct {
+	Role Role
+	// Name is the enrolled device's or signed token's name, if any.
+	Name string
+	// Device is set if the request used an enrolled device's token.
+	Device *Device
+	// Elevated is set for the admin token and elevated tokens; see
+	// Elevator.
+	Elevated bool
+
+	// cred is the credential the request was made with.
+	cred credential
+	// session is set if the request used a session cookie.
+	session bool
+	// ambient is set if the browser sent the credential by itself: a
+	// session cookie or a client certificate.
+	ambient bool
+	// pin is set if an admin PIN is configured.
+	pin bool
+}
+
+// identify returns the identity of the request's credentials, as far as
+// method accepts them. The highest role wins if several are present; a
+// client certificate, then the session cookie, is only used if no token is
+// sent. The error is ErrTokenExpired or ErrOutsideWindow for signed tokens
+// that cannot be used now, errInvalidSession for a session cookie that no
+// longer works, errNoCredentials if the request has none, errCertRequired
+// for AuthCert without a certificate, and ErrInvalidToken otherwise.
+func (c Credentials) identify(r *http.Request, method AuthMethod) (Identity, error) {
+	if method == AuthCert {
+		if id, ok := c.identifyCert(r); ok {
+			return id, nil
+		}
+		return Identity{}, errCertRequired
+	}
+	bearer := extractBearerToken(r)
+	admin := r.Header.Get(AdminHeader)
+	if bearer == "" && admin == "" {
+		if method != AuthBearer {
+			if id, ok := c.identifyCert(r); ok {
+				return id, nil
+			}
+		}
+		if cookie, err := r.Cookie(SessionCookie); err == nil {
+			return c.identifySession(cookie.Value)
+		}
+		return Identity{}, errNoCredentials
+	}
+	if c.AdminToken != "" && (matches(bearer, c.AdminToken) || matches(admin, c.AdminToken)) {
+		return Identity{Role: RoleAdmin, Elevated: true, cred: credential{Kind: credAdmin, Ref: hashToken(c.AdminToken)}}, nil
+	}
+	if admin != "" {
+		// A wrong admin token is a failed login, even if the bearer token
+		// is valid.
+		return Identity{}, ErrInvalidToken
+	}
+	if c.Shared.Valid(bearer) {
+		return Identity{Role: RoleWorker, cred: credential{Kind: credShared, Ref: hashToken(bearer)}}, nil
+	}
+	if strings.HasPrefix(bearer, signedPrefix) {
+		claims, err := c.Signer.Verify(bearer, time.Now())
+		if err != nil {
+			return Identity{}, err
+		}
+		return Identity{Role: claims.Role, Name: claims.Name, Elevated: claims.Elevated, cred: credential{Kind: credSigned, Ref: hashToken(bearer), Claims: &claims, Key: c.Signer.keyID()}}, nil
+	}
+	if c.Devices != nil {
+		if d, ok := c.Devices.Lookup(bearer); ok {
+			return Identity{Role: d.Role, Name: d.Name, Device: &d, cred: credential{Kind: credDevice, Ref: d.ID}}, nil
 		}
 	}
-	return false
+	return Identity{}, ErrInvalidToken
+}
+
+// matches compares a provided token in constant time. An empty token never
+// matches.
+func matches(provided, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// identityKey is the request context key for the caller's Identity.
+type identityKey struct{}
+
+// FromContext returns the identity stored by Middleware. ok is false on
+// public paths.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// meResponse is the JSON body returned by HandleMe.
+type meResponse struct {
+	Role   Role   `json:"role"`
+	Device string `json:"device,omitempty"`
+	// PIN is set if admin actions need the admin PIN, and Elevated if the
+	// caller has entered it.
+	PIN      bool `json:"pin,omitempty"`
+	Elevated bool `json:"elevated,omitempty"`
+}
+
+// HandleMe handles GET /auth/me. It returns the caller's role, so the PWA
+// can hide what the token may not do.
+func HandleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := FromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	res := meResponse{Role: id.Role, Device: id.Name, PIN: id.pin, Elevated: id.Elevated}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// RequestDevice names who made a request, for logs and history: the
+// enrolled device's or signed token's name, or the client address for the
+// shared token.
+func RequestDevice(r *http.Request) string {
+	if id, ok := FromContext(r.Context()); ok && id.Name != "" {
+		return id.Name
+	}
+	return clientAddr(r)
 }
 
 func extractBearerToken(r *http.Request) string {
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -32,7 +33,7 @@ func TestMiddlewareAllowsUnprotectedPaths(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := Middleware("secret", []string{"/api/", "/children"})
+	mw := Middleware(Credentials{Shared: NewSharedToken("secret", 0)}, protect("/api/", "/children"), nil)
 	wrapped := mw(handler)
 
 	tests := []struct {
@@ -66,7 +67,7 @@ func TestMiddlewareRejectsWithoutToken(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := Middleware("secret", []string{"/api/", "/children"})
+	mw := Middleware(Credentials{Shared: NewSharedToken("secret", 0)}, protect("/api/", "/children"), nil)
 	wrapped := mw(handler)
 
 	tests := []struct {
@@ -97,7 +98,7 @@ func TestMiddlewareRejectsWrongToken(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := Middleware("correct-token", []string{"/api/"})
+	mw := Middleware(Credentials{Shared: NewSharedToken("correct-token", 0)}, protect("/api/"), nil)
 	wrapped := mw(handler)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/messages", nil)
@@ -117,7 +118,7 @@ func TestMiddlewareAcceptsValidToken(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := Middleware("correct-token", []string{"/api/", "/children"})
+	mw := Middleware(Credentials{Shared: NewSharedToken("correct-token", 0)}, protect("/api/", "/children"), nil)
 	wrapped := mw(handler)
 
 	tests := []struct {
@@ -141,3 +142,123 @@ func TestMiddlewareAcceptsValidToken(t *testing.T) {
 		})
 	}
 }
+
+func TestMiddlewareRoles(t *testing.T) {
+	t.Parallel()
+
+	perms := []Permission{
+		{Method: http.MethodGet, Prefix: "/message/test", Role: RoleViewer},
+		{Prefix: "/message/", Role: RoleWorker},
+		{Prefix: "/message/send-text", Role: RoleAdmin},
+		{Method: http.MethodGet, Prefix: "/children", Role: RoleWorker},
+		{Prefix: "/children", Role: RoleAdmin},
+	}
+	handler := Middleware(Credentials{Shared: NewSharedToken("worker", 0), AdminToken: "admin"}, perms, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		bearer string
+		admin  string
+		want   int
+	}{
+		{"worker reads status", http.MethodGet, "/message/test", "worker", "", http.StatusOK},
+		{"worker sends", http.MethodPost, "/message/send", "worker", "", http.StatusOK},
+		{"worker reads children", http.MethodGet, "/children", "worker", "", http.StatusOK},
+		{"worker head children", http.MethodHead, "/children", "worker", "", http.StatusOK},
+		{"worker deletes children", http.MethodDelete, "/children", "worker", "", http.StatusForbidden},
+		{"worker sends free text", http.MethodPost, "/message/send-text", "worker", "", http.StatusForbidden},
+		{"admin header", http.MethodPost, "/message/send-text", "worker", "admin", http.StatusOK},
+		{"admin bearer", http.MethodDelete, "/children", "admin", "", http.StatusOK},
+		{"wrong admin token", http.MethodDelete, "/children", "worker", "nope", http.StatusUnauthorized},
+		{"wrong admin token on worker path", http.MethodGet, "/children", "worker", "nope", http.StatusUnauthorized},
+		{"no token", http.MethodGet, "/message/test", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			if tc.admin != "" {
+				req.Header.Set(AdminHeader, tc.admin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareEmptyAdminTokenDisablesAdmin(t *testing.T) {
+	t.Parallel()
+
+	handler := Middleware(Credentials{Shared: NewSharedToken("worker", 0)}, []Permission{{Prefix: "/auth/devices", Role: RoleAdmin}}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/devices", nil)
+	req.Header.Set("Authorization", "Bearer worker")
+	req.Header.Set(AdminHeader, "")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", rec.Code)
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    Role
+		wantErr bool
+	}{
+		{"", RoleWorker, false},
+		{"Viewer", RoleViewer, false},
+		{" admin ", RoleAdmin, false},
+		{"root", "", true},
+	}
+	for _, tc := range tests {
+		got, err := ParseRole(tc.in)
+		if got != tc.want || (err != nil) != tc.wantErr {
+			t.Errorf("ParseRole(%q) = %q, %v", tc.in, got, err)
+		}
+	}
+
+	if RoleWorker.Allows(RoleAdmin) || !RoleAdmin.Allows(RoleViewer) || Role("").Allows(RoleViewer) {
+		t.Error("unexpected role order")
+	}
+}
+
+// protect returns permissions that require RoleWorker for the prefixes.
+func protect(prefixes ...string) []Permission {
+	perms := make([]Permission, len(prefixes))
+	for i, p := range prefixes {
+		perms[i] = Permission{Prefix: p, Role: RoleWorker}
+	}
+	return perms
+}
+
+func TestHandleMe(t *testing.T) {
+	t.Parallel()
+
+	handler := Middleware(Credentials{Shared: NewSharedToken("worker", 0), AdminToken: "admin"}, []Permission{{Prefix: "/auth/me", Role: RoleViewer}}, nil)(http.HandlerFunc(HandleMe))
+
+	for token, want := range map[string]Role{"worker": RoleWorker, "admin": RoleAdmin} {
+		req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var res meResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		if rec.Code != http.StatusOK || res.Role != want {
+			t.Errorf("%s: got %d %+v, want role %s", token, rec.Code, res, want)
+		}
+	}
+}
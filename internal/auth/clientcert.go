@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/x509/pkix"
+	"net/http"
+)
+
+// ClientCertSubject returns the subject of the client certificate for d:
+// the device name as common name and the device ID as serial number.
+// identifyCert maps the serial number back to the device, so the role
+// comes from the registry and revoking the device revokes the certificate.
+func ClientCertSubject(d Device) pkix.Name {
+	return pkix.Name{CommonName: d.Name, SerialNumber: d.ID, Organization: []string{"Calling Parents"}}
+}
+
+// EnrollCertificate adds a device that authenticates with a client
+// certificate (mTLS) instead of a token, e.g. a wall-mounted tablet. Issue
+// its certificate with the subject from ClientCertSubject.
+func (r *Registry) EnrollCertificate(name string, role Role) (Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The device's token is never handed out.
+	_, d, err := r.add(name, role, true)
+	return d, err
+}
+
+// identifyCert returns the identity of the request's client certificate. ok
+// is false if the request has no verified certificate, or the device it was
+// issued to has been revoked.
+func (c Credentials) identifyCert(r *http.Request) (id Identity, ok bool) {
+	if c.Devices == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Identity{}, false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	d, ok := c.Devices.lookupID(leaf.Subject.SerialNumber)
+	if !ok || !d.Cert {
+		return Identity{}, false
+	}
+	return Identity{Role: d.Role, Name: d.Name, Device: &d, cred: credential{Kind: credDevice, Ref: d.ID}, ambient: true}, true
+}
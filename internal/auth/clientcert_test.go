@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// withClientCert returns a request as if made over TLS with a verified
+// client certificate for subject d.
+func withClientCert(d Device) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/message/test", nil)
+	leaf := &x509.Certificate{Subject: ClientCertSubject(d)}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+	return req
+}
+
+func TestClientCertAuth(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	tablet, err := reg.EnrollCertificate("Gruppenraum 1", RoleViewer)
+	if err != nil {
+		t.Fatalf("EnrollCertificate() error: %v", err)
+	}
+	if infos := reg.Devices(); len(infos) != 1 || !infos[0].Cert {
+		t.Fatalf("Devices() = %+v, want one certificate device", infos)
+	}
+	code, _, _ := reg.Enroll("Kasse 1", RoleWorker)
+	_, phone, _ := reg.Redeem(code)
+
+	var got Identity
+	creds := Credentials{Shared: NewSharedToken("shared", 0), Devices: reg}
+	perms := []Permission{{Prefix: "/message/", Role: RoleViewer}}
+	h := Middleware(creds, perms, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, withClientCert(tablet))
+	if rec.Code != http.StatusOK || got.Role != RoleViewer || got.Name != "Gruppenraum 1" {
+		t.Fatalf("certificate: got %d, identity %+v", rec.Code, got)
+	}
+
+	// The browser sends the certificate to any site's requests, so writes
+	// need the PWA's origin, as with session cookies.
+	for _, tc := range []struct {
+		origin string
+		want   int
+	}{{"", http.StatusForbidden}, {"http://evil.example", http.StatusForbidden}, {"http://example.com", http.StatusOK}} {
+		req := withClientCert(tablet)
+		req.Method = http.MethodPost
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("POST with origin %q: expected %d, got %d", tc.origin, tc.want, rec.Code)
+		}
+	}
+
+	// A token sent along wins over the certificate.
+	req := withClientCert(tablet)
+	req.Header.Set("Authorization", "Bearer shared")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.Role != RoleWorker || got.Device != nil {
+		t.Errorf("token with certificate: identity %+v", got)
+	}
+
+	// A certificate naming a token device does not work.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, withClientCert(phone))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("token device: expected 401, got %d", rec.Code)
+	}
+
+	// Revoking the device revokes the certificate.
+	reg.Revoke(tablet.ID)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, withClientCert(tablet))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestPermissionAuthMethod(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	tablet, err := reg.EnrollCertificate("Gruppenraum 1", RoleWorker)
+	if err != nil {
+		t.Fatalf("EnrollCertificate() error: %v", err)
+	}
+
+	creds := Credentials{Shared: NewSharedToken("shared", 0), Devices: reg}
+	perms := []Permission{
+		{Prefix: "/message/", Role: RoleViewer},
+		{Prefix: "/message/token", Role: RoleViewer, Auth: AuthBearer},
+		{Prefix: "/message/cert", Role: RoleViewer, Auth: AuthCert},
+	}
+	h := Middleware(creds, perms, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		path     string
+		cert     bool
+		token    string
+		wantCode int
+	}{
+		{"certificate on any route", "/message/test", true, "", http.StatusOK},
+		{"token on any route", "/message/test", false, "shared", http.StatusOK},
+		{"certificate on bearer route", "/message/token", true, "", http.StatusUnauthorized},
+		{"token on bearer route", "/message/token", false, "shared", http.StatusOK},
+		{"certificate on cert route", "/message/cert", true, "", http.StatusOK},
+		{"token on cert route", "/message/cert", false, "shared", http.StatusUnauthorized},
+		{"certificate and token on cert route", "/message/cert", true, "shared", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.cert {
+				req = withClientCert(tablet)
+				req.URL.Path = tc.path
+			}
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Errorf("expected %d, got %d", tc.wantCode, rec.Code)
+			}
+		})
+	}
+}
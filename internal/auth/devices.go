@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// EnrollmentTTL is how long an enrollment code can be redeemed. It only has
+// to last from printing the QR code to scanning it.
+const EnrollmentTTL = 15 * time.Minute
+
+// Errors returned by Registry.
+var (
+	ErrUnknownDevice = errors.New("unknown device")
+	ErrInvalidCode   = errors.New("invalid or expired enrollment code")
+)
+
+// Device is a phone or tablet enrolled with its own token. Only a hash of
+// the token is kept, so the registry file does not grant access.
+type Device struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      Role   `json:"role"`
+	TokenHash string `json:"tokenHash"`
+	Created   string `json:"created"`
+	// Cert is set for devices that authenticate with a client certificate
+	// instead of their token; see EnrollCertificate.
+	Cert bool `json:"cert,omitempty"`
+}
+
+// DeviceInfo is a Device as listed by GET /auth/devices.
+type DeviceInfo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Role    Role   `json:"role"`
+	Created string `json:"created"`
+	// Cert is set for devices with a client certificate.
+	Cert bool `json:"cert,omitempty"`
+	// LastSeen is the time of the last authenticated request since the
+	// server started, if any.
+	LastSeen string `json:"lastSeen,omitempty"`
+}
+
+// enrollment is a pending one-time enrollment code.
+type enrollment struct {
+	name    string
+	role    Role
+	expires time.Time
+	// failures counts wrong pairing codes while a pairing code is pending.
+	failures int
+}
+
+// Registry keeps the enrolled devices in a JSON file and the pending
+// enrollment and pairing codes in memory. It is safe for concurrent use.
+type Registry struct {
+	mu          sync.Mutex
+	path        string
+	devices     []Device
+	enrollments map[string]enrollment
+	pairings    map[string]enrollment
+	lastSeen    map[string]time.Time
+
+	// baseURL and announce are used by HandleCreateEnrollment.
+	baseURL  string
+	announce func(name, url string)
+}
+
+// NewRegistry loads the registry from path. A missing file is an empty
+// registry; it is created on the first enrollment.
+func NewRegistry(path string) (*Registry, error) {
+	r := &Registry{
+		path:        path,
+		enrollments: make(map[string]enrollment),
+		pairings:    make(map[string]enrollment),
+		lastSeen:    make(map[string]time.Time),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.devices); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	// Devices enrolled before roles existed are workers.
+	for i := range r.devices {
+		if r.devices[i].Role == "" {
+			r.devices[i].Role = RoleWorker
+		}
+	}
+	return r, nil
+}
+
+// Enroll creates a one-time code that Redeem exchanges for a token of a new
+// device called name with the given role.
+func (r *Registry) Enroll(name string, role Role) (code string, expires time.Time, err error) {
+	code, err = randomHex(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires = time.Now().Add(EnrollmentTTL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired()
+	r.enrollments[code] = enrollment{name: name, role: role, expires: expires}
+	return code, expires, nil
+}
+
+// Redeem exchanges an enrollment code for a new device and its token. Each
+// code works once.
+func (r *Registry) Redeem(code string) (token string, d Device, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropExpired()
+	e, ok := r.enrollments[code]
+	if !ok {
+		return "", Device{}, ErrInvalidCode
+	}
+	delete(r.enrollments, code)
+	return r.add(e.name, e.role, false)
+}
+
+// add creates and saves a device with a new token; cert marks a device with
+// a client certificate. The caller must hold r.mu.
+func (r *Registry) add(name string, role Role, cert bool) (token string, d Device, err error) {
+	token, err = GenerateToken()
+	if err != nil {
+		return "", Device{}, err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return "", Device{}, err
+	}
+	if name == "" {
+		name = "Device " + id
+	}
+	d = Device{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		TokenHash: hashToken(token),
+		Created:   time.Now().Format(time.RFC3339),
+		Cert:      cert,
+	}
+	devices := append(slices.Clone(r.devices), d)
+	if err := r.save(devices); err != nil {
+		return "", Device{}, err
+	}
+	r.devices = devices
+	return token, d, nil
+}
+
+// Lookup returns the device the token belongs to and records the request.
+func (r *Registry) Lookup(token string) (Device, bool) {
+	if token == "" {
+		return Device{}, false
+	}
+	hash := hashToken(token)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.devices {
+		if d.TokenHash == hash {
+			r.lastSeen[d.ID] = time.Now()
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// lookupID returns the device with the given ID and records the request.
+func (r *Registry) lookupID(id string) (Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.devices {
+		if d.ID == id {
+			r.lastSeen[d.ID] = time.Now()
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// Devices lists the enrolled devices in enrollment order.
+func (r *Registry) Devices() []DeviceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]DeviceInfo, 0, len(r.devices))
+	for _, d := range r.devices {
+		info := DeviceInfo{ID: d.ID, Name: d.Name, Role: d.Role, Created: d.Created, Cert: d.Cert}
+		if t, ok := r.lastSeen[d.ID]; ok {
+			info.LastSeen = t.Format(time.RFC3339)
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// Revoke removes the device with the given ID; its token stops working
+// immediately.
+func (r *Registry) Revoke(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx := slices.IndexFunc(r.devices, func(d Device) bool { return d.ID == id })
+	if idx < 0 {
+		return ErrUnknownDevice
+	}
+	devices := slices.Delete(slices.Clone(r.devices), idx, idx+1)
+	if err := r.save(devices); err != nil {
+		return err
+	}
+	r.devices = devices
+	delete(r.lastSeen, id)
+	return nil
+}
+
+// dropExpired forgets expired enrollment and pairing codes. The caller must
+// hold r.mu.
+func (r *Registry) dropExpired() {
+	now := time.Now()
+	for _, codes := range []map[string]enrollment{r.enrollments, r.pairings} {
+		for code, e := range codes {
+			if now.After(e.expires) {
+				delete(codes, code)
+			}
+		}
+	}
+}
+
+// save writes devices to the registry file atomically. The caller must hold
+// r.mu.
+func (r *Registry) save(devices []Device) error {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(r.path, append(data, '\n'), 0600)
+}
+
+// hashToken returns the hex SHA-256 of a device token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes as hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
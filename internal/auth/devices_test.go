@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistryEnrollRedeemRevoke(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "devices.json")
+	reg, err := NewRegistry(path)
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+
+	code, _, err := reg.Enroll("Kasse 1", RoleWorker)
+	if err != nil {
+		t.Fatalf("Enroll() error: %v", err)
+	}
+	token, d, err := reg.Redeem(code)
+	if err != nil {
+		t.Fatalf("Redeem() error: %v", err)
+	}
+	if d.Name != "Kasse 1" || d.ID == "" {
+		t.Errorf("unexpected device %+v", d)
+	}
+	if _, _, err := reg.Redeem(code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second Redeem() = %v, want ErrInvalidCode", err)
+	}
+
+	// The file holds the hash, not the token, and is reloaded.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading registry: %v", err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("registry file must not contain the token")
+	}
+	reloaded, err := NewRegistry(path)
+	if err != nil {
+		t.Fatalf("NewRegistry() reload error: %v", err)
+	}
+	if got, ok := reloaded.Lookup(token); !ok || got.ID != d.ID {
+		t.Errorf("Lookup() after reload = %+v, %v", got, ok)
+	}
+
+	if err := reloaded.Revoke(d.ID); err != nil {
+		t.Fatalf("Revoke() error: %v", err)
+	}
+	if _, ok := reloaded.Lookup(token); ok {
+		t.Error("revoked token must not be accepted")
+	}
+	if err := reloaded.Revoke(d.ID); !errors.Is(err, ErrUnknownDevice) {
+		t.Errorf("second Revoke() = %v, want ErrUnknownDevice", err)
+	}
+}
+
+func TestRegistryHandlers(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	reg.SetBaseURL("http://192.168.1.20:8080/")
+	var announced string
+	reg.SetAnnouncer(func(name, url string) { announced = url })
+
+	for _, body := range []string{`{"name":" "}`, `{"name":"Kasse 1","role":"root"}`} {
+		rec := httptest.NewRecorder()
+		reg.HandleCreateEnrollment(rec, httptest.NewRequest(http.MethodPost, "/auth/enrollments", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	reg.HandleCreateEnrollment(rec, httptest.NewRequest(http.MethodPost, "/auth/enrollments", strings.NewReader(`{"name":"Kasse 1","role":"viewer"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	var enr enrollmentResponse
+	json.NewDecoder(rec.Body).Decode(&enr)
+	if enr.URL != "http://192.168.1.20:8080#enroll="+enr.Code || announced != enr.URL {
+		t.Errorf("unexpected URL %q (announced %q)", enr.URL, announced)
+	}
+	if !strings.HasPrefix(enr.QR, "data:image/png;base64,") {
+		t.Errorf("expected PNG data URI, got %.30q", enr.QR)
+	}
+
+	rec = httptest.NewRecorder()
+	reg.HandleEnroll(rec, httptest.NewRequest(http.MethodPost, "/auth/enroll", strings.NewReader(`{"code":"`+enr.Code+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var res enrollResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Token == "" || res.Device.Name != "Kasse 1" || res.Device.Role != RoleViewer {
+		t.Errorf("unexpected enrollment %+v", res)
+	}
+
+	rec = httptest.NewRecorder()
+	reg.HandleEnroll(rec, httptest.NewRequest(http.MethodPost, "/auth/enroll", strings.NewReader(`{"code":"`+enr.Code+`"}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a used code, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	reg.HandleDevices(rec, httptest.NewRequest(http.MethodGet, "/auth/devices", nil))
+	var list []DeviceInfo
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list) != 1 || list[0].ID != res.Device.ID {
+		t.Errorf("unexpected device list %+v", list)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /auth/devices/{id}", reg.HandleRevoke)
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/auth/devices/"+res.Device.ID, nil))
+		if rec.Code != want {
+			t.Errorf("DELETE: expected %d, got %d", want, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareDeviceTokens(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	code, _, _ := reg.Enroll("Kasse 1", RoleWorker)
+	deviceToken, d, err := reg.Redeem(code)
+	if err != nil {
+		t.Fatalf("Redeem() error: %v", err)
+	}
+
+	var who string
+	handler := Middleware(Credentials{Shared: NewSharedToken("shared", 0), Devices: reg}, protect("/message/"), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who = RequestDevice(r)
+	}))
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantWho  string
+	}{
+		{"shared token", "shared", http.StatusOK, "192.0.2.1"},
+		{"device token", deviceToken, http.StatusOK, "Kasse 1"},
+		{"wrong token", "nope", http.StatusUnauthorized, ""},
+	}
+	for _, tc := range tests {
+		who = ""
+		req := httptest.NewRequest(http.MethodPost, "/message/send", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.wantCode || who != tc.wantWho {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, rec.Code, who, tc.wantCode, tc.wantWho)
+		}
+	}
+
+	if err := reg.Revoke(d.ID); err != nil {
+		t.Fatalf("Revoke() error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/message/send", nil)
+	req.Header.Set("Authorization", "Bearer "+deviceToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after revocation, got %d", rec.Code)
+	}
+}
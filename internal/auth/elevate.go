@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// pinIterations is the PBKDF2 iteration count for new PIN hashes, as
+// recommended by OWASP for PBKDF2-HMAC-SHA256. It makes each guess against
+// a stolen config.toml expensive.
+const pinIterations = 600_000
+
+// pinHashPrefix starts a PIN hash: "pbkdf2-sha256$<iterations>$<salt>$<key>"
+// with base64 salt and key.
+const pinHashPrefix = "pbkdf2-sha256"
+
+// minPINLength is the shortest PIN HashPIN accepts.
+const minPINLength = 6
+
+// errorElevation is the ErrorHeader value for a request that needs the
+// admin PIN (403): elevate with POST /auth/elevate and retry.
+const errorElevation = "elevation_required"
+
+// HashPIN hashes an admin PIN with PBKDF2-HMAC-SHA256 and a random salt,
+// for admin_pin_hash in config.toml.
+func HashPIN(pin string) (string, error) {
+	if utf8.RuneCountInString(pin) < minPINLength {
+		return "", fmt.Errorf("the PIN must have at least %d characters", minPINLength)
+	}
+	return hashPIN(pin, pinIterations)
+}
+
+func hashPIN(pin string, iterations int) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, pin, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", pinHashPrefix, iterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// pinHash is a parsed PIN hash.
+type pinHash struct {
+	iterations int
+	salt, key  []byte
+}
+
+// parsePINHash parses a hash made by HashPIN.
+func parsePINHash(s string) (pinHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != pinHashPrefix {
+		return pinHash{}, errors.New("not a PIN hash; create one with \"calling-parents hash-pin\"")
+	}
+	var h pinHash
+	var err error
+	if h.iterations, err = strconv.Atoi(parts[1]); err != nil || h.iterations <= 0 {
+		return pinHash{}, errors.New("invalid iteration count in PIN hash")
+	}
+	enc := base64.RawStdEncoding
+	if h.salt, err = enc.DecodeString(parts[2]); err != nil || len(h.salt) == 0 {
+		return pinHash{}, errors.New("invalid salt in PIN hash")
+	}
+	if h.key, err = enc.DecodeString(parts[3]); err != nil || len(h.key) == 0 {
+		return pinHash{}, errors.New("invalid key in PIN hash")
+	}
+	return h, nil
+}
+
+// matches reports whether pin is the hashed PIN, in constant time.
+func (h pinHash) matches(pin string) bool {
+	key, err := pbkdf2.Key(sha256.New, pin, h.salt, h.iterations, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// Elevator checks the admin PIN and issues elevated tokens: short-lived
+// signed admin tokens. While an Elevator is set in Credentials, routes that
+// need RoleAdmin also need an elevated token (or the admin token itself),
+// so a phone with the QR code, or a lost admin device, cannot change the
+// children list without the PIN.
+type Elevator struct {
+	pin    pinHash
+	signer *Signer
+	ttl    time.Duration
+}
+
+// NewElevator returns an Elevator for a hash from HashPIN. Elevated tokens
+// are signed by signer and last ttl.
+func NewElevator(hash string, signer *Signer, ttl time.Duration) (*Elevator, error) {
+	pin, err := parsePINHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Elevator{pin: pin, signer: signer, ttl: ttl}, nil
+}
+
+// elevateRequest is the JSON body of POST /auth/elevate.
+type elevateRequest struct {
+	PIN string `json:"pin"`
+}
+
+// elevateResponse is the JSON body returned by HandleElevate.
+type elevateResponse struct {
+	Token   string `json:"token"`
+	Expires string `json:"expires"`
+}
+
+// HandleElevate handles POST /auth/elevate. It checks the admin PIN and
+// returns an elevated token for the caller, named like the caller. A wrong
+// PIN gets 401; with /auth/elevate in Limits.CodePaths it counts as a failed
+// login.
+func (e *Elevator) HandleElevate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req elevateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !e.pin.matches(req.PIN) {
+		http.Error(w, "wrong PIN", http.StatusUnauthorized)
+		return
+	}
+
+	id, _ := FromContext(r.Context())
+	expires := time.Now().Add(e.ttl)
+	token, err := e.signer.Issue(Claims{Name: id.Name, Role: RoleAdmin, Expires: expires.Unix(), Elevated: true})
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin PIN entered by %s (elevated until %s)", RequestDevice(r), expires.Format(time.TimeOnly))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(elevateResponse{Token: token, Expires: expires.Format(time.RFC3339)})
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashPIN(t *testing.T) {
+	t.Parallel()
+
+	if _, err := HashPIN("12345"); err == nil {
+		t.Error("expected an error for a short PIN")
+	}
+	hash, err := HashPIN("471147")
+	if err != nil {
+		t.Fatalf("HashPIN() error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") || strings.Contains(hash, "471147") {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	h, err := parsePINHash(hash)
+	if err != nil {
+		t.Fatalf("parsePINHash() error: %v", err)
+	}
+	if !h.matches("471147") || h.matches("471148") || h.matches("") {
+		t.Error("hash matches the wrong PINs")
+	}
+	for _, bad := range []string{"", "4711", "pbkdf2-sha256$x$AA$AA", "bcrypt$1$AA$AA", "pbkdf2-sha256$1$$AA"} {
+		if _, err := NewElevator(bad, nil, time.Minute); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestElevation(t *testing.T) {
+	t.Parallel()
+
+	// Few iterations keep the test fast; the count is part of the hash.
+	hash, err := hashPIN("471147", 1000)
+	if err != nil {
+		t.Fatalf("hashPIN() error: %v", err)
+	}
+	signer := NewSigner([]byte("test key"))
+	elevator, err := NewElevator(hash, signer, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("NewElevator() error: %v", err)
+	}
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	code, _, _ := reg.Enroll("Büro", RoleAdmin)
+	adminDeviceToken, _, _ := reg.Redeem(code)
+
+	creds := Credentials{Shared: NewSharedToken("shared", 0), AdminToken: "admin", Devices: reg, Signer: signer, Elevator: elevator}
+	perms := []Permission{
+		{Prefix: "/auth/elevate", Role: RoleWorker},
+		{Method: http.MethodGet, Prefix: "/children", Role: RoleWorker},
+		{Prefix: "/children", Role: RoleAdmin},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/elevate", elevator.HandleElevate)
+	mux.HandleFunc("/children", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /auth/me", HandleMe)
+	limiter := NewLimiter(Limits{LockoutAttempts: 3, CodePaths: []string{"/auth/elevate"}})
+	h := Middleware(creds, append(perms, Permission{Prefix: "/auth/me", Role: RoleViewer}), limiter)(mux)
+
+	do := func(method, path, token, body, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = addr + ":1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without the PIN, even an admin device cannot change the list.
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		wantCode int
+	}{
+		{"worker reads", http.MethodGet, "shared", http.StatusOK},
+		{"worker writes", http.MethodPost, "shared", http.StatusForbidden},
+		{"admin device writes", http.MethodPost, adminDeviceToken, http.StatusForbidden},
+		{"admin token writes", http.MethodPost, "admin", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := do(tc.method, "/children", tc.token, "", "192.0.2.1"); rec.Code != tc.wantCode {
+				t.Errorf("expected %d, got %d", tc.wantCode, rec.Code)
+			}
+		})
+	}
+	if rec := do(http.MethodPost, "/children", adminDeviceToken, "", "192.0.2.1"); rec.Header().Get(ErrorHeader) != "elevation_required" {
+		t.Errorf("expected %s elevation_required, got %q", ErrorHeader, rec.Header().Get(ErrorHeader))
+	}
+
+	// A wrong PIN is a failed login; the third one locks the address out.
+	// Requests with the valid worker token in between do not reset the
+	// count, but a correct PIN does.
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"000000"}`, "192.0.2.3"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong PIN %d: expected 401, got %d", i+1, rec.Code)
+		}
+	}
+	if rec := do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"471147"}`, "192.0.2.3"); rec.Code != http.StatusOK {
+		t.Fatalf("correct PIN: expected 200, got %d", rec.Code)
+	}
+	for i := 0; i < 2; i++ {
+		do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"000000"}`, "192.0.2.2")
+		if rec := do(http.MethodGet, "/children", "shared", "", "192.0.2.2"); rec.Code != http.StatusOK {
+			t.Fatalf("read between wrong PINs: expected 200, got %d", rec.Code)
+		}
+	}
+	do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"000000"}`, "192.0.2.2")
+	if rec := do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"471147"}`, "192.0.2.2"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked out: expected 429, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodPost, "/auth/elevate", "shared", `{"pin":"471147"}`, "192.0.2.1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("elevate: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var res elevateResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec := do(http.MethodPost, "/children", res.Token, "", "192.0.2.1"); rec.Code != http.StatusOK {
+		t.Errorf("elevated write: expected 200, got %d", rec.Code)
+	}
+	var me meResponse
+	json.NewDecoder(do(http.MethodGet, "/auth/me", res.Token, "", "192.0.2.1").Body).Decode(&me)
+	if me.Role != RoleAdmin || !me.PIN || !me.Elevated {
+		t.Errorf("GET /auth/me = %+v", me)
+	}
+
+	// Elevated tokens expire.
+	expired, _ := signer.Issue(Claims{Role: RoleAdmin, Expires: time.Now().Add(-time.Second).Unix(), Elevated: true})
+	if rec := do(http.MethodPost, "/children", expired, "", "192.0.2.1"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired elevation: expected 401, got %d", rec.Code)
+	}
+	// Admin tokens from HandleIssue are not elevated.
+	issued, _ := signer.Issue(Claims{Name: "Gast", Role: RoleAdmin, Expires: time.Now().Add(time.Hour).Unix()})
+	if rec := do(http.MethodPost, "/children", issued, "", "192.0.2.1"); rec.Code != http.StatusForbidden {
+		t.Errorf("signed admin token: expected 403, got %d", rec.Code)
+	}
+}
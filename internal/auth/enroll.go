@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rsc.io/qr"
+)
+
+// maxDeviceName is the longest device name accepted, in characters.
+const maxDeviceName = 64
+
+// SetBaseURL sets the PWA URL that enrollment links point to, e.g.
+// "http://192.168.1.20:8080".
+func (r *Registry) SetBaseURL(url string) {
+	r.baseURL = strings.TrimRight(url, "/")
+}
+
+// SetAnnouncer sets a function that is called with each new enrollment link,
+// e.g. to print its QR code on the terminal. Nil disables it.
+func (r *Registry) SetAnnouncer(announce func(name, url string)) {
+	r.announce = announce
+}
+
+// enrollmentRequest is the JSON body for POST /auth/enrollments.
+type enrollmentRequest struct {
+	Name string `json:"name"`
+	// Role defaults to worker.
+	Role string `json:"role,omitempty"`
+}
+
+// enrollmentResponse is the JSON body returned by HandleCreateEnrollment.
+type enrollmentResponse struct {
+	Code    string `json:"code"`
+	URL     string `json:"url"`
+	Expires string `json:"expires"`
+	// QR is the URL as a PNG QR code in a data: URI, for showing in a
+	// browser.
+	QR string `json:"qr"`
+}
+
+// HandleCreateEnrollment handles POST /auth/enrollments (admin only). It
+// creates a one-time enrollment code for a device with the given name and
+// role and returns a link and QR code that enroll the device when opened.
+func (r *Registry) HandleCreateEnrollment(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body enrollmentRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		http.Error(w, "device name must not be empty", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(name) > maxDeviceName {
+		http.Error(w, "device name is too long", http.StatusBadRequest)
+		return
+	}
+
+	role, err := ParseRole(body.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, expires, err := r.Enroll(name, role)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	url := r.baseURL + "#enroll=" + code
+
+	res := enrollmentResponse{Code: code, URL: url, Expires: expires.Format(time.RFC3339), QR: qrDataURI(url)}
+	if r.announce != nil {
+		r.announce(name, url)
+	}
+	log.Printf("Enrollment code issued for device %q as %s (valid until %s)", name, role, expires.Format("15:04"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// qrDataURI returns url as a PNG QR code in a data: URI, or "" if it is too
+// long to encode.
+func qrDataURI(url string) string {
+	c, err := qr.Encode(url, qr.M)
+	if err != nil {
+		return ""
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(c.PNG())
+}
+
+// enrollRequest is the JSON body for POST /auth/enroll.
+type enrollRequest struct {
+	Code string `json:"code"`
+}
+
+// enrollResponse is the JSON body returned by HandleEnroll.
+type enrollResponse struct {
+	Token  string     `json:"token"`
+	Device DeviceInfo `json:"device"`
+}
+
+// HandleEnroll handles POST /auth/enroll. It needs no token: the enrollment
+// code is exchanged for the new device's token, once.
+func (r *Registry) HandleEnroll(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body enrollRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	token, d, err := r.Redeem(strings.TrimSpace(body.Code))
+	if errors.Is(err, ErrInvalidCode) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("WARNING: enrolling device: %v", err)
+		http.Error(w, "failed to save device", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Device %q enrolled as %s (id %s)", d.Name, d.Role, d.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollResponse{
+		Token:  token,
+		Device: DeviceInfo{ID: d.ID, Name: d.Name, Role: d.Role, Created: d.Created},
+	})
+}
+
+// HandleDevices handles GET /auth/devices (admin only) and lists the
+// enrolled devices.
+func (r *Registry) HandleDevices(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Devices())
+}
+
+// HandleRevoke handles DELETE /auth/devices/{id} (admin only). The device's
+// token stops working immediately.
+func (r *Registry) HandleRevoke(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := req.PathValue("id")
+	err := r.Revoke(id)
+	if errors.Is(err, ErrUnknownDevice) {
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("WARNING: revoking device: %v", err)
+		http.Error(w, "failed to save devices", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Device %s revoked", id)
+	w.WriteHeader(http.StatusNoContent)
+}
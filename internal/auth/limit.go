@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lockout durations: the first lockout of an address lasts LockoutBase, each
+// further one twice as long as the last, up to LockoutMax.
+const (
+	LockoutBase = time.Minute
+	LockoutMax  = time.Hour
+)
+
+// failureWindow is how long failed logins are remembered. An address that
+// fails now and then over a day is not locked out.
+const failureWindow = time.Hour
+
+// lockoutDecay is how long after its last lockout ended an address starts
+// again at LockoutBase. Logging in successfully does not shorten it, so an
+// attacker who also holds a valid token cannot reset the backoff.
+const lockoutDecay = 24 * time.Hour
+
+// maxTrackedAddrs bounds the addresses kept in memory; beyond it, addresses
+// that are not locked out are forgotten.
+const maxTrackedAddrs = 1024
+
+// RateLimit limits the requests each token may make to paths starting with
+// Prefix: a token bucket that holds Burst requests and refills at Burst per
+// Per.
+type RateLimit struct {
+	Prefix string
+	Burst  int
+	Per    time.Duration
+}
+
+// ParseRateLimits parses a comma-separated list of "prefix=N/unit" rate
+// limits, where unit is s, min or h, e.g.
+// "/message/send=20/min, /children=120/min". An empty string means no
+// limits.
+func ParseRateLimits(s string) ([]RateLimit, error) {
+	var limits []RateLimit
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, rate, ok := strings.Cut(part, "=")
+		count, unit, ok2 := strings.Cut(rate, "/")
+		if !ok || !ok2 || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("rate limit %q: want /prefix=N/unit", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rate limit %q: count must be a positive number", part)
+		}
+		per, ok := map[string]time.Duration{"s": time.Second, "min": time.Minute, "h": time.Hour}[strings.TrimSpace(unit)]
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: unit must be s, min or h", part)
+		}
+		limits = append(limits, RateLimit{Prefix: strings.TrimSpace(prefix), Burst: n, Per: per})
+	}
+	return limits, nil
+}
+
+// Limits configures a Limiter.
+type Limits struct {
+	// LockoutAttempts is how many failed logins in a row lock an address
+	// out. 0 disables lockouts.
+	LockoutAttempts int
+	// Rates limit each token's requests; the longest matching prefix
+	// applies. Requests no rate matches are not limited.
+	Rates []RateLimit
+	// CodePaths are endpoints that check a secret of their own, such as
+	// the one-time code at /auth/pair or the PIN at /auth/elevate. A 401
+	// from them counts as a failed code for that path and address, kept
+	// apart from failed logins: only a correct code or time resets it.
+	// Addresses locked out from a path or from logging in cannot use it.
+	CodePaths []string
+	// OnLockout is called when an address is locked out, e.g. to record it
+	// in the activity log. It may be nil.
+	OnLockout func(addr string, until time.Time)
+}
+
+// addrState tracks the failed logins of one client address.
+type addrState struct {
+	failures    int
+	lastFailure time.Time
+	// lockouts is the number of lockouts so far; it sets the next one's
+	// duration.
+	lockouts    int
+	lockedUntil time.Time
+}
+
+// bucket is a token bucket for one token and rate limit.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter protects Middleware against password guessing and floods: an
+// address that fails to log in LockoutAttempts times in a row is locked out
+// with exponential backoff, and each token's requests are rate limited per
+// route group. Both answer 429 with Retry-After. It is safe for concurrent
+// use.
+type Limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mu    sync.Mutex
+	addrs map[string]*addrState
+	// codes tracks failed codes per code path and address. Logging in
+	// with a token does not reset them, so a caller who holds one cannot
+	// use it to get more guesses at the admin PIN.
+	codes   map[string]*addrState
+	buckets map[string]*bucket
+}
+
+// NewLimiter returns a Limiter for the given limits.
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{
+		limits:  limits,
+		now:     time.Now,
+		addrs:   make(map[string]*addrState),
+		codes:   make(map[string]*addrState),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// lockedFor returns how long addr remains locked out, or 0.
+func (l *Limiter) lockedFor(addr string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.locked(l.addrs, addr)
+}
+
+// codeLockedFor returns how long addr remains locked out from the code path
+// path, or 0. An address locked out from logging in is locked out from
+// every code path too.
+func (l *Limiter) codeLockedFor(path, addr string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return max(l.locked(l.addrs, addr), l.locked(l.codes, codeKey(path, addr)))
+}
+
+// locked returns how long key in states remains locked out, or 0.
+func (l *Limiter) locked(states map[string]*addrState, key string) time.Duration {
+	if l.limits.LockoutAttempts <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := states[key]; ok {
+		return max(s.lockedUntil.Sub(l.now()), 0)
+	}
+	return 0
+}
+
+// codeKey is the key of path and addr in l.codes.
+func codeKey(path, addr string) string {
+	return path + "\x00" + addr
+}
+
+// fail records a failed login from addr and locks it out once it has
+// failed LockoutAttempts times in a row.
+func (l *Limiter) fail(addr string) {
+	if l == nil {
+		return
+	}
+	l.record(l.addrs, addr, addr)
+}
+
+// failCode records a wrong code from addr at path and locks addr out of
+// path once it has failed LockoutAttempts times in a row.
+func (l *Limiter) failCode(path, addr string) {
+	if l == nil {
+		return
+	}
+	l.record(l.codes, codeKey(path, addr), addr)
+}
+
+// record counts a failure for key in states and locks key out once it has
+// failed LockoutAttempts times in a row. OnLockout is told addr.
+func (l *Limiter) record(states map[string]*addrState, key, addr string) {
+	if l.limits.LockoutAttempts <= 0 {
+		return
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	s, ok := states[key]
+	if !ok {
+		if len(states) >= maxTrackedAddrs {
+			forget(states, now)
+		}
+		s = &addrState{}
+		states[key] = s
+	}
+	if now.Sub(s.lastFailure) > failureWindow {
+		s.failures = 0
+	}
+	if s.lockouts > 0 && now.Sub(s.lockedUntil) > lockoutDecay {
+		s.lockouts = 0
+	}
+	s.failures++
+	s.lastFailure = now
+	if s.failures < l.limits.LockoutAttempts {
+		l.mu.Unlock()
+		return
+	}
+	s.failures = 0
+	s.lockouts++
+	s.lockedUntil = now.Add(lockoutDuration(s.lockouts))
+	until := s.lockedUntil
+	l.mu.Unlock()
+
+	if l.limits.OnLockout != nil {
+		l.limits.OnLockout(addr, until)
+	}
+}
+
+// succeed forgets the failed logins of addr. Its lockouts are kept until
+// they decay, so the next lockout still lasts longer. Failed codes are not
+// affected.
+func (l *Limiter) succeed(addr string) {
+	if l == nil {
+		return
+	}
+	l.reset(l.addrs, addr)
+}
+
+// succeedCode forgets the wrong codes of addr at path, after a correct one.
+func (l *Limiter) succeedCode(path, addr string) {
+	if l == nil {
+		return
+	}
+	l.reset(l.codes, codeKey(path, addr))
+}
+
+// reset clears the failures of key in states, keeping its lockouts.
+func (l *Limiter) reset(states map[string]*addrState, key string) {
+	if l.limits.LockoutAttempts <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := states[key]
+	if !ok {
+		return
+	}
+	if s.lockouts == 0 {
+		delete(states, key)
+		return
+	}
+	s.failures = 0
+}
+
+// forget drops entries of states that are not locked out and whose lockouts,
+// if any, have decayed. The caller must hold l.mu.
+func forget(states map[string]*addrState, now time.Time) {
+	for key, s := range states {
+		if !now.Before(s.lockedUntil) && (s.lockouts == 0 || now.Sub(s.lockedUntil) > lockoutDecay) {
+			delete(states, key)
+		}
+	}
+}
+
+// lockoutDuration returns how long the n-th lockout of an address lasts.
+func lockoutDuration(n int) time.Duration {
+	d := LockoutBase
+	for i := 1; i < n && d < LockoutMax; i++ {
+		d *= 2
+	}
+	return min(d, LockoutMax)
+}
+
+// allow takes a request for key from the bucket of the rate limit matching
+// path. If the bucket is empty it returns false and how long until the
+// next request is allowed.
+func (l *Limiter) allow(key, path string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	rate, ok := l.rate(path)
+	if !ok {
+		return true, 0
+	}
+	now := l.now()
+	perToken := rate.Per.Seconds() / float64(rate.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := rate.Prefix + "\x00" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Burst), last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()/perToken, float64(rate.Burst))
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * perToken * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rate returns the rate limit with the longest prefix matching path.
+func (l *Limiter) rate(path string) (RateLimit, bool) {
+	var best RateLimit
+	found := false
+	for _, r := range l.limits.Rates {
+		if strings.HasPrefix(path, r.Prefix) && (!found || len(r.Prefix) > len(best.Prefix)) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// isCodePath reports whether path is one of the code redemption endpoints.
+func (l *Limiter) isCodePath(path string) bool {
+	return l != nil && slices.Contains(l.limits.CodePaths, path)
+}
+
+// tooManyRequests answers 429 with a Retry-After of wait, rounded up to
+// whole seconds.
+func tooManyRequests(w http.ResponseWriter, msg string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// clientAddr returns the IP address of the request's client.
+func clientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
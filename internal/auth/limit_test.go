@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseRateLimits(" /message/send=20/min, /children=5/s,, /auth/=100/h ")
+	if err != nil {
+		t.Fatalf("ParseRateLimits() error: %v", err)
+	}
+	want := []RateLimit{
+		{Prefix: "/message/send", Burst: 20, Per: time.Minute},
+		{Prefix: "/children", Burst: 5, Per: time.Second},
+		{Prefix: "/auth/", Burst: 100, Per: time.Hour},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("limit %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if limits, err := ParseRateLimits(""); err != nil || len(limits) != 0 {
+		t.Errorf("empty: got %+v, %v", limits, err)
+	}
+	for _, bad := range []string{"/message/send", "message=1/s", "/message/=0/s", "/message/=x/s", "/message/=5/day"} {
+		if _, err := ParseRateLimits(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{7, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tc := range tests {
+		if got := lockoutDuration(tc.n); got != tc.want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
+
+// fakeClock is a settable time source for Limiter.
+type fakeClock struct{ t time.Time }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// withClock makes l use c as its time source.
+func withClock(l *Limiter, c *fakeClock) *Limiter {
+	l.now = c.now
+	return l
+}
+
+func TestMiddlewareLockout(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	var lockedAddr string
+	var lockedUntil time.Time
+	limiter := withClock(NewLimiter(Limits{
+		LockoutAttempts: 3,
+		OnLockout:       func(addr string, until time.Time) { lockedAddr, lockedUntil = addr, until },
+	}), clock)
+	handler := Middleware(Credentials{Shared: NewSharedToken("secret", 0)}, protect("/api/"), limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(token, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+		req.RemoteAddr = addr + ":1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Requests without any token are not guesses.
+	for i := 0; i < 5; i++ {
+		do("", "192.0.2.7")
+	}
+	if lockedAddr != "" {
+		t.Fatalf("requests without a token locked out %s", lockedAddr)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := do("wrong", "192.0.2.7"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rec.Code)
+		}
+	}
+	if lockedAddr != "192.0.2.7" || !lockedUntil.Equal(clock.t.Add(time.Minute)) {
+		t.Errorf("OnLockout(%q, %v)", lockedAddr, lockedUntil)
+	}
+
+	// Locked out, even with the right token; other addresses still work.
+	rec := do("secret", "192.0.2.7")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("secret", "192.0.2.8"); rec.Code != http.StatusOK {
+		t.Errorf("other address: expected 200, got %d", rec.Code)
+	}
+
+	// The second lockout lasts twice as long.
+	clock.advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		do("wrong", "192.0.2.7")
+	}
+	if want := clock.t.Add(2 * time.Minute); !lockedUntil.Equal(want) {
+		t.Errorf("second lockout until %v, want %v", lockedUntil, want)
+	}
+
+	// A successful login clears the count.
+	clock.advance(2 * time.Minute)
+	do("wrong", "192.0.2.7")
+	do("wrong", "192.0.2.7")
+	if rec := do("secret", "192.0.2.7"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the lockout, got %d", rec.Code)
+	}
+	do("wrong", "192.0.2.7")
+	if rec := do("secret", "192.0.2.7"); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 after one new failure, got %d", rec.Code)
+	}
+
+	// It does not reset the backoff: the third lockout lasts 4 minutes.
+	for i := 0; i < 3; i++ {
+		do("wrong", "192.0.2.7")
+	}
+	if want := clock.t.Add(4 * time.Minute); !lockedUntil.Equal(want) {
+		t.Errorf("third lockout until %v, want %v", lockedUntil, want)
+	}
+
+	// A day after the last lockout, the backoff starts over.
+	clock.advance(4*time.Minute + lockoutDecay + time.Second)
+	for i := 0; i < 3; i++ {
+		do("wrong", "192.0.2.7")
+	}
+	if want := clock.t.Add(time.Minute); !lockedUntil.Equal(want) {
+		t.Errorf("lockout after decay until %v, want %v", lockedUntil, want)
+	}
+}
+
+func TestMiddlewareLockoutOnWrongAdminHeader(t *testing.T) {
+	t.Parallel()
+
+	limiter := withClock(NewLimiter(Limits{LockoutAttempts: 2}), newFakeClock())
+	creds := Credentials{Shared: NewSharedToken("worker", 0), AdminToken: "admin"}
+	handler := Middleware(creds, protect("/api/"), limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Guessing the admin token next to a valid worker token is still a
+	// failed login.
+	wantCodes := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, want := range wantCodes {
+		req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+		req.Header.Set("Authorization", "Bearer worker")
+		req.Header.Set(AdminHeader, "guess")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("attempt %d: expected %d, got %d", i+1, want, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareLockoutOnCodePaths(t *testing.T) {
+	t.Parallel()
+
+	limiter := withClock(NewLimiter(Limits{LockoutAttempts: 2, CodePaths: []string{"/auth/pair"}}), newFakeClock())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/pair", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(Credentials{Shared: NewSharedToken("secret", 0)}, protect("/api/"), limiter)(mux)
+
+	wantCodes := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, want := range wantCodes {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/pair", nil))
+		if rec.Code != want {
+			t.Errorf("attempt %d: expected %d, got %d", i+1, want, rec.Code)
+		}
+	}
+	// The PWA itself still loads.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("static file: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	limiter := withClock(NewLimiter(Limits{Rates: []RateLimit{
+		{Prefix: "/message/", Burst: 100, Per: time.Minute},
+		{Prefix: "/message/send", Burst: 2, Per: time.Minute},
+	}}), clock)
+	creds := Credentials{Shared: NewSharedToken("shared", 0), AdminToken: "admin"}
+	handler := Middleware(creds, protect("/message/"), limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(token, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("shared", "/message/send"); rec.Code != http.StatusOK {
+			t.Fatalf("send %d: expected 200, got %d", i+1, rec.Code)
+		}
+	}
+	rec := do("shared", "/message/send")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected 429 with Retry-After 30, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Other route groups and other tokens have their own buckets.
+	if rec := do("shared", "/message/clear"); rec.Code != http.StatusOK {
+		t.Errorf("other group: expected 200, got %d", rec.Code)
+	}
+	if rec := do("admin", "/message/send"); rec.Code != http.StatusOK {
+		t.Errorf("other token: expected 200, got %d", rec.Code)
+	}
+
+	// The bucket refills over time.
+	clock.advance(30 * time.Second)
+	if rec := do("shared", "/message/send"); rec.Code != http.StatusOK {
+		t.Errorf("after refill: expected 200, got %d", rec.Code)
+	}
+	if rec := do("shared", "/message/send"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 again, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PairingTTL is how long a pairing code can be redeemed. Codes are short
+// enough to guess, so they expire sooner than enrollment codes.
+const PairingTTL = 5 * time.Minute
+
+// pairingDigits is the length of a pairing code.
+const pairingDigits = 6
+
+// maxPairingFailures is how many wrong pairing codes a pending code
+// survives. A wrong code cannot tell which pending code it was meant for,
+// so it counts against all of them. With one code pending, a guesser has a
+// 5 in a million chance before a new code must be issued, whatever the
+// Limiter allows.
+const maxPairingFailures = 5
+
+// ErrPairingLocked is returned by RedeemPairing for the wrong code that
+// cancelled one or more pending pairing codes.
+var ErrPairingLocked = errors.New("too many wrong pairing codes; ask for a new code")
+
+// Pair creates a one-time numeric code that RedeemPairing exchanges for a
+// token of a new device with the given role. name may be empty to let the
+// device choose its name.
+func (r *Registry) Pair(name string, role Role) (code string, expires time.Time, err error) {
+	expires = time.Now().Add(PairingTTL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired()
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("generating pairing code: %w", err)
+		}
+		code = fmt.Sprintf("%0*d", pairingDigits, n)
+		if _, taken := r.pairings[code]; !taken {
+			break
+		}
+	}
+	r.pairings[code] = enrollment{name: name, role: role, expires: expires}
+	return code, expires, nil
+}
+
+// RedeemPairing exchanges a pairing code for a new device and its token.
+// Each code works once. The device is called name unless the code was
+// issued for a name. A wrong code counts against every pending code, and
+// codes with maxPairingFailures wrong guesses are cancelled; the wrong code
+// that cancels them gets ErrPairingLocked.
+func (r *Registry) RedeemPairing(code, name string) (token string, d Device, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropExpired()
+	e, ok := r.pairings[code]
+	if !ok {
+		cancelled := false
+		for c, p := range r.pairings {
+			p.failures++
+			if p.failures >= maxPairingFailures {
+				delete(r.pairings, c)
+				cancelled = true
+			} else {
+				r.pairings[c] = p
+			}
+		}
+		if cancelled {
+			return "", Device{}, ErrPairingLocked
+		}
+		return "", Device{}, ErrInvalidCode
+	}
+	delete(r.pairings, code)
+
+	if e.name != "" {
+		name = e.name
+	}
+	return r.add(name, e.role, false)
+}
+
+// FormatPairingCode groups a pairing code for reading aloud, e.g.
+// "123 456".
+func FormatPairingCode(code string) string {
+	if len(code) != pairingDigits {
+		return code
+	}
+	return code[:3] + " " + code[3:]
+}
+
+// pairingResponse is the JSON body returned by HandleCreatePairing.
+type pairingResponse struct {
+	Code    string `json:"code"`
+	Expires string `json:"expires"`
+}
+
+// HandleCreatePairing handles POST /auth/pairings (admin only). It creates
+// a pairing code for a device that cannot scan QR codes. The body is
+// optional; like POST /auth/enrollments it may set a name and role.
+func (r *Registry) HandleCreatePairing(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body enrollmentRequest
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	name := strings.TrimSpace(body.Name)
+	if utf8.RuneCountInString(name) > maxDeviceName {
+		http.Error(w, "device name is too long", http.StatusBadRequest)
+		return
+	}
+	role, err := ParseRole(body.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code, expires, err := r.Pair(name, role)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Pairing code issued as %s (valid until %s)", role, expires.Format("15:04"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pairingResponse{Code: code, Expires: expires.Format(time.RFC3339)})
+}
+
+// pairRequest is the JSON body for POST /auth/pair.
+type pairRequest struct {
+	Code string `json:"code"`
+	// Name is used if the code was issued without one.
+	Name string `json:"name,omitempty"`
+}
+
+// HandlePair handles POST /auth/pair. It needs no token: the pairing code
+// is exchanged for the new device's token, once. Spaces and dashes in the
+// code are ignored.
+func (r *Registry) HandlePair(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body pairRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if utf8.RuneCountInString(name) > maxDeviceName {
+		http.Error(w, "device name is too long", http.StatusBadRequest)
+		return
+	}
+	code := strings.NewReplacer(" ", "", "-", "").Replace(body.Code)
+
+	token, d, err := r.RedeemPairing(code, name)
+	switch {
+	case errors.Is(err, ErrPairingLocked):
+		log.Printf("WARNING: pairing codes cancelled after %d wrong codes", maxPairingFailures)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, ErrInvalidCode):
+		http.Error(w, "invalid or expired pairing code", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Printf("WARNING: pairing device: %v", err)
+		http.Error(w, "failed to save device", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Device %q paired as %s (id %s)", d.Name, d.Role, d.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollResponse{
+		Token:  token,
+		Device: DeviceInfo{ID: d.ID, Name: d.Name, Role: d.Role, Created: d.Created},
+	})
+}
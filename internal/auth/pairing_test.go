@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistryPairSingleUse(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+
+	code, _, err := reg.Pair("", RoleWorker)
+	if err != nil {
+		t.Fatalf("Pair() error: %v", err)
+	}
+	if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+		t.Fatalf("expected 6 digits, got %q", code)
+	}
+	token, d, err := reg.RedeemPairing(code, "Tablet Eingang")
+	if err != nil {
+		t.Fatalf("RedeemPairing() error: %v", err)
+	}
+	if d.Name != "Tablet Eingang" || d.Role != RoleWorker {
+		t.Errorf("unexpected device %+v", d)
+	}
+	if got, ok := reg.Lookup(token); !ok || got.ID != d.ID {
+		t.Errorf("Lookup() = %+v, %v", got, ok)
+	}
+	if _, _, err := reg.RedeemPairing(code, "Tablet Eingang"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("second RedeemPairing() = %v, want ErrInvalidCode", err)
+	}
+
+	// A name set by the admin wins over the device's.
+	code, _, _ = reg.Pair("Kasse 2", RoleViewer)
+	if _, d, _ = reg.RedeemPairing(code, "Tablet"); d.Name != "Kasse 2" || d.Role != RoleViewer {
+		t.Errorf("unexpected device %+v", d)
+	}
+	// Without any name the device gets a generated one.
+	code, _, _ = reg.Pair("", RoleWorker)
+	if _, d, _ = reg.RedeemPairing(code, ""); d.Name != "Device "+d.ID {
+		t.Errorf("unexpected device name %q", d.Name)
+	}
+}
+
+func TestWrongPairingCodesCancelPendingCodes(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	code, _, _ := reg.Pair("", RoleWorker)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "000001"
+	}
+
+	// The cap holds whatever the Limiter allows, e.g. with lockouts
+	// disabled or guesses from many addresses.
+	for i := 1; i < maxPairingFailures; i++ {
+		if _, _, err := reg.RedeemPairing(wrong, ""); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: got %v, want ErrInvalidCode", i, err)
+		}
+	}
+	if _, _, err := reg.RedeemPairing(wrong, ""); !errors.Is(err, ErrPairingLocked) {
+		t.Fatalf("attempt %d: got %v, want ErrPairingLocked", maxPairingFailures, err)
+	}
+	if _, _, err := reg.RedeemPairing(code, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("cancelled code: got %v, want ErrInvalidCode", err)
+	}
+
+	// A code issued afterwards starts with a clean count.
+	code, _, _ = reg.Pair("", RoleWorker)
+	if wrong = "000000"; code == wrong {
+		wrong = "000001"
+	}
+	reg.RedeemPairing(wrong, "")
+	if _, _, err := reg.RedeemPairing(code, ""); err != nil {
+		t.Errorf("new code: %v", err)
+	}
+}
+
+func TestPairingHandlers(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	reg.HandleCreatePairing(rec, httptest.NewRequest(http.MethodPost, "/auth/pairings", strings.NewReader(`{"role":"root"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown role, got %d", rec.Code)
+	}
+
+	// The body is optional.
+	rec = httptest.NewRecorder()
+	reg.HandleCreatePairing(rec, httptest.NewRequest(http.MethodPost, "/auth/pairings", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var p pairingResponse
+	json.NewDecoder(rec.Body).Decode(&p)
+
+	rec = httptest.NewRecorder()
+	body := `{"code":"` + FormatPairingCode(p.Code) + `","name":"Tablet"}`
+	reg.HandlePair(rec, httptest.NewRequest(http.MethodPost, "/auth/pair", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var res enrollResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Token == "" || res.Device.Name != "Tablet" || res.Device.Role != RoleWorker {
+		t.Errorf("unexpected pairing %+v", res)
+	}
+
+	// The code works once.
+	rec = httptest.NewRecorder()
+	reg.HandlePair(rec, httptest.NewRequest(http.MethodPost, "/auth/pair", strings.NewReader(`{"code":"`+p.Code+`"}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused code: expected 401, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is what a token holder may do. Each role includes the rights of the
+// roles below it.
+type Role string
+
+const (
+	// RoleViewer can only read status, e.g. a lobby display.
+	RoleViewer Role = "viewer"
+	// RoleWorker can call parents, clear the message and read the list.
+	RoleWorker Role = "worker"
+	// RoleAdmin can also manage children and devices.
+	RoleAdmin Role = "admin"
+)
+
+// rank orders the roles; the zero value ranks below all of them.
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleWorker:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Allows reports whether r includes the rights of need.
+func (r Role) Allows(need Role) bool {
+	return r.rank() >= need.rank() && r.rank() > 0
+}
+
+// ParseRole parses a role name. An empty name is RoleWorker, the role of
+// the shared token.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(strings.ToLower(strings.TrimSpace(s))); r {
+	case "":
+		return RoleWorker, nil
+	case RoleViewer, RoleWorker, RoleAdmin:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown role %q (want viewer, worker or admin)", s)
+}
+
+// AuthMethod restricts how a route's callers may authenticate.
+type AuthMethod int
+
+const (
+	// AuthAny accepts a client certificate or a token.
+	AuthAny AuthMethod = iota
+	// AuthCert accepts only a client certificate, e.g. for routes meant
+	// for fixed tablets.
+	AuthCert
+	// AuthBearer accepts only a token: the Authorization or X-Admin-Token
+	// header, or a session cookie started with one. Client certificates
+	// are ignored.
+	AuthBearer
+)
+
+// Permission requires Role for requests whose path starts with Prefix. An
+// empty Method matches any method; GET also covers HEAD. Auth restricts how
+// the caller may authenticate; the zero value accepts any credential.
+type Permission struct {
+	Method string
+	Prefix string
+	Role   Role
+	Auth   AuthMethod
+}
+
+// required returns the permission a request needs. ok is false if no
+// permission matches, so the path is public. The longest matching prefix
+// wins; for equal prefixes a permission for the request's method beats one
+// for any method.
+func required(perms []Permission, method, path string) (need Permission, ok bool) {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	best := -1
+	for _, p := range perms {
+		if !strings.HasPrefix(path, p.Prefix) {
+			continue
+		}
+		if p.Method != "" && p.Method != method {
+			continue
+		}
+		score := 2 * len(p.Prefix)
+		if p.Method != "" {
+			score++
+		}
+		if score > best {
+			best, need = score, p
+		}
+	}
+	return need, best >= 0
+}
@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// SessionCookie is the name of the session cookie set by HandleCreate.
+const SessionCookie = "cp_session"
+
+// errInvalidSession is returned by identifySession for an unknown or expired
+// session, or one whose credential no longer works. Session IDs cannot be
+// guessed, so it does not count as a failed login.
+var errInvalidSession = errors.New("invalid session")
+
+// Kinds of credential a session can be exchanged for.
+const (
+	credAdmin  = "admin"
+	credShared = "shared"
+	credDevice = "device"
+	credSigned = "signed"
+)
+
+// credential is what a request authenticated with. Sessions keep it, so a
+// session ends when its credential stops working.
+type credential struct {
+	// Kind is one of the cred constants.
+	Kind string `json:"kind"`
+	// Ref identifies the credential: the SHA-256 hash of the admin, shared
+	// or signed token, or the device ID.
+	Ref string `json:"ref"`
+	// Claims and Key are kept for signed tokens: the verified claims, whose
+	// expiry and windows keep applying, and the ID of the key that signed
+	// them, so replacing the key ends the session too.
+	Claims *Claims `json:"claims,omitempty"`
+	Key    string  `json:"key,omitempty"`
+}
+
+// key tells credentials apart for rate limits.
+func (c credential) key() string {
+	switch c.Kind {
+	case credDevice, credSigned:
+		return c.Kind + ":" + c.Ref
+	}
+	return c.Kind
+}
+
+// session is a server-side session record.
+type session struct {
+	Credential credential `json:"credential"`
+	Created    time.Time  `json:"created"`
+	Expires    time.Time  `json:"expires"`
+}
+
+// Sessions keeps the sessions that back session cookies, so the PWA does
+// not have to keep its token where scripts can read it. Sessions are stored
+// by the hash of their ID in a JSON file, so the file does not grant access
+// by itself. It is safe for concurrent use.
+type Sessions struct {
+	mu       sync.Mutex
+	path     string
+	ttl      time.Duration
+	sessions map[string]session
+}
+
+// NewSessions loads the sessions from path; "" keeps them in memory only.
+// New sessions last ttl. A missing file is created on the first session.
+func NewSessions(path string, ttl time.Duration) (*Sessions, error) {
+	s := &Sessions{path: path, ttl: ttl, sessions: make(map[string]session)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	// Older files kept signed tokens in clear instead of their claims; such
+	// sessions are dropped and removed from the file on the next save.
+	maps.DeleteFunc(s.sessions, func(_ string, sess session) bool {
+		return sess.Credential.Kind == credSigned && sess.Credential.Claims == nil
+	})
+	return s, nil
+}
+
+// Len returns the number of sessions that have not expired.
+func (s *Sessions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for _, sess := range s.sessions {
+		if now.Before(sess.Expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// create starts a session for cred and returns its ID.
+func (s *Sessions) create(cred credential) (id string, expires time.Time, err error) {
+	if id, err = GenerateToken(); err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires = now.Add(s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := maps.Clone(s.sessions)
+	sessions[hashToken(id)] = session{Credential: cred, Created: now, Expires: expires}
+	if err := s.save(sessions); err != nil {
+		return "", time.Time{}, err
+	}
+	s.sessions = sessions
+	return id, expires, nil
+}
+
+// lookup returns the credential of the session with the given ID.
+func (s *Sessions) lookup(id string) (credential, bool) {
+	if s == nil || id == "" {
+		return credential{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[hashToken(id)]
+	if !ok || !time.Now().Before(sess.Expires) {
+		return credential{}, false
+	}
+	return sess.Credential, true
+}
+
+// end deletes the session with the given ID, if it exists.
+func (s *Sessions) end(id string) error {
+	hash := hashToken(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[hash]; !ok {
+		return nil
+	}
+	sessions := maps.Clone(s.sessions)
+	delete(sessions, hash)
+	if err := s.save(sessions); err != nil {
+		return err
+	}
+	s.sessions = sessions
+	return nil
+}
+
+// save drops expired sessions and writes the rest to the sessions file
+// atomically. The caller must hold s.mu.
+func (s *Sessions) save(sessions map[string]session) error {
+	now := time.Now()
+	maps.DeleteFunc(sessions, func(_ string, sess session) bool { return !now.Before(sess.Expires) })
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, append(data, '\n'), 0600)
+}
+
+// identifySession returns the identity of the session with the given ID.
+// It fails if the session has expired or its credential no longer works.
+func (c Credentials) identifySession(id string) (Identity, error) {
+	cred, ok := c.Sessions.lookup(id)
+	if !ok {
+		return Identity{}, errInvalidSession
+	}
+
+	var ident Identity
+	switch cred.Kind {
+	case credAdmin:
+		if c.AdminToken == "" || !matches(cred.Ref, hashToken(c.AdminToken)) {
+			return Identity{}, errInvalidSession
+		}
+		ident = Identity{Role: RoleAdmin}
+	case credShared:
+		if !c.Shared.validHash(cred.Ref) {
+			return Identity{}, errInvalidSession
+		}
+		ident = Identity{Role: RoleWorker}
+	case credDevice:
+		if c.Devices == nil {
+			return Identity{}, errInvalidSession
+		}
+		d, ok := c.Devices.lookupID(cred.Ref)
+		if !ok {
+			return Identity{}, errInvalidSession
+		}
+		ident = Identity{Role: d.Role, Name: d.Name, Device: &d}
+	case credSigned:
+		if cred.Claims == nil || c.Signer == nil || !matches(cred.Key, c.Signer.keyID()) {
+			return Identity{}, errInvalidSession
+		}
+		claims := *cred.Claims
+		if err := claims.check(time.Now()); err != nil {
+			return Identity{}, err
+		}
+		ident = Identity{Role: claims.Role, Name: claims.Name, Elevated: claims.Elevated}
+	default:
+		return Identity{}, errInvalidSession
+	}
+	ident.cred = cred
+	ident.session = true
+	ident.ambient = true
+	return ident, nil
+}
+
+// sameOrigin reports whether the request's Origin header names the host it
+// was sent to. Browsers send Origin with every POST, PUT, PATCH and DELETE,
+// so a missing header is treated as cross-origin.
+func sameOrigin(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
+// safeMethod reports whether method only reads.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sessionResponse is the JSON body returned by HandleCreate.
+type sessionResponse struct {
+	Role    Role   `json:"role"`
+	Expires string `json:"expires"`
+}
+
+// HandleCreate handles POST /auth/session. It exchanges the token the
+// request was made with for a session cookie (Secure, HttpOnly,
+// SameSite=Strict), so the PWA can forget the token. The session ends when
+// it expires or when the token stops working.
+func (s *Sessions) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := FromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if id.session {
+		http.Error(w, "already a session; send the token to start a new one", http.StatusBadRequest)
+		return
+	}
+
+	sid, expires, err := s.create(id.cred)
+	if err != nil {
+		log.Printf("WARNING: creating session: %v", err)
+		http.Error(w, "failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    sid,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionResponse{Role: id.Role, Expires: expires.Format(time.RFC3339)})
+}
+
+// HandleDelete handles DELETE /auth/session. It ends the request's session
+// and clears the cookie.
+func (s *Sessions) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		if err := s.end(c.Value); err != nil {
+			log.Printf("WARNING: ending session: %v", err)
+			http.Error(w, "failed to save sessions", http.StatusInternalServerError)
+			return
+		}
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearSessionCookie tells the browser to delete the session cookie.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
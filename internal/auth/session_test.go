@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionServer returns a handler with the session endpoints behind
+// Middleware, and /message/ as a protected route.
+func sessionServer(creds Credentials) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/session", creds.Sessions.HandleCreate)
+	mux.HandleFunc("DELETE /auth/session", creds.Sessions.HandleDelete)
+	mux.HandleFunc("/message/", func(w http.ResponseWriter, r *http.Request) {})
+	perms := []Permission{
+		{Prefix: "/auth/session", Role: RoleViewer},
+		{Prefix: "/message/", Role: RoleWorker},
+	}
+	return Middleware(creds, perms, nil)(mux)
+}
+
+// startSession exchanges token for a session cookie.
+func startSession(t *testing.T, h http.Handler, token string) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/session", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /auth/session: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SessionCookie {
+			return c
+		}
+	}
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+// withCookie sends a request with the session cookie and origin, if any.
+func withCookie(h http.Handler, method, path string, cookie *http.Cookie, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(cookie)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSessionCookie(t *testing.T) {
+	t.Parallel()
+
+	sessions, err := NewSessions("", time.Hour)
+	if err != nil {
+		t.Fatalf("NewSessions() error: %v", err)
+	}
+	h := sessionServer(Credentials{Shared: NewSharedToken("shared", 0), Sessions: sessions})
+	cookie := startSession(t, h, "shared")
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Path != "/" {
+		t.Errorf("unexpected cookie attributes %+v", cookie)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		origin   string
+		wantCode int
+	}{
+		{"read", http.MethodGet, "", http.StatusOK},
+		{"write from the PWA", http.MethodPost, "http://example.com", http.StatusOK},
+		{"write without origin", http.MethodPost, "", http.StatusForbidden},
+		{"write from another site", http.MethodPost, "http://evil.example", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := withCookie(h, tc.method, "/message/send", cookie, tc.origin); rec.Code != tc.wantCode {
+				t.Errorf("expected %d, got %d", tc.wantCode, rec.Code)
+			}
+		})
+	}
+
+	// Bearer tokens need no origin.
+	req := httptest.NewRequest(http.MethodPost, "/message/send", nil)
+	req.Header.Set("Authorization", "Bearer shared")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("bearer write: expected 200, got %d", rec.Code)
+	}
+
+	// A session cannot start another one.
+	if rec := withCookie(h, http.MethodPost, "/auth/session", cookie, "http://example.com"); rec.Code != http.StatusBadRequest {
+		t.Errorf("session from session: expected 400, got %d", rec.Code)
+	}
+
+	// Logging out ends the session and clears the cookie.
+	if rec := withCookie(h, http.MethodDelete, "/auth/session", cookie, "http://example.com"); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", rec.Code)
+	}
+	rec = withCookie(h, http.MethodGet, "/message/test", cookie, "")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("Set-Cookie"), "Max-Age=0") {
+		t.Errorf("after logout: got %d, Set-Cookie %q", rec.Code, rec.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestSessionEndsWithCredential(t *testing.T) {
+	t.Parallel()
+
+	reg, err := NewRegistry(filepath.Join(t.TempDir(), "devices.json"))
+	if err != nil {
+		t.Fatalf("NewRegistry() error: %v", err)
+	}
+	code, _, _ := reg.Enroll("Kasse 1", RoleWorker)
+	deviceToken, d, _ := reg.Redeem(code)
+	shared := NewSharedToken("shared", 0)
+	sessions, _ := NewSessions("", time.Hour)
+	h := sessionServer(Credentials{Shared: shared, Devices: reg, Sessions: sessions})
+
+	deviceCookie := startSession(t, h, deviceToken)
+	sharedCookie := startSession(t, h, "shared")
+	for _, c := range []*http.Cookie{deviceCookie, sharedCookie} {
+		if rec := withCookie(h, http.MethodGet, "/message/test", c, ""); rec.Code != http.StatusOK {
+			t.Fatalf("before: expected 200, got %d", rec.Code)
+		}
+	}
+
+	reg.Revoke(d.ID)
+	if rec := withCookie(h, http.MethodGet, "/message/test", deviceCookie, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked device: expected 401, got %d", rec.Code)
+	}
+	shared.Rotate()
+	if rec := withCookie(h, http.MethodGet, "/message/test", sharedCookie, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("rotated token: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestSessionsPersist(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	sessions, err := NewSessions(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSessions() error: %v", err)
+	}
+	creds := Credentials{Shared: NewSharedToken("shared", 0), Sessions: sessions}
+	cookie := startSession(t, sessionServer(creds), "shared")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sessions: %v", err)
+	}
+	if strings.Contains(string(data), cookie.Value) {
+		t.Error("sessions file must not contain the session ID")
+	}
+
+	creds.Sessions, err = NewSessions(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSessions() reload error: %v", err)
+	}
+	if creds.Sessions.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", creds.Sessions.Len())
+	}
+	if rec := withCookie(sessionServer(creds), http.MethodGet, "/message/test", cookie, ""); rec.Code != http.StatusOK {
+		t.Errorf("after reload: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestSignedSessionKeepsClaimsNotToken(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	sessions, err := NewSessions(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSessions() error: %v", err)
+	}
+	signer := NewSigner([]byte("test key"))
+	token, _ := signer.Issue(Claims{Name: "Gast", Role: RoleWorker, Expires: time.Now().Add(time.Hour).Unix()})
+	creds := Credentials{Shared: NewSharedToken("shared", 0), Signer: signer, Sessions: sessions}
+	cookie := startSession(t, sessionServer(creds), token)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sessions: %v", err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("sessions file must not contain the signed token")
+	}
+
+	// The stored claims are checked on every use.
+	creds.Sessions, _ = NewSessions(path, time.Hour)
+	if rec := withCookie(sessionServer(creds), http.MethodGet, "/message/test", cookie, ""); rec.Code != http.StatusOK {
+		t.Errorf("after reload: expected 200, got %d", rec.Code)
+	}
+	creds.Sessions.mu.Lock()
+	for id, sess := range creds.Sessions.sessions {
+		sess.Credential.Claims.Expires = time.Now().Add(-time.Second).Unix()
+		creds.Sessions.sessions[id] = sess
+	}
+	creds.Sessions.mu.Unlock()
+	if rec := withCookie(sessionServer(creds), http.MethodGet, "/message/test", cookie, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired claims: expected 401, got %d", rec.Code)
+	}
+
+	// Replacing the signing key ends the session.
+	creds.Sessions, _ = NewSessions(path, time.Hour)
+	creds.Signer = NewSigner([]byte("new key"))
+	if rec := withCookie(sessionServer(creds), http.MethodGet, "/message/test", cookie, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("new signing key: expected 401, got %d", rec.Code)
+	}
+}
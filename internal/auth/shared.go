@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// SharedToken is the token in the QR code. Rotate replaces it; the previous
+// token keeps working for a grace period so phones in use are not locked out
+// mid-service. It is safe for concurrent use.
+type SharedToken struct {
+	mu            sync.RWMutex
+	current       string
+	previous      string
+	previousUntil time.Time
+	grace         time.Duration
+	// path is the file the token is saved to, or "" to keep it in memory.
+	path string
+
+	// baseURL and announce are used by Rotate.
+	baseURL  string
+	announce func(url string)
+}
+
+// NewSharedToken returns a SharedToken with the given token. After a
+// rotation the previous token is accepted for grace.
+func NewSharedToken(token string, grace time.Duration) *SharedToken {
+	return &SharedToken{current: token, grace: grace}
+}
+
+// LoadSharedToken reads the token saved at path by an earlier run, so a
+// restart does not force everyone to rescan. If the file does not exist, a
+// random token is generated and saved; created reports this. Rotations are
+// saved to the same file.
+func LoadSharedToken(path string, grace time.Duration) (s *SharedToken, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		if token, err = GenerateToken(); err != nil {
+			return nil, false, err
+		}
+		if err := atomicfile.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			return nil, false, fmt.Errorf("saving auth token: %w", err)
+		}
+		created = true
+	}
+	s = NewSharedToken(token, grace)
+	s.path = path
+	return s, created, nil
+}
+
+// SetBaseURL sets the PWA URL that the QR code link points to, e.g.
+// "http://192.168.1.20:8080".
+func (s *SharedToken) SetBaseURL(url string) {
+	s.baseURL = strings.TrimRight(url, "/")
+}
+
+// SetAnnouncer sets a function that is called with the new QR code link
+// after each rotation, e.g. to print it on the terminal. Nil disables it.
+func (s *SharedToken) SetAnnouncer(announce func(url string)) {
+	s.announce = announce
+}
+
+// Current returns the token to hand out.
+func (s *SharedToken) Current() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// URL returns the QR code link with the current token.
+func (s *SharedToken) URL() string {
+	return s.baseURL + "#token=" + s.Current()
+}
+
+// Valid reports whether provided is the current token, or the previous one
+// within its grace period.
+func (s *SharedToken) Valid(provided string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if matches(provided, s.current) {
+		return true
+	}
+	return time.Now().Before(s.previousUntil) && matches(provided, s.previous)
+}
+
+// validHash is Valid for the SHA-256 hash of a token, as kept in session
+// records.
+func (s *SharedToken) validHash(hash string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if matches(hash, hashToken(s.current)) {
+		return true
+	}
+	return s.previous != "" && time.Now().Before(s.previousUntil) && matches(hash, hashToken(s.previous))
+}
+
+// Rotate replaces the token with a new random one and returns it with the
+// time the old token stops working. The new token is saved if the token was
+// loaded from a file, and announced.
+func (s *SharedToken) Rotate() (token string, previousUntil time.Time, err error) {
+	token, err = GenerateToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	if s.path != "" {
+		if err := atomicfile.WriteFile(s.path, []byte(token+"\n"), 0600); err != nil {
+			s.mu.Unlock()
+			return "", time.Time{}, fmt.Errorf("saving auth token: %w", err)
+		}
+	}
+	s.previous, s.current = s.current, token
+	s.previousUntil = time.Now().Add(s.grace)
+	previousUntil = s.previousUntil
+	s.mu.Unlock()
+
+	log.Printf("Auth token rotated; the previous token is accepted until %s", previousUntil.Format("15:04"))
+	if s.announce != nil {
+		s.announce(s.URL())
+	}
+	return token, previousUntil, nil
+}
+
+// rotateResponse is the JSON body returned by HandleRotate.
+type rotateResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+	// QR is the URL as a PNG QR code in a data: URI.
+	QR string `json:"qr"`
+	// PreviousValidUntil is when the old token stops working.
+	PreviousValidUntil string `json:"previousValidUntil"`
+}
+
+// HandleRotate handles POST /auth/token/rotate (admin only). It rotates the
+// shared token and returns the new one with its QR code link.
+func (s *SharedToken) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, until, err := s.Rotate()
+	if err != nil {
+		log.Printf("WARNING: rotating auth token: %v", err)
+		http.Error(w, "failed to rotate token", http.StatusInternalServerError)
+		return
+	}
+	url := s.URL()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rotateResponse{
+		Token:              token,
+		URL:                url,
+		QR:                 qrDataURI(url),
+		PreviousValidUntil: until.Format(time.RFC3339),
+	})
+}
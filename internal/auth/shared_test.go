@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedTokenRotateGrace(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		grace     time.Duration
+		oldWorks  bool
+		wantUntil bool
+	}{
+		{"within grace", time.Hour, true, true},
+		{"no grace", 0, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSharedToken("old", tc.grace)
+			token, until, err := s.Rotate()
+			if err != nil {
+				t.Fatalf("Rotate() error: %v", err)
+			}
+			if token == "old" || s.Current() != token {
+				t.Fatalf("expected a new current token, got %q", s.Current())
+			}
+			if !s.Valid(token) {
+				t.Error("new token must be valid")
+			}
+			if s.Valid("old") != tc.oldWorks {
+				t.Errorf("old token valid = %v, want %v", !tc.oldWorks, tc.oldWorks)
+			}
+			if time.Now().Before(until) != tc.wantUntil {
+				t.Errorf("unexpected grace end %v", until)
+			}
+			if s.Valid("") {
+				t.Error("empty token must not be valid")
+			}
+		})
+	}
+}
+
+func TestLoadSharedTokenPersists(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "token")
+	s, created, err := LoadSharedToken(path, time.Minute)
+	if err != nil || !created {
+		t.Fatalf("LoadSharedToken() = %v, %v", created, err)
+	}
+	first := s.Current()
+
+	again, created, err := LoadSharedToken(path, time.Minute)
+	if err != nil || created || again.Current() != first {
+		t.Fatalf("reload = %q, %v, %v; want %q", again.Current(), created, err, first)
+	}
+
+	rotated, _, err := again.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != rotated {
+		t.Errorf("token file = %q, want rotated token", data)
+	}
+}
+
+func TestHandleRotate(t *testing.T) {
+	t.Parallel()
+
+	s := NewSharedToken("old", time.Hour)
+	s.SetBaseURL("http://192.168.1.20:8080")
+	var announced string
+	s.SetAnnouncer(func(url string) { announced = url })
+
+	rec := httptest.NewRecorder()
+	s.HandleRotate(rec, httptest.NewRequest(http.MethodPost, "/auth/token/rotate", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var res rotateResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.URL != "http://192.168.1.20:8080#token="+res.Token || announced != res.URL {
+		t.Errorf("unexpected URL %q (announced %q)", res.URL, announced)
+	}
+	if res.Token != s.Current() || res.QR == "" || res.PreviousValidUntil == "" {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
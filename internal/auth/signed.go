@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// signedPrefix starts every signed token, so the format can change later.
+const signedPrefix = "v1."
+
+// Errors returned by Signer.Verify.
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrOutsideWindow = errors.New("token not valid at this time")
+)
+
+// weekdays maps the day names used in windows to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a weekly time span in which a signed token works, in the
+// server's local time.
+type Window struct {
+	// Days are "sun" to "sat"; empty means every day.
+	Days []string `json:"days,omitempty"`
+	// From and To are "15:04" times; To must be later than From.
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// normalize checks the day names and times of w and returns it with
+// lower-case days and zero-padded times, so contains can compare strings.
+func (w Window) normalize() (Window, error) {
+	days := make([]string, len(w.Days))
+	for i, d := range w.Days {
+		days[i] = strings.ToLower(strings.TrimSpace(d))
+		if _, ok := weekdays[days[i]]; !ok {
+			return Window{}, fmt.Errorf("unknown day %q (want sun, mon, ... sat)", d)
+		}
+	}
+	from, err := time.Parse("15:04", w.From)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid from time %q", w.From)
+	}
+	to, err := time.Parse("15:04", w.To)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid to time %q", w.To)
+	}
+	if !to.After(from) {
+		return Window{}, fmt.Errorf("window %s–%s ends before it starts", w.From, w.To)
+	}
+	return Window{Days: days, From: from.Format("15:04"), To: to.Format("15:04")}, nil
+}
+
+// contains reports whether t falls inside w.
+func (w Window) contains(t time.Time) bool {
+	if len(w.Days) > 0 && !slices.ContainsFunc(w.Days, func(d string) bool { return weekdays[d] == t.Weekday() }) {
+		return false
+	}
+	clock := t.Format("15:04")
+	return clock >= w.From && clock < w.To
+}
+
+// Claims is what a signed token grants.
+type Claims struct {
+	// Name labels the token holder in logs, like a device name.
+	Name string `json:"name,omitempty"`
+	Role Role   `json:"role"`
+	// Expires is a Unix time.
+	Expires int64 `json:"exp"`
+	// Windows limit the token to weekly time spans. Empty means any time
+	// before Expires.
+	Windows []Window `json:"win,omitempty"`
+	// Elevated marks a token from Elevator.HandleElevate.
+	Elevated bool `json:"elv,omitempty"`
+}
+
+// Signer issues and verifies signed tokens: the claims with an HMAC-SHA256
+// over them, so they can be checked without storing anything per token.
+// Tokens cannot be revoked one by one; replacing the key revokes them all.
+type Signer struct {
+	key []byte
+
+	// baseURL is used by HandleIssue.
+	baseURL string
+}
+
+// NewSigner returns a Signer with the given key.
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// LoadSigner reads the signing key from path, or generates a random key and
+// saves it there if the file does not exist; created reports this.
+func LoadSigner(path string) (s *Signer, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, false, fmt.Errorf("generating signing key: %w", err)
+		}
+		if err := atomicfile.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, false, fmt.Errorf("saving signing key: %w", err)
+		}
+		created = true
+	}
+	return NewSigner(key), created, nil
+}
+
+// SetBaseURL sets the PWA URL that issued links point to, e.g.
+// "http://192.168.1.20:8080".
+func (s *Signer) SetBaseURL(url string) {
+	s.baseURL = strings.TrimRight(url, "/")
+}
+
+// Issue returns a signed token for c.
+func (s *Signer) Issue(c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	body := signedPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(s.sign(body)), nil
+}
+
+// Verify checks the signature of token and returns its claims if the token
+// may be used at now.
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	if s == nil || !strings.HasPrefix(token, signedPrefix) {
+		return Claims{}, ErrInvalidToken
+	}
+	dot := strings.LastIndexByte(token, '.')
+	body, sig := token[:dot], token[dot+1:]
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, s.sign(body)) {
+		return Claims{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(body, signedPrefix))
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.Role.rank() == 0 {
+		return Claims{}, ErrInvalidToken
+	}
+	return c, c.check(now)
+}
+
+// check returns ErrTokenExpired or ErrOutsideWindow if c does not grant
+// access at now.
+func (c Claims) check(now time.Time) error {
+	if !now.Before(time.Unix(c.Expires, 0)) {
+		return ErrTokenExpired
+	}
+	if len(c.Windows) > 0 && !slices.ContainsFunc(c.Windows, func(w Window) bool { return w.contains(now) }) {
+		return ErrOutsideWindow
+	}
+	return nil
+}
+
+// keyID identifies the signing key without revealing it, so sessions can
+// tell that the key was replaced since their token was verified.
+func (s *Signer) keyID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sign("session key id")[:8])
+}
+
+// sign returns the HMAC of body.
+func (s *Signer) sign(body string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(body))
+	return mac.Sum(nil)
+}
+
+// issueRequest is the JSON body for POST /auth/tokens.
+type issueRequest struct {
+	Name string `json:"name"`
+	// Role defaults to worker.
+	Role string `json:"role,omitempty"`
+	// Either Expires (RFC 3339) or Hours sets the expiry.
+	Expires string   `json:"expires,omitempty"`
+	Hours   int      `json:"hours,omitempty"`
+	Windows []Window `json:"windows,omitempty"`
+}
+
+// issueResponse is the JSON body returned by HandleIssue.
+type issueResponse struct {
+	Token   string `json:"token"`
+	URL     string `json:"url"`
+	QR      string `json:"qr"`
+	Expires string `json:"expires"`
+}
+
+// HandleIssue handles POST /auth/tokens (admin only). It issues a signed
+// token, e.g. for a visiting volunteer, and returns it with a QR code link
+// that works like the shared token's.
+func (s *Signer) HandleIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req issueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	c, err := req.claims(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.Issue(c)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Unix(c.Expires, 0)
+	log.Printf("Signed token issued for %q as %s (expires %s)", c.Name, c.Role, expires.Format(time.DateTime))
+
+	url := s.baseURL + "#token=" + token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issueResponse{
+		Token:   token,
+		URL:     url,
+		QR:      qrDataURI(url),
+		Expires: expires.Format(time.RFC3339),
+	})
+}
+
+// claims validates req and turns it into token claims.
+func (req issueRequest) claims(now time.Time) (Claims, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return Claims{}, errors.New("name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxDeviceName {
+		return Claims{}, errors.New("name is too long")
+	}
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	var expires time.Time
+	switch {
+	case req.Expires != "" && req.Hours != 0:
+		return Claims{}, errors.New("set either expires or hours")
+	case req.Expires != "":
+		if expires, err = time.Parse(time.RFC3339, req.Expires); err != nil {
+			return Claims{}, fmt.Errorf("invalid expires %q (want RFC 3339)", req.Expires)
+		}
+	case req.Hours > 0:
+		expires = now.Add(time.Duration(req.Hours) * time.Hour)
+	default:
+		return Claims{}, errors.New("set expires or a positive number of hours")
+	}
+	if !expires.After(now) {
+		return Claims{}, errors.New("expires must be in the future")
+	}
+
+	var windows []Window
+	for _, w := range req.Windows {
+		w, err := w.normalize()
+		if err != nil {
+			return Claims{}, err
+		}
+		windows = append(windows, w)
+	}
+	return Claims{Name: name, Role: role, Expires: expires.Unix(), Windows: windows}, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerVerify(t *testing.T) {
+	t.Parallel()
+
+	s := NewSigner([]byte("test key"))
+	// Sunday 2026-10-18 10:30 local time.
+	now := time.Date(2026, 10, 18, 10, 30, 0, 0, time.Local)
+	sunday := []Window{{Days: []string{"sun"}, From: "09:00", To: "13:00"}}
+
+	tests := []struct {
+		name    string
+		claims  Claims
+		at      time.Time
+		wantErr error
+	}{
+		{"valid", Claims{Role: RoleWorker, Expires: now.Add(time.Hour).Unix()}, now, nil},
+		{"expired", Claims{Role: RoleWorker, Expires: now.Add(-time.Minute).Unix()}, now, ErrTokenExpired},
+		{"inside window", Claims{Role: RoleWorker, Expires: now.AddDate(0, 1, 0).Unix(), Windows: sunday}, now, nil},
+		{"after window", Claims{Role: RoleWorker, Expires: now.AddDate(0, 1, 0).Unix(), Windows: sunday}, now.Add(3 * time.Hour), ErrOutsideWindow},
+		{"wrong day", Claims{Role: RoleWorker, Expires: now.AddDate(0, 1, 0).Unix(), Windows: sunday}, now.AddDate(0, 0, 1), ErrOutsideWindow},
+		{"no role", Claims{Expires: now.Add(time.Hour).Unix()}, now, ErrInvalidToken},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := s.Issue(tc.claims)
+			if err != nil {
+				t.Fatalf("Issue() error: %v", err)
+			}
+			if _, err := s.Verify(token, tc.at); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Verify() = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignerRejectsForgedTokens(t *testing.T) {
+	t.Parallel()
+
+	s := NewSigner([]byte("test key"))
+	token, _ := s.Issue(Claims{Role: RoleWorker, Expires: time.Now().Add(time.Hour).Unix()})
+	admin, _ := s.Issue(Claims{Role: RoleAdmin, Expires: time.Now().Add(time.Hour).Unix()})
+
+	forged := []string{
+		// Admin claims with the worker token's signature.
+		admin[:strings.LastIndexByte(admin, '.')] + token[strings.LastIndexByte(token, '.'):],
+		token + "x",
+		"v1.",
+		"v1.e30",
+	}
+	other, _ := NewSigner([]byte("other key")).Issue(Claims{Role: RoleAdmin, Expires: time.Now().Add(time.Hour).Unix()})
+	forged = append(forged, other)
+
+	for _, f := range forged {
+		if _, err := s.Verify(f, time.Now()); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(%.20q) = %v, want ErrInvalidToken", f, err)
+		}
+	}
+}
+
+func TestLoadSignerPersists(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "signing.key")
+	s, created, err := LoadSigner(path)
+	if err != nil || !created {
+		t.Fatalf("LoadSigner() = %v, %v", created, err)
+	}
+	token, _ := s.Issue(Claims{Role: RoleWorker, Expires: time.Now().Add(time.Hour).Unix()})
+
+	again, created, err := LoadSigner(path)
+	if err != nil || created {
+		t.Fatalf("reload = %v, %v", created, err)
+	}
+	if _, err := again.Verify(token, time.Now()); err != nil {
+		t.Errorf("token from before the reload: %v", err)
+	}
+}
+
+func TestMiddlewareSignedTokenReasons(t *testing.T) {
+	t.Parallel()
+
+	s := NewSigner([]byte("test key"))
+	handler := Middleware(Credentials{Shared: NewSharedToken("shared", 0), Signer: s}, protect("/message/"), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestDevice(r) != "Gast" {
+			t.Errorf("RequestDevice() = %q, want token name", RequestDevice(r))
+		}
+	}))
+
+	now := time.Now()
+	valid, _ := s.Issue(Claims{Name: "Gast", Role: RoleWorker, Expires: now.Add(time.Hour).Unix()})
+	expired, _ := s.Issue(Claims{Name: "Gast", Role: RoleWorker, Expires: now.Add(-time.Hour).Unix()})
+	// A window that ended a minute ago; just after midnight, one late last
+	// night.
+	from, to := now.Add(-2*time.Minute).Format("15:04"), now.Add(-time.Minute).Format("15:04")
+	if to <= from {
+		from, to = "23:58", "23:59"
+	}
+	outside, _ := s.Issue(Claims{Name: "Gast", Role: RoleWorker, Expires: now.Add(time.Hour).Unix(), Windows: []Window{{From: from, To: to}}})
+
+	tests := []struct {
+		name       string
+		token      string
+		wantCode   int
+		wantReason string
+	}{
+		{"valid", valid, http.StatusOK, ""},
+		{"expired", expired, http.StatusUnauthorized, "expired"},
+		{"outside window", outside, http.StatusForbidden, "outside_window"},
+		{"forged", valid + "x", http.StatusUnauthorized, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/message/send", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode || rec.Header().Get(ErrorHeader) != tc.wantReason {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Header().Get(ErrorHeader), tc.wantCode, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestHandleIssue(t *testing.T) {
+	t.Parallel()
+
+	s := NewSigner([]byte("test key"))
+	s.SetBaseURL("http://192.168.1.20:8080")
+
+	bad := []string{
+		`{"name":"Gast"}`,
+		`{"name":"","hours":4}`,
+		`{"name":"Gast","hours":4,"expires":"2030-01-01T00:00:00Z"}`,
+		`{"name":"Gast","expires":"2000-01-01T00:00:00Z"}`,
+		`{"name":"Gast","hours":4,"windows":[{"days":["sunday"],"from":"09:00","to":"13:00"}]}`,
+		`{"name":"Gast","hours":4,"windows":[{"from":"13:00","to":"09:00"}]}`,
+	}
+	for _, body := range bad {
+		rec := httptest.NewRecorder()
+		s.HandleIssue(rec, httptest.NewRequest(http.MethodPost, "/auth/tokens", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	body := `{"name":"Gast","role":"viewer","hours":4,"windows":[{"days":["Sun"],"from":"9:00","to":"13:00"}]}`
+	s.HandleIssue(rec, httptest.NewRequest(http.MethodPost, "/auth/tokens", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var res issueResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.URL != "http://192.168.1.20:8080#token="+res.Token || res.QR == "" {
+		t.Errorf("unexpected response %+v", res)
+	}
+
+	// Decode the claims without checking the time.
+	claims, err := s.Verify(res.Token, time.Now())
+	if err != nil && !errors.Is(err, ErrOutsideWindow) {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	if claims.Role != RoleViewer || len(claims.Windows) != 1 {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	// Days and times are normalised.
+	if w := claims.Windows[0]; strings.Join(w.Days, ",") != "sun" || w.From != "09:00" || w.To != "13:00" {
+		t.Errorf("unexpected window %+v", w)
+	}
+}
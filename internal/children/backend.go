@@ -0,0 +1,190 @@
+package children
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// Backend persists the children list. FileBackend, the default, keeps it in
+// children.json; BoltBackend keeps it in an embedded key-value database.
+type Backend interface {
+	// Load returns the stored list. An empty store yields an empty list.
+	Load() (Data, error)
+	// Save replaces the stored list atomically.
+	Save(Data) error
+	// String describes where the list is stored, for logs and the status
+	// endpoint.
+	String() string
+
+	// AppendChange adds c to the end of the change history.
+	AppendChange(c Change) error
+	// Changes returns the change history, oldest first.
+	Changes() ([]Change, error)
+	// ReplaceChanges replaces the whole history. It is only used to erase
+	// children from it.
+	ReplaceChanges(changes []Change) error
+}
+
+// FileBackend stores the list in a JSON file that admins can edit by hand.
+// Each save keeps the previous content as a rotating backup.
+type FileBackend struct {
+	path string
+}
+
+// NewFileBackend returns a Backend for the JSON file at path.
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{path: path}
+}
+
+// Load reads and parses the file. A missing file yields an empty list; a
+// file that cannot be parsed yields a *parseError.
+func (b *FileBackend) Load() (Data, error) {
+	return readContents(b.path)
+}
+
+// Save writes the list atomically after rotating the backups.
+func (b *FileBackend) Save(d Data) error {
+	data, err := d.encode()
+	if err != nil {
+		return fmt.Errorf("marshalling children: %w", err)
+	}
+	if err := rotateBackups(b.path); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(b.path, data, 0644); err != nil {
+		return fmt.Errorf("writing children file %q: %w", b.path, err)
+	}
+	return nil
+}
+
+func (b *FileBackend) String() string { return b.path }
+
+// Bucket names used by BoltBackend.
+var (
+	boltChildren = []byte("children")
+	boltFamilies = []byte("families")
+)
+
+// BoltBackend stores the list in a bbolt database: one key per child in the
+// "children" bucket and one per family in the "families" bucket. Children
+// are keyed by their big-endian position in the list, so the order is kept
+// and children with the same name stay separate records. The values are the
+// JSON form of Child and Family. Every save is a single transaction.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend returns a Backend that uses the open database db. The caller
+// owns db and closes it.
+func NewBoltBackend(db *bolt.DB) *BoltBackend {
+	return &BoltBackend{db: db}
+}
+
+// Load reads all children and families.
+func (b *BoltBackend) Load() (Data, error) {
+	d := Data{Children: []Child{}}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(boltChildren); bucket != nil {
+			err := bucket.ForEach(func(_, v []byte) error {
+				var c Child
+				if err := json.Unmarshal(v, &c); err != nil {
+					return err
+				}
+				d.Children = append(d.Children, c)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if bucket := tx.Bucket(boltFamilies); bucket != nil {
+			return bucket.ForEach(func(k, v []byte) error {
+				var f Family
+				if err := json.Unmarshal(v, &f); err != nil {
+					return err
+				}
+				if d.Families == nil {
+					d.Families = make(map[string]Family)
+				}
+				d.Families[string(k)] = f
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("reading children from %s: %w", b, err)
+	}
+	return d, nil
+}
+
+// Save replaces all children and families in one transaction.
+func (b *BoltBackend) Save(d Data) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltChildren, boltFamilies} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		children, err := tx.CreateBucket(boltChildren)
+		if err != nil {
+			return err
+		}
+		for i, c := range d.Children {
+			v, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := children.Put(binary.BigEndian.AppendUint64(nil, uint64(i)), v); err != nil {
+				return err
+			}
+		}
+		families, err := tx.CreateBucket(boltFamilies)
+		if err != nil {
+			return err
+		}
+		for id, f := range d.Families {
+			v, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+			if err := families.Put([]byte(id), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("writing children to %s: %w", b, err)
+	}
+	return nil
+}
+
+func (b *BoltBackend) String() string { return "bolt:" + b.db.Path() }
+
+// Migrate copies the list and its change history from one backend to
+// another, replacing whatever the destination held, and returns the number
+// of children copied.
+func Migrate(from, to Backend) (int, error) {
+	d, err := from.Load()
+	if err != nil {
+		return 0, err
+	}
+	changes, err := from.Changes()
+	if err != nil {
+		return 0, err
+	}
+	if err := to.Save(d); err != nil {
+		return 0, err
+	}
+	if err := to.ReplaceChanges(changes); err != nil {
+		return 0, err
+	}
+	return len(d.Children), nil
+}
@@ -0,0 +1,99 @@
+package children
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltBackendRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t)
+	s, err := NewStoreWithBackend(NewBoltBackend(db))
+	if err != nil {
+		t.Fatalf("NewStoreWithBackend() error: %v", err)
+	}
+	if err := s.Watch(); err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+	defer s.Close()
+
+	s.mu.Lock()
+	s.data.Children = []Child{{Name: "Ben", Family: "mueller"}, {Name: "Anna", Family: "mueller"}}
+	s.data.Families = map[string]Family{"mueller": {Surname: "Müller"}}
+	err = s.save()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatalf("save() error: %v", err)
+	}
+
+	s2, err := NewStoreWithBackend(NewBoltBackend(db))
+	if err != nil {
+		t.Fatalf("reload error: %v", err)
+	}
+	if names := s2.Names(); len(names) != 2 || names[0] != "Anna" {
+		t.Errorf("unexpected names %v", names)
+	}
+	if _, surname, ok := s2.Family("mueller"); !ok || surname != "Müller" {
+		t.Errorf("family not stored")
+	}
+}
+
+func TestBoltBackendKeepsSameNames(t *testing.T) {
+	t.Parallel()
+
+	b := NewBoltBackend(openTestDB(t))
+	want := []Child{{Name: "Ben"}, {Name: "Anna", Family: "a"}, {Name: "Anna", Family: "b"}}
+	if err := b.Save(Data{Children: want}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	d, err := b.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(d.Children) != len(want) {
+		t.Fatalf("got %+v, want %+v", d.Children, want)
+	}
+	for i := range want {
+		if d.Children[i].Name != want[i].Name || d.Children[i].Family != want[i].Family {
+			t.Errorf("child %d = %+v, want %+v", i, d.Children[i], want[i])
+		}
+	}
+}
+
+func TestMigrateFileToBolt(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(`["Anna",{"name":"Ben","private":true,"code":"K7M"}]`), 0644)
+
+	db := openTestDB(t)
+	n, err := Migrate(NewFileBackend(path), NewBoltBackend(db))
+	if err != nil {
+		t.Fatalf("Migrate() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 children copied, got %d", n)
+	}
+
+	s, err := NewStoreWithBackend(NewBoltBackend(db))
+	if err != nil {
+		t.Fatalf("NewStoreWithBackend() error: %v", err)
+	}
+	if code, ok := s.PickupCode("Ben"); !ok || code != "K7M" {
+		t.Errorf("PickupCode(Ben) = %q, %v", code, ok)
+	}
+}
@@ -2,45 +2,124 @@ package children
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strings"
+	"slices"
 	"sync"
+	"time"
+
+	"golang.org/x/text/language"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+	"github.com/tafli/CallingParents/internal/auth"
+	"github.com/tafli/CallingParents/internal/sanitize"
 )
 
 // Store loads and serves a list of children's names from a JSON file.
 type Store struct {
-	mu       sync.RWMutex
-	names    []string
+	mu      sync.RWMutex
+	data    Data
+	backend Backend
+	// filePath is the children file when the list is stored in a file, or
+	// empty for other backends. Watching and backups only apply to files.
 	filePath string
+	loadedAt time.Time
+	loadErr  error
+	logger   *activitylog.Logger
+
+	// locale selects the collation used to sort names.
+	locale language.Tag
+	// duplicates lists groups of names that only differ in case, spacing
+	// or Unicode normalisation.
+	duplicates [][]string
+	// privacyMode shows every child's pickup code instead of the name.
+	privacyMode bool
+	// sanitizer cleans new names before they are stored.
+	sanitizer *sanitize.Sanitizer
+	// version is the latest version in the change history; historyLen is
+	// the number of changes it holds.
+	version    int
+	historyLen int
+	// retention is how long a child may go uncalled before it is removed
+	// by Prune. Zero keeps children forever.
+	retention time.Duration
+	// lastSeenDirty is set while LastSeen days recorded by touch are not
+	// saved yet; lastSeenTimer saves them.
+	lastSeenDirty bool
+	lastSeenTimer *time.Timer
+
+	// lastActive maps search keys to the time a child was last called;
+	// used to rank search results.
+	lastActive map[string]time.Time
+	// searchKeys maps each name on the list to searchKey(name). It is
+	// rebuilt whenever the list changes; see indexSearchKeys.
+	searchKeys map[string]string
+
+	stopWatch chan struct{}
+	watchDone chan struct{}
+	watchMode string
+
+	subMu       sync.Mutex
+	subscribers map[chan struct{}]struct{}
 }
 
 // NewStore creates a Store that reads names from the given JSON file.
-// The file must contain a JSON array of strings, e.g. ["Anna","Ben","Clara"].
-// If the file does not exist, the store starts with an empty list.
+// The file contains a JSON array of names, e.g. ["Anna","Ben","Clara"], or
+// once families are used, an object with "children" and "families" (see
+// parseContents). If the file does not exist, the store starts with an
+// empty list.
+// Call Watch to pick up manual edits to the file while the server runs.
 func NewStore(filePath string) (*Store, error) {
-	s := &Store{filePath: filePath}
+	return NewStoreWithBackend(NewFileBackend(filePath))
+}
+
+// NewStoreWithBackend creates a Store that keeps the list in the given
+// backend.
+func NewStoreWithBackend(b Backend) (*Store, error) {
+	s := &Store{backend: b}
+	if fb, ok := b.(*FileBackend); ok {
+		s.filePath = fb.path
+	}
 	if err := s.load(); err != nil {
 		return nil, err
 	}
+	if err := s.loadVersion(); err != nil {
+		return nil, fmt.Errorf("reading children history: %w", err)
+	}
 	return s, nil
 }
 
+// SetLocale sets the locale whose collation rules are used to sort names,
+// e.g. "de" or "en", and re-sorts the current list.
+func (s *Store) SetLocale(locale string) error {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locale = tag
+	sortChildren(s.data.Children, tag)
+	return nil
+}
+
 // Names returns a copy of the current children list.
 func (s *Store) Names() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	out := make([]string, len(s.names))
-	copy(out, s.names)
-	return out
+	return s.data.names()
 }
 
 // ServeHTTP handles GET, POST, and DELETE /children.
 // GET returns the names as a JSON array.
 // POST accepts {"name":"..."} and adds the name to the list, persisting to disk.
 // DELETE accepts {"name":"..."} and removes the name from the list, persisting to disk.
+// Every response carries an ETag for the list. GET honours If-None-Match with
+// 304 Not Modified; POST and DELETE honour If-Match and answer 409 Conflict
+// with the current list when the client's version is stale.
 func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -54,21 +133,20 @@ func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Store) handleGet(w http.ResponseWriter, _ *http.Request) {
-	// Re-read from disk so manual edits to children.json are picked up.
-	s.mu.Lock()
-	if err := s.load(); err != nil {
-		s.mu.Unlock()
-		http.Error(w, "failed to read children file", http.StatusInternalServerError)
-		return
-	}
-	names := s.names
-	s.mu.Unlock()
+func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
+	// Manual edits to children.json are picked up by the watcher (see Watch),
+	// so GET only serves the in-memory list.
+	var reply listReply
+	s.mu.RLock()
+	s.replyNames(&reply, http.StatusOK)
+	s.mu.RUnlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(names); err != nil {
-		http.Error(w, "encoding error", http.StatusInternalServerError)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, reply.etag) {
+		w.Header().Set("ETag", reply.etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
+	reply.write(w)
 }
 
 // addRequest is the expected JSON body for POST /children.
@@ -83,40 +161,51 @@ func (s *Store) handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := strings.TrimSpace(req.Name)
+	name := normalizeName(req.Name)
 	if name == "" {
 		http.Error(w, "name must not be empty", http.StatusBadRequest)
 		return
 	}
 
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check for duplicate (case-sensitive).
-	for _, existing := range s.names {
-		if existing == name {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(s.names)
-			return
-		}
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+
+	name = s.cleanName(w, name)
+	if name == "" {
+		http.Error(w, "name contains no displayable characters", http.StatusBadRequest)
+		return
+	}
+
+	// Duplicates are detected on the case-folded, normalised name, so
+	// "anna" is not added next to an existing "Anna".
+	if indexOf(s.data.Children, name) >= 0 {
+		s.replyNames(&reply, http.StatusOK)
+		return
 	}
 
-	s.names = append(s.names, name)
-	sort.Slice(s.names, func(i, j int) bool {
-		return s.names[i] < s.names[j]
-	})
+	before := s.data.clone()
+	s.data.Children = append(s.data.Children, Child{Name: name})
+	sortChildren(s.data.Children, s.locale)
 
 	if err := s.save(); err != nil {
 		// Roll back the append on save failure.
 		s.load()
-		http.Error(w, "failed to persist name", http.StatusInternalServerError)
+		saveFailed(w, err, "failed to persist name")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(s.names)
+	err := s.record(auth.RequestDevice(r), opAdd, name, before)
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(&reply, http.StatusCreated)
 }
 
 // deleteRequest is the expected JSON body for DELETE /children.
@@ -131,75 +220,135 @@ func (s *Store) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := strings.TrimSpace(req.Name)
+	name := normalizeName(req.Name)
 	if name == "" {
 		http.Error(w, "name must not be empty", http.StatusBadRequest)
 		return
 	}
 
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	idx := -1
-	for i, existing := range s.names {
-		if existing == name {
-			idx = i
-			break
-		}
+	if !s.checkIfMatch(&reply, r) {
+		return
 	}
 
+	idx := indexOf(s.data.Children, name)
 	if idx == -1 {
 		// Name not found — return current list.
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(s.names)
+		s.replyNames(&reply, http.StatusOK)
 		return
 	}
 
-	s.names = append(s.names[:idx], s.names[idx+1:]...)
+	before := s.data.clone()
+	removed := s.data.Children[idx].Name
+	s.data.Children = slices.Delete(s.data.Children, idx, idx+1)
+	s.data.dropEmptiedFamilies(before)
 
 	if err := s.save(); err != nil {
 		s.load()
-		http.Error(w, "failed to persist deletion", http.StatusInternalServerError)
+		saveFailed(w, err, "failed to persist deletion")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(s.names)
+	err := s.record(auth.RequestDevice(r), opRemove, removed, before)
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(&reply, http.StatusOK)
 }
 
+// save persists the list atomically through the backend. Children that need
+// a pickup code get one first, and new children are stamped for retention.
 func (s *Store) save() error {
-	data, err := json.MarshalIndent(s.names, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshalling children: %w", err)
+	if _, err := s.assignCodes(); err != nil {
+		return err
 	}
-	data = append(data, '\n')
-	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
-		return fmt.Errorf("writing children file %q: %w", s.filePath, err)
+	s.stampLastSeen(time.Now())
+	s.indexSearchKeys()
+	if err := s.backend.Save(s.data); err != nil {
+		return err
 	}
+	s.lastSeenDirty = false
 	return nil
 }
 
+// load reads the list from the backend. If the children file cannot be
+// parsed, the newest valid backup is used instead so a damaged file does not
+// keep the server from starting.
 func (s *Store) load() error {
-	data, err := os.ReadFile(s.filePath)
+	c, err := s.backend.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			s.names = []string{}
-			return nil
+		var parseErr *parseError
+		if !errors.As(err, &parseErr) || s.filePath == "" {
+			return err
 		}
-		return fmt.Errorf("reading children file %q: %w", s.filePath, err)
+		backup, path, ok := loadFromBackups(s.filePath)
+		if !ok {
+			return err
+		}
+		log.Printf("WARNING: %v", err)
+		log.Printf("WARNING: using %d children from backup %s — fix or replace %s", len(backup.Children), path, s.filePath)
+		c = backup
+		s.loadErr = err
+	} else {
+		s.loadErr = nil
 	}
 
-	var names []string
-	if err := json.Unmarshal(data, &names); err != nil {
-		return fmt.Errorf("parsing children file %q: %w", s.filePath, err)
+	s.setContents(c)
+	return nil
+}
+
+// setContents replaces the in-memory list with freshly read contents.
+// The caller must hold s.mu.
+func (s *Store) setContents(c Data) {
+	sortChildren(c.Children, s.locale)
+	s.data = c
+	s.loadedAt = time.Now()
+	s.indexSearchKeys()
+	s.setDuplicates(findDuplicates(c.names()))
+}
+
+// setDuplicates records near-duplicate names and logs them when they change.
+// The caller must hold s.mu.
+func (s *Store) setDuplicates(dups [][]string) {
+	if fmt.Sprint(dups) != fmt.Sprint(s.duplicates) {
+		for _, group := range dups {
+			log.Printf("WARNING: near-duplicate children in %s: %q", s.backend, group)
+		}
 	}
+	s.duplicates = dups
+}
 
-	sort.Slice(names, func(i, j int) bool {
-		return names[i] < names[j]
-	})
+// parseError marks a children file that exists but is not valid JSON.
+type parseError struct {
+	path string
+	err  error
+}
 
-	s.names = names
-	return nil
+func (e *parseError) Error() string {
+	return fmt.Sprintf("parsing children file %q: %v", e.path, e.err)
+}
+
+func (e *parseError) Unwrap() error { return e.err }
+
+// readContents reads and parses the children file. Entries keep their file
+// order. A missing file yields an empty list.
+func readContents(path string) (Data, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Data{Children: []Child{}}, nil
+		}
+		return Data{}, fmt.Errorf("reading children file %q: %w", path, err)
+	}
+
+	c, err := parseContents(data)
+	if err != nil {
+		return Data{}, &parseError{path: path, err: err}
+	}
+	return c, nil
 }
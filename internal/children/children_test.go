@@ -8,6 +8,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/sanitize"
 )
 
 func TestNewStoreLoadsFile(t *testing.T) {
@@ -386,20 +389,29 @@ func TestServeHTTPGetPicksUpExternalEdits(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewStore() error: %v", err)
 	}
+	if err := s.Watch(); err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+	defer s.Close()
 
 	// Externally modify the file (simulates manual edit).
 	os.WriteFile(path, []byte(`["Anna","Ben","Clara","David"]`), 0644)
 
-	req := httptest.NewRequest(http.MethodGet, "/children", nil)
-	rec := httptest.NewRecorder()
-	s.ServeHTTP(rec, req)
+	var names []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(names) != 4 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("expected status 200, got %d", rec.Code)
-	}
+		req := httptest.NewRequest(http.MethodGet, "/children", nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
 
-	var names []string
-	json.NewDecoder(rec.Body).Decode(&names)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+		names = nil
+		json.NewDecoder(rec.Body).Decode(&names)
+	}
 	if len(names) != 4 {
 		t.Fatalf("expected 4 names after external edit, got %d: %v", len(names), names)
 	}
@@ -410,3 +422,286 @@ func TestServeHTTPGetPicksUpExternalEdits(t *testing.T) {
 		}
 	}
 }
+
+func TestSaveRotatesBackupsAndLeavesNoTempFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	for _, name := range []string{"Ben", "Clara", "David", "Emma"} {
+		req := httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"`+name+`"}`))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s: expected 201, got %d", name, rec.Code)
+		}
+	}
+
+	// Newest backup holds the state before the last save.
+	data, err := os.ReadFile(backupPath(path, 1))
+	if err != nil {
+		t.Fatalf("reading newest backup: %v", err)
+	}
+	var backup []string
+	json.Unmarshal(data, &backup)
+	if len(backup) != 4 {
+		t.Errorf("expected 4 names in newest backup, got %v", backup)
+	}
+
+	if _, err := os.Stat(backupPath(path, backupGenerations+1)); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d backup generations", backupGenerations)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("leftover temp file: %s", e.Name())
+		}
+	}
+}
+
+func TestNewStoreFallsBackToBackup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Be`), 0644) // truncated write
+	os.WriteFile(backupPath(path, 1), []byte(`not json`), 0644)
+	os.WriteFile(backupPath(path, 2), []byte(`["Ben","Anna"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	names := s.Names()
+	if len(names) != 2 || names[0] != "Anna" || names[1] != "Ben" {
+		t.Errorf("expected names from backup, got %v", names)
+	}
+}
+
+func TestWatchKeepsLastGoodListOnSyntaxError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	if err := s.Watch(); err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+	defer s.Close()
+
+	os.WriteFile(path, []byte(`["Anna","Ben",`), 0644)
+
+	var status statusResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Error == "" && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		rec := httptest.NewRecorder()
+		s.HandleStatus(rec, httptest.NewRequest(http.MethodGet, "/children/status", nil))
+		json.NewDecoder(rec.Body).Decode(&status)
+	}
+
+	if status.Error == "" {
+		t.Fatal("expected status to report the syntax error")
+	}
+	if names := s.Names(); len(names) != 2 {
+		t.Errorf("expected last good list to be kept, got %v", names)
+	}
+}
+
+func TestSubscribeNotifiedOnPost(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	changes, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	req := httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"Anna"}`))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("expected a change notification after POST")
+	}
+}
+
+func TestServeHTTPGetNotModified(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/children", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header on GET")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/children", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected empty body for 304, got %q", rec.Body.String())
+	}
+}
+
+func TestServeHTTPIfMatchConflict(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/children", nil))
+	staleETag := rec.Header().Get("ETag")
+
+	// Another worker adds a name, so the first worker's version is stale.
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"Clara"}`)))
+
+	req := httptest.NewRequest(http.MethodDelete, "/children", strings.NewReader(`{"name":"Ben"}`))
+	req.Header.Set("If-Match", staleETag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+	var names []string
+	json.NewDecoder(rec.Body).Decode(&names)
+	if len(names) != 3 {
+		t.Errorf("expected current list of 3 names in conflict response, got %v", names)
+	}
+
+	// Retrying with the current version succeeds.
+	req = httptest.NewRequest(http.MethodDelete, "/children", strings.NewReader(`{"name":"Ben"}`))
+	req.Header.Set("If-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 with current ETag, got %d", rec.Code)
+	}
+	if names := s.Names(); len(names) != 2 {
+		t.Errorf("expected Ben to be removed, got %v", names)
+	}
+}
+
+func TestServeHTTPPostSanitizesName(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	san, err := sanitize.New(sanitize.Options{MaxLength: 20})
+	if err != nil {
+		t.Fatalf("sanitize.New() error: %v", err)
+	}
+	s.SetSanitizer(san)
+
+	req := httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"Ben\u200b 🦖"}`))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Values(sanitizedHeader); len(got) != 2 {
+		t.Errorf("expected invisible and symbols in %s, got %v", sanitizedHeader, got)
+	}
+	if got := s.Names(); len(got) != 2 || got[1] != "Ben" {
+		t.Errorf("expected [Anna Ben], got %v", got)
+	}
+
+	// A name made only of emoji is rejected.
+	req = httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"🦖"}`))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+// lockCheckingWriter records whether the store was locked when the response
+// was written.
+type lockCheckingWriter struct {
+	*httptest.ResponseRecorder
+	s      *Store
+	locked bool
+}
+
+func (w *lockCheckingWriter) WriteHeader(code int) {
+	if !w.s.mu.TryLock() {
+		w.locked = true
+	} else {
+		w.s.mu.Unlock()
+	}
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func TestServeHTTPWritesAfterUnlock(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	// A slow client must not hold up other requests to the store.
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/children", nil),
+		httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"Clara"}`)),
+		httptest.NewRequest(http.MethodDelete, "/children", strings.NewReader(`{"name":"Ben"}`)),
+	} {
+		w := &lockCheckingWriter{ResponseRecorder: httptest.NewRecorder(), s: s}
+		s.ServeHTTP(w, req)
+		if w.locked || w.Body.Len() == 0 {
+			t.Errorf("%s: response written while locked (%v) or empty", req.Method, w.locked)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package children
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeName trims the name, collapses runs of whitespace to a single
+// space and converts it to Unicode NFC, so "Jürgen" typed on a keyboard and
+// "Jürgen" pasted from a spreadsheet become the same string.
+func normalizeName(name string) string {
+	return norm.NFC.String(strings.Join(strings.Fields(name), " "))
+}
+
+// foldKey returns the key used to detect duplicates: the normalised name
+// with case folding applied, so "anna", "Anna " and "ANNA" share one key.
+func foldKey(name string) string {
+	return cases.Fold().String(normalizeName(name))
+}
+
+// sortChildren sorts children by name using the collation rules of the
+// given locale, so umlauts sort next to their base letter ("Ökan" before
+// "Zoe") instead of after "z" as with byte order. language.Und uses the root
+// collation.
+func sortChildren(children []Child, locale language.Tag) {
+	// A Collator is not safe for concurrent use; create one per sort.
+	c := collate.New(locale, collate.Numeric)
+	sort.SliceStable(children, func(i, j int) bool {
+		return c.CompareString(children[i].Name, children[j].Name) < 0
+	})
+}
+
+// indexOf returns the index of the child called name. An exact match wins;
+// otherwise a name with the same fold key matches. It returns -1 if there is
+// none.
+func indexOf(children []Child, name string) int {
+	for i, existing := range children {
+		if existing.Name == name {
+			return i
+		}
+	}
+	key := foldKey(name)
+	for i, existing := range children {
+		if foldKey(existing.Name) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// findDuplicates returns groups of names that only differ in case, spacing
+// or Unicode normalisation form.
+func findDuplicates(names []string) [][]string {
+	groups := make(map[string][]string)
+	var order []string
+	for _, name := range names {
+		key := foldKey(name)
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], name)
+	}
+
+	var dups [][]string
+	for _, key := range order {
+		if len(groups[key]) > 1 {
+			dups = append(dups, groups[key])
+		}
+	}
+	return dups
+}
@@ -0,0 +1,108 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestSortChildrenLocaleAware(t *testing.T) {
+	t.Parallel()
+
+	var children []Child
+	for _, name := range []string{"Zoe", "Ökan", "Anna", "Oskar", "Émile", "Ben", "Kind 10", "Kind 2"} {
+		children = append(children, Child{Name: name})
+	}
+	sortChildren(children, language.German)
+
+	expected := []string{"Anna", "Ben", "Émile", "Kind 2", "Kind 10", "Ökan", "Oskar", "Zoe"}
+	for i, want := range expected {
+		if children[i].Name != want {
+			t.Errorf("children[%d] = %q, want %q (got %v)", i, children[i].Name, want, children)
+		}
+	}
+}
+
+func TestFoldKeyMatchesCaseSpacingAndNFD(t *testing.T) {
+	t.Parallel()
+
+	nfd := "Ju\u0308rgen" // "Jürgen" with a combining diaeresis
+	tests := []struct{ a, b string }{
+		{"anna", "Anna "},
+		{"ANNA", "Anna"},
+		{"Anna  Lena", "Anna Lena"},
+		{nfd, "Jürgen"},
+	}
+	for _, tc := range tests {
+		if foldKey(tc.a) != foldKey(tc.b) {
+			t.Errorf("foldKey(%q) != foldKey(%q)", tc.a, tc.b)
+		}
+	}
+	if foldKey("Anna") == foldKey("Anne") {
+		t.Error("different names must not share a fold key")
+	}
+}
+
+func TestServeHTTPPostRejectsNearDuplicates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Jürgen"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	for _, name := range []string{"anna", "Anna ", "Ju\u0308rgen"} {
+		body, _ := json.Marshal(addRequest{Name: name})
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(string(body))))
+		if rec.Code != http.StatusOK {
+			t.Errorf("POST %q: expected 200 for duplicate, got %d", name, rec.Code)
+		}
+	}
+	if names := s.Names(); len(names) != 2 {
+		t.Errorf("expected no new names, got %v", names)
+	}
+}
+
+func TestNewStoreReportsNearDuplicates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","anna","Ben","Ju\u0308rgen"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleStatus(rec, httptest.NewRequest(http.MethodGet, "/children/status", nil))
+	var status statusResponse
+	json.NewDecoder(rec.Body).Decode(&status)
+
+	if len(status.Duplicates) != 1 || len(status.Duplicates[0]) != 2 {
+		t.Fatalf("expected one duplicate group of 2, got %v", status.Duplicates)
+	}
+
+	// The NFD name is stored in NFC form.
+	found := false
+	for _, name := range s.Names() {
+		if name == "Jürgen" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected NFC-normalised Jürgen, got %q", s.Names())
+	}
+}
@@ -0,0 +1,33 @@
+package children
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/tafli/CallingParents/internal/sanitize"
+)
+
+// sanitizedHeader lists the sanitize change codes applied to the names of a
+// write request, e.g. "symbols", so the PWA can tell the worker the name was
+// stored differently than typed.
+const sanitizedHeader = "X-Sanitized"
+
+// SetSanitizer sets how new and renamed names are cleaned before they are
+// stored. Nil stores names as typed apart from whitespace normalisation.
+func (s *Store) SetSanitizer(san *sanitize.Sanitizer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sanitizer = san
+}
+
+// cleanName returns the sanitised, normalised form of name and reports the
+// changes in the sanitizedHeader of w. The caller must hold s.mu.
+func (s *Store) cleanName(w http.ResponseWriter, name string) string {
+	res := s.sanitizer.Clean(name)
+	for _, change := range res.Changes {
+		if !slices.Contains(w.Header().Values(sanitizedHeader), change) {
+			w.Header().Add(sanitizedHeader, change)
+		}
+	}
+	return normalizeName(res.Text)
+}
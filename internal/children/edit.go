@@ -0,0 +1,268 @@
+package children
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+// Batch operation kinds accepted by POST /children/batch. They are also the
+// op names recorded in the history.
+const (
+	opAdd    = "add"
+	opRemove = "remove"
+	opRename = "rename"
+	// opSetFamily links the child to a family, or unlinks it when Family
+	// is empty.
+	opSetFamily = "setFamily"
+	// opSetPrivate shows the child's pickup code instead of its name, or
+	// the name again when Private is false.
+	opSetPrivate = "setPrivate"
+	// opBatch is recorded in the history for a batch of several operations.
+	opBatch = "batch"
+)
+
+// errNotFound and errExists classify operation failures so handlers can map
+// them to 404 and 409 responses.
+var (
+	errNotFound = errors.New("not found")
+	errExists   = errors.New("already exists")
+)
+
+// operation is a single change to the children list. Children are identified
+// by their name; a rename keeps the child's place in the activity log by
+// recording the old and new name.
+type operation struct {
+	Op      string `json:"op"`
+	Name    string `json:"name"`
+	NewName string `json:"newName,omitempty"`
+	Family  string `json:"family,omitempty"`
+	Private bool   `json:"private,omitempty"`
+}
+
+// rename records a completed rename for the activity log.
+type rename struct {
+	from, to string
+}
+
+// SetLogger sets the activity logger used to record renames. A nil logger
+// disables logging.
+func (s *Store) SetLogger(logger *activitylog.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+}
+
+// applyOperations applies ops to a copy of children and returns the result. It
+// either applies every operation or none: the first invalid operation aborts
+// with an error that names its index. Names are matched like in POST and
+// DELETE /children: adding an existing name (ignoring case and Unicode form)
+// and removing a missing one are no-ops.
+func applyOperations(children []Child, ops []operation, locale language.Tag) ([]Child, []rename, error) {
+	out := slices.Clone(children)
+	var renames []rename
+
+	for i, op := range ops {
+		name := normalizeName(op.Name)
+		if name == "" {
+			return nil, nil, fmt.Errorf("operation %d: name must not be empty", i)
+		}
+
+		switch op.Op {
+		case opAdd:
+			if indexOf(out, name) < 0 {
+				out = append(out, Child{Name: name, Family: strings.TrimSpace(op.Family)})
+			}
+		case opRemove:
+			if idx := indexOf(out, name); idx >= 0 {
+				out = slices.Delete(out, idx, idx+1)
+			}
+		case opRename:
+			newName := normalizeName(op.NewName)
+			if newName == "" {
+				return nil, nil, fmt.Errorf("operation %d: newName must not be empty", i)
+			}
+			idx := indexOf(out, name)
+			if idx < 0 {
+				return nil, nil, fmt.Errorf("operation %d: child %q %w", i, name, errNotFound)
+			}
+			current := out[idx].Name
+			if newName == current {
+				continue
+			}
+			// Changing only the case of a name ("anna" → "Anna") is allowed;
+			// taking another child's name is not.
+			if other := indexOf(out, newName); other >= 0 && other != idx {
+				return nil, nil, fmt.Errorf("operation %d: child %q %w", i, out[other].Name, errExists)
+			}
+			out[idx].Name = newName
+			renames = append(renames, rename{from: current, to: newName})
+		case opSetFamily:
+			idx := indexOf(out, name)
+			if idx < 0 {
+				return nil, nil, fmt.Errorf("operation %d: child %q %w", i, name, errNotFound)
+			}
+			out[idx].Family = strings.TrimSpace(op.Family)
+		case opSetPrivate:
+			idx := indexOf(out, name)
+			if idx < 0 {
+				return nil, nil, fmt.Errorf("operation %d: child %q %w", i, name, errNotFound)
+			}
+			out[idx].Private = op.Private
+		default:
+			return nil, nil, fmt.Errorf("operation %d: unknown op %q (want add, remove, rename, setFamily or setPrivate)", i, op.Op)
+		}
+	}
+
+	sortChildren(out, locale)
+	return out, renames, nil
+}
+
+// commit applies ops under the write lock, persists the result with a single
+// save, records it in the history and sets reply to the updated list. The
+// caller must hold s.mu.
+func (s *Store) commit(w http.ResponseWriter, reply *listReply, r *http.Request, ops []operation) {
+	// New names are cleaned like in POST /children. An operation whose
+	// name is emptied by cleaning fails in applyOperations.
+	ops = slices.Clone(ops)
+	for i, op := range ops {
+		switch op.Op {
+		case opAdd:
+			ops[i].Name = s.cleanName(w, op.Name)
+		case opRename:
+			ops[i].NewName = s.cleanName(w, op.NewName)
+		}
+	}
+
+	children, renames, err := applyOperations(s.data.Children, ops, s.locale)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, errNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errExists):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if slices.Equal(children, s.data.Children) {
+		s.replyNames(reply, http.StatusOK)
+		return
+	}
+
+	before := s.data.clone()
+	s.data.Children = children
+	s.data.dropEmptiedFamilies(before)
+	if err := s.save(); err != nil {
+		s.data = before
+		saveFailed(w, err, "failed to persist changes")
+		return
+	}
+
+	op, name := opBatch, ""
+	if len(ops) == 1 {
+		op, name = ops[0].Op, normalizeName(ops[0].Name)
+	}
+	err = s.record(auth.RequestDevice(r), op, name, before)
+	for _, rn := range renames {
+		s.logger.LogRename(rn.from, rn.to, auth.RequestDevice(r))
+	}
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(reply, http.StatusOK)
+}
+
+// editRequest is the expected JSON body for PATCH /children/{id}. Fields
+// that are left out are not changed.
+type editRequest struct {
+	Name    string  `json:"name"`
+	Family  *string `json:"family"`
+	Private *bool   `json:"private"`
+}
+
+// HandleEdit handles PATCH /children/{id}, where id is the child's current
+// name. The body {"name":"..."} renames the child, {"family":"..."} links
+// it to a family ("" unlinks it) and {"private":true} shows its pickup code
+// instead of the name. It returns 404 if the child does not exist
+// and 409 if the new name is already taken.
+func (s *Store) HandleEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req editRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+	id := r.PathValue("id")
+	var ops []operation
+	if req.Family != nil {
+		ops = append(ops, operation{Op: opSetFamily, Name: id, Family: *req.Family})
+	}
+	if req.Private != nil {
+		ops = append(ops, operation{Op: opSetPrivate, Name: id, Private: *req.Private})
+	}
+	if req.Name != "" || (req.Family == nil && req.Private == nil) {
+		ops = append(ops, operation{Op: opRename, Name: id, NewName: req.Name})
+	}
+	s.commit(w, &reply, r, ops)
+}
+
+// batchRequest is the expected JSON body for POST /children/batch.
+type batchRequest struct {
+	Operations []operation `json:"operations"`
+}
+
+// HandleBatch handles POST /children/batch. The body lists add, remove and
+// rename operations that are applied atomically under one lock and persisted
+// with one save:
+//
+//	{"operations":[{"op":"add","name":"Anna"},{"op":"rename","name":"Jurgen","newName":"Jürgen"}]}
+//
+// If any operation is invalid, nothing is changed.
+func (s *Store) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+	s.commit(w, &reply, r, req.Operations)
+}
@@ -0,0 +1,138 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+)
+
+func TestHandleEditRenamesAndLogs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Jurgen"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	logPath := filepath.Join(dir, "activity.jsonl")
+	logger, err := activitylog.New(logPath)
+	if err != nil {
+		t.Fatalf("activitylog.New() error: %v", err)
+	}
+	defer logger.Close()
+	s.SetLogger(logger)
+
+	req := httptest.NewRequest(http.MethodPatch, "/children/Jurgen", strings.NewReader(`{"name":"Jürgen"}`))
+	req.SetPathValue("id", "Jurgen")
+	rec := httptest.NewRecorder()
+	s.HandleEdit(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	names := s.Names()
+	if len(names) != 2 || names[1] != "Jürgen" {
+		t.Errorf("expected Jurgen to be renamed, got %v", names)
+	}
+
+	data, _ := os.ReadFile(logPath)
+	var entry activitylog.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("parsing log entry: %v", err)
+	}
+	if entry.Action != "rename" || entry.Name != "Jurgen" || entry.NewName != "Jürgen" {
+		t.Errorf("unexpected log entry: %+v", entry)
+	}
+}
+
+func TestHandleEditErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		body string
+		want int
+	}{
+		{"unknown child", "Clara", `{"name":"Claire"}`, http.StatusNotFound},
+		{"name taken", "Anna", `{"name":"Ben"}`, http.StatusConflict},
+		{"empty name", "Anna", `{"name":" "}`, http.StatusBadRequest},
+		{"invalid JSON", "Anna", `nope`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/children/"+tc.id, strings.NewReader(tc.body))
+			req.SetPathValue("id", tc.id)
+			rec := httptest.NewRecorder()
+			s.HandleEdit(rec, req)
+
+			if rec.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandleBatchIsAtomic(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	// The rename of an unknown child must abort the whole batch.
+	body := `{"operations":[{"op":"add","name":"Clara"},{"op":"rename","name":"Zoe","newName":"Zoë"}]}`
+	rec := httptest.NewRecorder()
+	s.HandleBatch(rec, httptest.NewRequest(http.MethodPost, "/children/batch", strings.NewReader(body)))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+	if names := s.Names(); len(names) != 2 {
+		t.Fatalf("expected no changes after failed batch, got %v", names)
+	}
+
+	body = `{"operations":[{"op":"add","name":"Clara"},{"op":"remove","name":"Anna"},{"op":"rename","name":"Ben","newName":"Benjamin"}]}`
+	rec = httptest.NewRecorder()
+	s.HandleBatch(rec, httptest.NewRequest(http.MethodPost, "/children/batch", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var names []string
+	json.NewDecoder(rec.Body).Decode(&names)
+	if len(names) != 2 || names[0] != "Benjamin" || names[1] != "Clara" {
+		t.Errorf("unexpected names after batch: %v", names)
+	}
+
+	data, _ := os.ReadFile(path)
+	var persisted []string
+	json.Unmarshal(data, &persisted)
+	if len(persisted) != 2 {
+		t.Errorf("expected batch to be persisted, got %v", persisted)
+	}
+}
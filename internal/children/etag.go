@@ -0,0 +1,84 @@
+package children
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// computeETag returns a strong entity tag for the list. It is a hash of the
+// content, so it stays the same across restarts and changes whenever any
+// child is added, removed or edited — including manual file edits. LastSeen
+// is left out: it changes on every call and is not shown to clients.
+func computeETag(c Data) string {
+	c.Children = slices.Clone(c.Children)
+	for i := range c.Children {
+		c.Children[i].LastSeen = ""
+	}
+	data, _ := c.encode()
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etag returns the entity tag of the current list. The caller must hold s.mu.
+func (s *Store) etag() string {
+	return computeETag(s.data)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// matches the given entity tag. The header may list several tags or be "*".
+// Weak tags (W/"...") are compared by their opaque part.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// listReply is the list as a JSON response. It is copied while s.mu is held
+// and written after s.mu is released, so a slow client cannot block other
+// requests to the store.
+type listReply struct {
+	status int
+	etag   string
+	names  []string
+}
+
+// replyNames sets reply to the current list and its ETag with the given
+// status. The caller must hold s.mu.
+func (s *Store) replyNames(reply *listReply, status int) {
+	*reply = listReply{status: status, etag: s.etag(), names: s.data.names()}
+}
+
+// write sends the reply, if one was set.
+func (l *listReply) write(w http.ResponseWriter) {
+	if l.status == 0 {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", l.etag)
+	w.WriteHeader(l.status)
+	json.NewEncoder(w).Encode(l.names)
+}
+
+// checkIfMatch enforces optimistic concurrency for write requests. If the
+// request carries an If-Match header that does not match the current list,
+// it sets reply to 409 Conflict with the current list and returns false.
+// The caller must hold s.mu.
+func (s *Store) checkIfMatch(reply *listReply, r *http.Request) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, s.etag()) {
+		return true
+	}
+	s.replyNames(reply, http.StatusConflict)
+	return false
+}
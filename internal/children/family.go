@@ -0,0 +1,135 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/tafli/CallingParents/internal/auth"
+	"github.com/tafli/CallingParents/internal/display"
+)
+
+// familyResponse is one entry returned by GET /children/families.
+type familyResponse struct {
+	ID       string   `json:"id"`
+	Surname  string   `json:"surname,omitempty"`
+	Children []string `json:"children"`
+	// Display is the combined text shown on screen, e.g. "Anna & Ben Müller".
+	Display string `json:"display"`
+}
+
+// Family returns the names of the children linked to the family id, in list
+// order, together with the family's surname. ok is false if no child belongs
+// to the family.
+func (s *Store) Family(id string) (names []string, surname string, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names = s.data.familyMembers(id)
+	if len(names) == 0 {
+		return nil, "", false
+	}
+	return names, s.data.Families[id].Surname, true
+}
+
+// HandleFamilies handles GET /children/families and lists every family that
+// has at least one child, sorted by ID.
+func (s *Store) HandleFamilies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	var ids []string
+	seen := make(map[string]bool)
+	for _, child := range s.data.Children {
+		if child.Family != "" && !seen[child.Family] {
+			seen[child.Family] = true
+			ids = append(ids, child.Family)
+		}
+	}
+	sort.Strings(ids)
+
+	out := make([]familyResponse, 0, len(ids))
+	for _, id := range ids {
+		members := s.data.familyMembers(id)
+		surname := s.data.Families[id].Surname
+		out = append(out, familyResponse{
+			ID:       id,
+			Surname:  surname,
+			Children: members,
+			Display:  display.JoinNames(members, surname),
+		})
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// familyRequest is the expected JSON body for PUT /children/families/{id}.
+type familyRequest struct {
+	Surname string `json:"surname"`
+}
+
+// HandleSetFamily handles PUT /children/families/{id} and sets the family's
+// surname. An empty surname removes it. Children are linked to a family with
+// PATCH /children/{id}.
+func (s *Store) HandleSetFamily(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSpace(r.PathValue("id"))
+	if id == "" {
+		http.Error(w, "family id must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	var req familyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	surname := normalizeName(req.Surname)
+
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+
+	// save also changes the children (pickup codes, retention stamps), so
+	// a failed save restores the whole snapshot.
+	before := s.data.clone()
+	families := make(map[string]Family, len(before.Families)+1)
+	for k, v := range before.Families {
+		families[k] = v
+	}
+	if surname == "" {
+		delete(families, id)
+	} else {
+		families[id] = Family{Surname: surname}
+	}
+
+	s.data = before.clone()
+	s.data.Families = families
+	if err := s.save(); err != nil {
+		s.data = before
+		saveFailed(w, err, "failed to persist changes")
+		return
+	}
+	err := s.record(auth.RequestDevice(r), changeFamily, "", before)
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(&reply, http.StatusOK)
+}
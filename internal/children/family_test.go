@@ -0,0 +1,186 @@
+package children
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseContentsFormats(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  []Child
+	}{
+		{"plain array", `["Anna","Ben"]`, []Child{{Name: "Anna"}, {Name: "Ben"}}},
+		{"mixed array", `["Anna",{"name":"Ben","family":"mueller"}]`, []Child{{Name: "Anna"}, {Name: "Ben", Family: "mueller"}}},
+		{"object", `{"children":[{"name":" Ben ","family":"mueller"}],"families":{"mueller":{"surname":"Müller"}}}`, []Child{{Name: "Ben", Family: "mueller"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := parseContents([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("parseContents() error: %v", err)
+			}
+			if len(c.Children) != len(tc.want) {
+				t.Fatalf("got %v, want %v", c.Children, tc.want)
+			}
+			for i := range tc.want {
+				if c.Children[i] != tc.want[i] {
+					t.Errorf("children[%d] = %v, want %v", i, c.Children[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeKeepsPlainArrayWithoutFamilies(t *testing.T) {
+	t.Parallel()
+
+	data, err := Data{Children: []Child{{Name: "Anna"}, {Name: "Ben"}}}.encode()
+	if err != nil {
+		t.Fatalf("encode() error: %v", err)
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		t.Errorf("expected a plain array, got %s", data)
+	}
+}
+
+func TestFamiliesEndToEnd(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben","Clara"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	for _, name := range []string{"Anna", "Ben"} {
+		req := httptest.NewRequest(http.MethodPatch, "/children/"+name, strings.NewReader(`{"family":"mueller"}`))
+		req.SetPathValue("id", name)
+		rec := httptest.NewRecorder()
+		s.HandleEdit(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PATCH %s: expected 200, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/children/families/mueller", strings.NewReader(`{"surname":"Müller"}`))
+	req.SetPathValue("id", "mueller")
+	rec := httptest.NewRecorder()
+	s.HandleSetFamily(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT family: expected 200, got %d", rec.Code)
+	}
+
+	names, surname, ok := s.Family("mueller")
+	if !ok || len(names) != 2 || surname != "Müller" {
+		t.Fatalf("Family() = %v, %q, %v", names, surname, ok)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleFamilies(rec, httptest.NewRequest(http.MethodGet, "/children/families", nil))
+	var families []familyResponse
+	json.NewDecoder(rec.Body).Decode(&families)
+	if len(families) != 1 || families[0].Display != "Anna & Ben Müller" {
+		t.Errorf("unexpected families %+v", families)
+	}
+
+	// The family survives a reload from disk.
+	s2, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error: %v", err)
+	}
+	if _, surname, ok := s2.Family("mueller"); !ok || surname != "Müller" {
+		t.Errorf("family not persisted")
+	}
+	if got := s2.Names(); len(got) != 3 {
+		t.Errorf("expected 3 names after reload, got %v", got)
+	}
+}
+
+func TestRemovingLastChildDeletesFamily(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(`{"children":[{"name":"Anna","family":"mueller"},{"name":"Ben","family":"mueller"},"Clara"],
+		"families":{"mueller":{"surname":"Müller"},"weber":{"surname":"Weber"}}}`), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	remove := func(name string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/children", strings.NewReader(`{"name":"`+name+`"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("DELETE %s: expected 200, got %d", name, rec.Code)
+		}
+	}
+
+	remove("Anna")
+	if _, surname, ok := s.Family("mueller"); !ok || surname != "Müller" {
+		t.Fatalf("family removed with a child left")
+	}
+	remove("Ben")
+
+	// The emptied family is gone; one that never had children is kept.
+	s2, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error: %v", err)
+	}
+	if _, ok := s2.data.Families["mueller"]; ok {
+		t.Errorf("orphaned family left behind: %v", s2.data.Families)
+	}
+	if _, ok := s2.data.Families["weber"]; !ok {
+		t.Errorf("family without children was deleted: %v", s2.data.Families)
+	}
+}
+
+// failingBackend is a Backend whose saves fail.
+type failingBackend struct{ Backend }
+
+func (failingBackend) Save(Data) error { return errors.New("disk full") }
+
+func TestSetFamilyRollsBackOnSaveError(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(`{"children":[{"name":"Anna","family":"mueller"}],"families":{"mueller":{"surname":"Müller"}}}`), 0644)
+	s, err := NewStoreWithBackend(failingBackend{NewFileBackend(path)})
+	if err != nil {
+		t.Fatalf("NewStoreWithBackend() error: %v", err)
+	}
+	// Privacy mode makes save assign Anna a pickup code before it fails.
+	s.privacyMode = true
+	before := s.etag()
+
+	req := httptest.NewRequest(http.MethodPut, "/children/families/mueller", strings.NewReader(`{"surname":"Meier"}`))
+	req.SetPathValue("id", "mueller")
+	rec := httptest.NewRecorder()
+	s.HandleSetFamily(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+	if _, surname, _ := s.Family("mueller"); surname != "Müller" {
+		t.Errorf("surname %q kept after a failed save", surname)
+	}
+	if code, _ := s.LookupCode("Anna"); code != "" {
+		t.Errorf("pickup code %q kept after a failed save", code)
+	}
+	if s.etag() != before {
+		t.Error("list differs from the saved one after a failed save")
+	}
+}
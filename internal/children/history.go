@@ -0,0 +1,477 @@
+package children
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+// Change operations recorded in the history besides the batch operation kinds.
+const (
+	changeEdit    = "edit"    // manual edit of children.json, picked up by the watcher
+	changeFamily  = "family"  // family surname changed
+	changeUndo    = "undo"    // POST /children/undo
+	changeRestore = "restore" // POST /children/restore
+	changePrune   = "prune"   // retention removed children
+	changeErase   = "erase"   // a child was erased
+	changeCodes   = "codes"   // pickup codes were assigned
+)
+
+// Change is one entry in the append-only history of the children list.
+// Before and After are complete snapshots, so any version can be restored.
+type Change struct {
+	Version int    `json:"version"`
+	Time    string `json:"time"`
+	// Who made the change: the client address, "file" for manual edits or
+	// "server" for automatic changes.
+	Who string `json:"who,omitempty"`
+	Op  string `json:"op"`
+	// Name is the child the change was about, if it was about one.
+	Name string `json:"name,omitempty"`
+	// Undoes is the version reverted by an undo.
+	Undoes int  `json:"undoes,omitempty"`
+	Before Data `json:"before"`
+	After  Data `json:"after"`
+}
+
+// clone returns a deep copy of d, so snapshots do not share memory with the
+// live list.
+func (d Data) clone() Data {
+	return Data{Children: slices.Clone(d.Children), Families: maps.Clone(d.Families)}
+}
+
+// maxHistory is the number of changes kept in the history. Older changes are
+// dropped once historyTrimSlack more have been recorded, so the history is
+// rewritten only every few changes.
+const (
+	maxHistory       = 500
+	historyTrimSlack = 50
+)
+
+// record appends a change from before to the current list to the history.
+// An error does not undo the change, which is already saved; the caller
+// reports it. The caller must hold s.mu.
+func (s *Store) record(who, op, name string, before Data) error {
+	c := Change{
+		Version: s.version + 1,
+		Time:    time.Now().Format(time.RFC3339),
+		Who:     who,
+		Op:      op,
+		Name:    name,
+		Before:  before,
+		After:   s.data.clone(),
+	}
+	return s.appendChange(c)
+}
+
+// appendChange stores c and advances the version. When the history has grown
+// past maxHistory, the oldest changes are dropped. The caller must hold s.mu.
+func (s *Store) appendChange(c Change) error {
+	if err := s.backend.AppendChange(c); err != nil {
+		return fmt.Errorf("recording children history: %w", err)
+	}
+	s.version = c.Version
+	s.historyLen++
+	if s.historyLen <= maxHistory+historyTrimSlack {
+		return nil
+	}
+	changes, err := s.backend.Changes()
+	if err != nil {
+		return fmt.Errorf("trimming children history: %w", err)
+	}
+	if len(changes) > maxHistory {
+		changes = changes[len(changes)-maxHistory:]
+	}
+	if err := s.backend.ReplaceChanges(changes); err != nil {
+		return fmt.Errorf("trimming children history: %w", err)
+	}
+	s.historyLen = len(changes)
+	return nil
+}
+
+// historyFailed reports a change that was saved but could not be recorded in
+// the history. The client must not retry it: the list already changed.
+func historyFailed(w http.ResponseWriter, err error) {
+	log.Printf("WARNING: %v", err)
+	http.Error(w, "changes saved, but the history could not be recorded", http.StatusInternalServerError)
+}
+
+// loadVersion reads the latest history version. The caller must hold s.mu.
+func (s *Store) loadVersion() error {
+	changes, err := s.backend.Changes()
+	if err != nil {
+		return err
+	}
+	s.version = 0
+	s.historyLen = len(changes)
+	if len(changes) > 0 {
+		s.version = changes[len(changes)-1].Version
+	}
+	return nil
+}
+
+// scrubHistory removes the named children from every snapshot in the history
+// and returns the number of changes that mentioned them. Changes that were
+// only about those children are dropped. The caller must hold s.mu.
+func (s *Store) scrubHistory(names ...string) (int, error) {
+	changes, err := s.backend.Changes()
+	if err != nil {
+		return 0, err
+	}
+
+	without := func(d Data) (Data, bool) {
+		found := false
+		for _, name := range names {
+			if idx := indexOf(d.Children, name); idx >= 0 {
+				d.Children = slices.Delete(slices.Clone(d.Children), idx, idx+1)
+				found = true
+			}
+		}
+		return d, found
+	}
+
+	scrubbed := 0
+	kept := changes[:0]
+	for _, c := range changes {
+		var inBefore, inAfter bool
+		c.Before, inBefore = without(c.Before)
+		c.After, inAfter = without(c.After)
+		named := c.Name != "" && slices.ContainsFunc(names, func(n string) bool { return foldKey(n) == foldKey(c.Name) })
+		if named {
+			c.Name = ""
+		}
+		if inBefore || inAfter || named {
+			scrubbed++
+			if computeETag(c.Before) == computeETag(c.After) {
+				// Nothing else happened in this change.
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if scrubbed == 0 {
+		return 0, nil
+	}
+	if err := s.backend.ReplaceChanges(kept); err != nil {
+		return 0, err
+	}
+	s.historyLen = len(kept)
+	return scrubbed, nil
+}
+
+// HandleHistory handles GET /children/history?limit=N and returns the most
+// recent changes, newest first (default 50).
+func (s *Store) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	s.mu.RLock()
+	changes, err := s.backend.Changes()
+	s.mu.RUnlock()
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+
+	slices.Reverse(changes)
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// HandleUndo handles POST /children/undo. It reverts the most recent change
+// that has not been undone yet; repeated calls step further back. It returns
+// 404 if there is nothing to undo and 409 if the list was changed outside
+// the history (e.g. a change was not recorded) since that change.
+func (s *Store) HandleUndo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+
+	changes, err := s.backend.Changes()
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+
+	// An undo reverts its target, and undoing an undo is not supported, so
+	// walk back past undos and the changes they reverted.
+	undone := make(map[int]bool)
+	var target *Change
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		if c.Op == changeUndo {
+			undone[c.Undoes] = true
+			continue
+		}
+		if !undone[c.Version] {
+			target = &changes[i]
+			break
+		}
+	}
+	if target == nil {
+		http.Error(w, "nothing to undo", http.StatusNotFound)
+		return
+	}
+	if computeETag(target.After) != s.etag() {
+		http.Error(w, fmt.Sprintf("the list changed since version %d; restore a version instead", target.Version), http.StatusConflict)
+		return
+	}
+
+	before := s.data.clone()
+	if !s.apply(w, target.Before.clone()) {
+		return
+	}
+	c := Change{
+		Version: s.version + 1,
+		Time:    time.Now().Format(time.RFC3339),
+		Who:     auth.RequestDevice(r),
+		Op:      changeUndo,
+		Name:    target.Name,
+		Undoes:  target.Version,
+		Before:  before,
+		After:   s.data.clone(),
+	}
+	err = s.appendChange(c)
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(&reply, http.StatusOK)
+}
+
+// restoreRequest is the expected JSON body for POST /children/restore.
+type restoreRequest struct {
+	Version int `json:"version"`
+}
+
+// HandleRestore handles POST /children/restore with {"version":N} and sets
+// the list to its state right after version N. The restore is itself
+// recorded, so it can be undone. It returns 404 for an unknown version.
+func (s *Store) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req restoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var reply listReply
+	defer reply.write(w) // after s.mu is released
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkIfMatch(&reply, r) {
+		return
+	}
+
+	changes, err := s.backend.Changes()
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+	idx := slices.IndexFunc(changes, func(c Change) bool { return c.Version == req.Version })
+	if idx < 0 {
+		http.Error(w, fmt.Sprintf("version %d not found", req.Version), http.StatusNotFound)
+		return
+	}
+
+	before := s.data.clone()
+	if !s.apply(w, changes[idx].After.clone()) {
+		return
+	}
+	err = s.record(auth.RequestDevice(r), changeRestore, "", before)
+	s.notify()
+	if err != nil {
+		historyFailed(w, err)
+		return
+	}
+	s.replyNames(&reply, http.StatusOK)
+}
+
+// apply replaces the list with d and saves it; the caller records the change
+// and notifies listeners. On failure it restores the
+// previous list, writes a 500 response and returns false. The caller must
+// hold s.mu.
+func (s *Store) apply(w http.ResponseWriter, d Data) bool {
+	previous := s.data
+	sortChildren(d.Children, s.locale)
+	s.data = d
+	if err := s.save(); err != nil {
+		s.data = previous
+		saveFailed(w, err, "failed to persist changes")
+		return false
+	}
+	s.setDuplicates(findDuplicates(s.data.names()))
+	return true
+}
+
+// historyPath returns the path of the history file kept next to the
+// children file.
+func historyPath(filePath string) string {
+	return filePath + ".history.jsonl"
+}
+
+// AppendChange appends c as one JSON line to the history file.
+func (b *FileBackend) AppendChange(c Change) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(b.path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Changes reads the history file. A missing file yields no changes; lines
+// that cannot be parsed (e.g. a write cut short by a crash) are logged and
+// skipped, and dropped when the history is next rewritten.
+func (b *FileBackend) Changes() ([]Change, error) {
+	data, err := os.ReadFile(historyPath(b.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var changes []Change
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var c Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			log.Printf("WARNING: skipping line %d of %s: %v", line, historyPath(b.path), err)
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, scanner.Err()
+}
+
+// ReplaceChanges atomically replaces the history file.
+func (b *FileBackend) ReplaceChanges(changes []Change) error {
+	var out bytes.Buffer
+	for _, c := range changes {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		out.Write(append(data, '\n'))
+	}
+	return atomicfile.WriteFile(historyPath(b.path), out.Bytes(), 0644)
+}
+
+// boltHistory is the bucket BoltBackend keeps the history in, keyed by
+// big-endian version.
+var boltHistory = []byte("history")
+
+// AppendChange stores c under its version.
+func (b *BoltBackend) AppendChange(c Change) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(boltHistory)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(binary.BigEndian.AppendUint64(nil, uint64(c.Version)), v)
+	})
+}
+
+// Changes returns all changes in version order.
+func (b *BoltBackend) Changes() ([]Change, error) {
+	var changes []Change
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHistory)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var c Change
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			changes = append(changes, c)
+			return nil
+		})
+	})
+	return changes, err
+}
+
+// ReplaceChanges replaces the history in one transaction.
+func (b *BoltBackend) ReplaceChanges(changes []Change) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltHistory); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := tx.CreateBucket(boltHistory)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			v, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(binary.BigEndian.AppendUint64(nil, uint64(c.Version)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
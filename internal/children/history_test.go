@@ -0,0 +1,230 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newHistoryStore creates a store backed by a temporary file holding names.
+func newHistoryStore(t *testing.T, names string) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(names), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	return s, path
+}
+
+func deleteChild(t *testing.T, s *Store, name string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, "/children", strings.NewReader(`{"name":"`+name+`"}`))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE %s: expected 200, got %d", name, rec.Code)
+	}
+}
+
+func readHistory(t *testing.T, s *Store) []Change {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.HandleHistory(rec, httptest.NewRequest(http.MethodGet, "/children/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET history: expected 200, got %d", rec.Code)
+	}
+	var changes []Change
+	json.NewDecoder(rec.Body).Decode(&changes)
+	return changes
+}
+
+func TestUndoRestoresDeletedChild(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna","Ben","Clara"]`)
+	deleteChild(t, s, "Ben")
+
+	rec := httptest.NewRecorder()
+	s.HandleUndo(rec, httptest.NewRequest(http.MethodPost, "/children/undo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := s.Names(); len(got) != 3 || got[1] != "Ben" {
+		t.Errorf("expected Ben to be back, got %v", got)
+	}
+
+	changes := readHistory(t, s)
+	if len(changes) != 2 || changes[0].Op != changeUndo || changes[0].Undoes != changes[1].Version {
+		t.Errorf("unexpected history %+v", changes)
+	}
+
+	// The delete was undone and nothing older is left.
+	rec = httptest.NewRecorder()
+	s.HandleUndo(rec, httptest.NewRequest(http.MethodPost, "/children/undo", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestUndoStepsBack(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna","Ben","Clara"]`)
+	deleteChild(t, s, "Ben")
+	deleteChild(t, s, "Clara")
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		s.HandleUndo(rec, httptest.NewRequest(http.MethodPost, "/children/undo", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if got := s.Names(); len(got) != 3 {
+		t.Errorf("expected all children back, got %v", got)
+	}
+}
+
+func TestUndoConflictsWithUnrecordedChange(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna","Ben"]`)
+	deleteChild(t, s, "Ben")
+
+	// Simulate a change that bypassed the history.
+	s.mu.Lock()
+	s.data.Children = append(s.data.Children, Child{Name: "Zoe"})
+	s.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	s.HandleUndo(rec, httptest.NewRequest(http.MethodPost, "/children/undo", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", rec.Code)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna","Ben","Clara"]`)
+	deleteChild(t, s, "Ben")
+	deleteChild(t, s, "Clara")
+
+	first := readHistory(t, s)[1]
+	body := strings.NewReader(`{"version":` + strconv.Itoa(first.Version) + `}`)
+	rec := httptest.NewRecorder()
+	s.HandleRestore(rec, httptest.NewRequest(http.MethodPost, "/children/restore", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := s.Names(); len(got) != 2 || got[1] != "Clara" {
+		t.Errorf("expected [Anna Clara], got %v", got)
+	}
+	if changes := readHistory(t, s); changes[0].Op != changeRestore {
+		t.Errorf("restore not recorded: %+v", changes[0])
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleRestore(rec, httptest.NewRequest(http.MethodPost, "/children/restore", strings.NewReader(`{"version":99}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown version, got %d", rec.Code)
+	}
+}
+
+func TestHistorySurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	s, path := newHistoryStore(t, `["Anna","Ben"]`)
+	deleteChild(t, s, "Ben")
+
+	s2, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error: %v", err)
+	}
+	changes := readHistory(t, s2)
+	if len(changes) != 1 || changes[0].Name != "Ben" {
+		t.Fatalf("unexpected history after reload %+v", changes)
+	}
+
+	// Versions continue after a restart instead of starting over.
+	deleteChild(t, s2, "Anna")
+	if changes := readHistory(t, s2); changes[0].Version != changes[1].Version+1 {
+		t.Errorf("versions not continued: %+v", changes)
+	}
+
+	rec := httptest.NewRecorder()
+	s2.HandleUndo(rec, httptest.NewRequest(http.MethodPost, "/children/undo", nil))
+	if rec.Code != http.StatusOK || len(s2.Names()) != 1 {
+		t.Errorf("undo after restart: status %d, names %v", rec.Code, s2.Names())
+	}
+}
+
+func TestEraseScrubsHistory(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna","Ben"]`)
+	deleteChild(t, s, "Anna")
+
+	report := s.Erase("Ben", "test")
+	if report.HistoryEntries != 1 {
+		t.Errorf("expected 1 scrubbed history entry, got %d", report.HistoryEntries)
+	}
+	for _, c := range readHistory(t, s) {
+		if indexOf(c.Before.Children, "Ben") >= 0 || indexOf(c.After.Children, "Ben") >= 0 {
+			t.Errorf("Ben still in history entry %+v", c)
+		}
+	}
+}
+
+func TestHistoryIsTrimmed(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newHistoryStore(t, `["Anna"]`)
+	s.mu.Lock()
+	for range maxHistory + historyTrimSlack + 1 {
+		if err := s.record("test", opAdd, "Anna", s.data.clone()); err != nil {
+			t.Fatalf("record() error: %v", err)
+		}
+	}
+	s.mu.Unlock()
+
+	changes, err := s.backend.Changes()
+	if err != nil {
+		t.Fatalf("Changes() error: %v", err)
+	}
+	if len(changes) != maxHistory {
+		t.Fatalf("expected %d changes, got %d", maxHistory, len(changes))
+	}
+	if last := changes[len(changes)-1].Version; last != maxHistory+historyTrimSlack+1 {
+		t.Errorf("newest version = %d, want %d", last, maxHistory+historyTrimSlack+1)
+	}
+}
+
+func TestUnrecordedChangeIsReported(t *testing.T) {
+	t.Parallel()
+
+	s, path := newHistoryStore(t, `["Anna"]`)
+	// A directory in place of the history file makes appending fail.
+	if err := os.Mkdir(historyPath(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(`{"name":"Ben"}`)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "saved") {
+		t.Errorf("response must say the change was saved, got %q", rec.Body.String())
+	}
+	if got := s.Names(); len(got) != 2 {
+		t.Errorf("the change must stay saved, got %v", got)
+	}
+}
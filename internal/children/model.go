@@ -0,0 +1,170 @@
+package children
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"strings"
+)
+
+// Child is one entry in the children list. Children are identified by their
+// name; all other fields are optional.
+type Child struct {
+	Name string `json:"name"`
+	// Family links siblings so their parents can be called with a single
+	// message. It refers to a key of the file's "families" object.
+	Family string `json:"family,omitempty"`
+	// Private shows the child's pickup code on screen instead of the name.
+	Private bool `json:"private,omitempty"`
+	// Code is the child's pickup code. It is assigned when the child is
+	// saved while private or while privacy mode is on.
+	Code string `json:"code,omitempty"`
+	// LastSeen is the day (YYYY-MM-DD) the child was last called or added.
+	// It is only tracked while a retention period is set.
+	LastSeen string `json:"lastSeen,omitempty"`
+}
+
+// isPlain reports whether the child has nothing but a name and can be
+// written to the file as a bare string.
+func (c Child) isPlain() bool {
+	return c.Family == "" && !c.Private && c.Code == "" && c.LastSeen == ""
+}
+
+// UnmarshalJSON accepts either a bare name ("Anna") or an object
+// ({"name":"Anna","family":"mueller"}), so plain entries in children.json
+// stay as simple as before.
+func (c *Child) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*c = Child{}
+		return json.Unmarshal(data, &c.Name)
+	}
+	type plain Child // avoids recursing into this method
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*c = Child(p)
+	return nil
+}
+
+// Family holds the display data shared by siblings.
+type Family struct {
+	// Surname is appended to the combined first names on screen, e.g.
+	// "Anna & Ben Müller". Optional.
+	Surname string `json:"surname,omitempty"`
+}
+
+// fileObject is the object form of children.json. It is only written when
+// families are in use; otherwise the file stays a plain array of names.
+type fileObject struct {
+	Children []Child           `json:"children"`
+	Families map[string]Family `json:"families,omitempty"`
+}
+
+// Data is the children list as stored by a Backend: the children in any
+// order plus the families they refer to.
+type Data struct {
+	Children []Child           `json:"children"`
+	Families map[string]Family `json:"families,omitempty"`
+}
+
+// names returns the children's names in list order.
+func (c Data) names() []string {
+	out := make([]string, len(c.Children))
+	for i, child := range c.Children {
+		out[i] = child.Name
+	}
+	return out
+}
+
+// parseContents decodes children.json. It accepts the plain form, a JSON
+// array whose entries are names or child objects, and the object form
+// {"children":[...],"families":{...}}. Names are normalised.
+func parseContents(data []byte) (Data, error) {
+	var c Data
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var obj fileObject
+		if err := json.Unmarshal(trimmed, &obj); err != nil {
+			return Data{}, err
+		}
+		c.Children, c.Families = obj.Children, obj.Families
+	} else if err := json.Unmarshal(trimmed, &c.Children); err != nil {
+		return Data{}, err
+	}
+
+	// Normalise names and drop blank entries left over from manual edits.
+	children := make([]Child, 0, len(c.Children))
+	for _, child := range c.Children {
+		child.Name = normalizeName(child.Name)
+		child.Family = strings.TrimSpace(child.Family)
+		child.Code = strings.ToUpper(strings.TrimSpace(child.Code))
+		if child.Name != "" {
+			children = append(children, child)
+		}
+	}
+	c.Children = children
+	return c, nil
+}
+
+// encode renders the data in the file format: a plain array of names as
+// long as no child has extra fields and no families are defined, otherwise
+// the object form with plain children still written as bare names.
+func (c Data) encode() ([]byte, error) {
+	plain := len(c.Families) == 0
+	entries := make([]any, len(c.Children))
+	for i, child := range c.Children {
+		if child.isPlain() {
+			entries[i] = child.Name
+		} else {
+			entries[i] = child
+			plain = false
+		}
+	}
+
+	var v any = entries
+	if !plain {
+		v = struct {
+			Children []any             `json:"children"`
+			Families map[string]Family `json:"families,omitempty"`
+		}{entries, c.Families}
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// familyMembers returns the names of the children in the given family, in
+// list order.
+func (c Data) familyMembers(id string) []string {
+	var names []string
+	for _, child := range c.Children {
+		if child.Family == id {
+			names = append(names, child.Name)
+		}
+	}
+	return names
+}
+
+// dropEmptiedFamilies deletes the families that had children in before but
+// have none left, so removing a family's last child does not leave its
+// surname behind. A family whose surname was set before any child was
+// linked is kept.
+func (c *Data) dropEmptiedFamilies(before Data) {
+	var emptied []string
+	for id := range c.Families {
+		if len(c.familyMembers(id)) == 0 && len(before.familyMembers(id)) > 0 {
+			emptied = append(emptied, id)
+		}
+	}
+	if len(emptied) == 0 {
+		return
+	}
+	// The map may be shared with a snapshot.
+	c.Families = maps.Clone(c.Families)
+	for _, id := range emptied {
+		delete(c.Families, id)
+	}
+}
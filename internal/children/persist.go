@@ -0,0 +1,63 @@
+package children
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// backupGenerations is the number of rotating backups kept next to the
+// children file (children.json.bak.1 is the newest).
+const backupGenerations = 3
+
+// backupPath returns the path of the n-th backup generation (1 = newest).
+func backupPath(filePath string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", filePath, n)
+}
+
+// rotateBackups shifts the existing backup generations by one and copies the
+// current children file into generation 1. A primary file that does not parse
+// is not backed up, so a corrupt file never displaces a good backup.
+func rotateBackups(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading children file %q: %w", filePath, err)
+	}
+	if _, err := parseContents(data); err != nil {
+		return nil
+	}
+
+	for n := backupGenerations - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(filePath, n), backupPath(filePath, n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotating backup %q: %w", backupPath(filePath, n), err)
+		}
+	}
+	if err := atomicfile.WriteFile(backupPath(filePath, 1), data, 0644); err != nil {
+		return fmt.Errorf("writing backup %q: %w", backupPath(filePath, 1), err)
+	}
+	return nil
+}
+
+// loadFromBackups returns the Data of the newest backup generation that
+// parses successfully, together with the path it was read from.
+func loadFromBackups(filePath string) (Data, string, bool) {
+	for n := 1; n <= backupGenerations; n++ {
+		path := backupPath(filePath, n)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		c, err := parseContents(data)
+		if err != nil {
+			log.Printf("WARNING: backup %s is also unreadable: %v", path, err)
+			continue
+		}
+		return c, path, true
+	}
+	return Data{}, "", false
+}
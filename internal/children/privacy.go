@@ -0,0 +1,218 @@
+package children
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+)
+
+// codeAlphabet omits characters that are easily confused on a screen
+// (0/O, 1/I/L, 2/Z, 5/S, 8/B, G/6).
+const codeAlphabet = "ACDEFHJKMNPRTUVWXY3479"
+
+// codeLength gives 22³ ≈ 10,000 codes, plenty for one children's ministry
+// while staying easy to read from the back of the room.
+const codeLength = 3
+
+// maxCodeAttempts is how many random codes newCode tries before it looks
+// for the free ones.
+const maxCodeAttempts = 100
+
+// errNoFreeCode is returned by newCode when every pickup code is taken.
+var errNoFreeCode = errors.New("every pickup code is taken")
+
+// newCode returns a random pickup code that is not in taken. If random
+// guesses keep hitting taken codes, it picks one of the free codes; if there
+// are none, it returns errNoFreeCode.
+func newCode(taken map[string]bool) (string, error) {
+	total := 1
+	for range codeLength {
+		total *= len(codeAlphabet)
+	}
+	for range maxCodeAttempts {
+		n, err := randIndex(total)
+		if err != nil {
+			return "", err
+		}
+		if code := codeAt(n); !taken[code] {
+			return code, nil
+		}
+	}
+
+	var free []string
+	for n := range total {
+		if code := codeAt(n); !taken[code] {
+			free = append(free, code)
+		}
+	}
+	if len(free) == 0 {
+		return "", errNoFreeCode
+	}
+	n, err := randIndex(len(free))
+	if err != nil {
+		return "", err
+	}
+	return free[n], nil
+}
+
+// codeAt returns the n-th pickup code, counting in base len(codeAlphabet).
+func codeAt(n int) string {
+	b := make([]byte, codeLength)
+	for i := codeLength - 1; i >= 0; i-- {
+		b[i] = codeAlphabet[n%len(codeAlphabet)]
+		n /= len(codeAlphabet)
+	}
+	return string(b)
+}
+
+// randIndex returns a random number in [0, n).
+func randIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("generating pickup code: %w", err)
+	}
+	return int(i.Int64()), nil
+}
+
+// saveFailed reports a change that could not be saved: 409 if no pickup
+// code was left for a child that needs one, 500 with msg otherwise.
+func saveFailed(w http.ResponseWriter, err error, msg string) {
+	log.Printf("WARNING: %v", err)
+	if errors.Is(err, errNoFreeCode) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// needsCode reports whether the child's code is shown instead of its name.
+func (s *Store) needsCode(c Child) bool {
+	return s.privacyMode || c.Private
+}
+
+// assignCodes gives every child that needs a pickup code and has none a new
+// unique code. It reports whether any code was assigned. The caller must
+// hold s.mu.
+func (s *Store) assignCodes() (bool, error) {
+	taken := make(map[string]bool, len(s.data.Children))
+	for _, c := range s.data.Children {
+		if c.Code != "" {
+			taken[c.Code] = true
+		}
+	}
+
+	assigned := false
+	for i, c := range s.data.Children {
+		if c.Code == "" && s.needsCode(c) {
+			code, err := newCode(taken)
+			if err != nil {
+				return assigned, err
+			}
+			taken[code] = true
+			s.data.Children[i].Code = code
+			assigned = true
+		}
+	}
+	return assigned, nil
+}
+
+// SetPrivacyMode turns the global privacy mode on or off. While it is on,
+// every child's pickup code is shown on screen instead of the name; children
+// without a code get one and the list is saved.
+func (s *Store) SetPrivacyMode(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.privacyMode = on
+	before := s.data.clone()
+	assigned, err := s.assignCodes()
+	if err != nil {
+		return err
+	}
+	if !assigned {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	err = s.record("server", changeCodes, "", before)
+	s.notify()
+	return err
+}
+
+// PickupCode returns the code to show on screen instead of the child's name.
+// hide is true if the name must not be shown: the child is private, or
+// privacy mode is on, even for names that are not on the list. code is
+// empty if hide is true but there is no code to show instead. A child that
+// needs a code but has none yet (e.g. after a manual file edit) gets one.
+func (s *Store) PickupCode(name string) (code string, hide bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := indexOf(s.data.Children, normalizeName(name))
+	if idx < 0 {
+		return "", s.privacyMode
+	}
+	if !s.needsCode(s.data.Children[idx]) {
+		return "", false
+	}
+	if s.data.Children[idx].Code == "" {
+		before := s.data.clone()
+		if _, err := s.assignCodes(); err != nil {
+			log.Printf("WARNING: %v", err)
+			return "", true
+		}
+		// The code is used even if it cannot be persisted; the next
+		// successful save stores it.
+		if err := s.save(); err == nil {
+			if err := s.record("server", changeCodes, "", before); err != nil {
+				log.Printf("WARNING: %v", err)
+			}
+			s.notify()
+		}
+	}
+	return s.data.Children[idx].Code, true
+}
+
+// LookupCode is PickupCode without side effects, for previews: a child that
+// needs a code but has none yet is reported with hide true and no code, and
+// nothing is assigned or saved.
+func (s *Store) LookupCode(name string) (code string, hide bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx := indexOf(s.data.Children, normalizeName(name))
+	if idx < 0 {
+		return "", s.privacyMode
+	}
+	if !s.needsCode(s.data.Children[idx]) {
+		return "", false
+	}
+	return s.data.Children[idx].Code, true
+}
+
+// HandleCodes handles GET /children/codes and returns the pickup codes of
+// all children whose code is shown on screen, as {"Anna":"K7M"}. Workers use
+// it to match a code on screen to the child.
+func (s *Store) HandleCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	codes := make(map[string]string)
+	for _, c := range s.data.Children {
+		if c.Code != "" && s.needsCode(c) {
+			codes[c.Name] = c.Code
+		}
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
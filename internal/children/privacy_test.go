@@ -0,0 +1,161 @@
+package children
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewCodeIsUniqueAndReadable(t *testing.T) {
+	t.Parallel()
+
+	taken := make(map[string]bool)
+	for range 500 {
+		code, err := newCode(taken)
+		if err != nil {
+			t.Fatalf("newCode() error: %v", err)
+		}
+		if len(code) != codeLength {
+			t.Fatalf("code %q has wrong length", code)
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(codeAlphabet, r) {
+				t.Fatalf("code %q contains %q", code, r)
+			}
+		}
+		if taken[code] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		taken[code] = true
+	}
+}
+
+func TestNewCodeWhenCodesRunOut(t *testing.T) {
+	t.Parallel()
+
+	taken := make(map[string]bool)
+	total := 1
+	for range codeLength {
+		total *= len(codeAlphabet)
+	}
+	for n := range total {
+		taken[codeAt(n)] = true
+	}
+	if len(taken) != total {
+		t.Fatalf("codeAt gave %d distinct codes, want %d", len(taken), total)
+	}
+	if _, err := newCode(taken); !errors.Is(err, errNoFreeCode) {
+		t.Fatalf("all taken: got %v, want errNoFreeCode", err)
+	}
+
+	// The last free code is found, however unlikely a random guess is.
+	delete(taken, "K7M")
+	if code, err := newCode(taken); err != nil || code != "K7M" {
+		t.Errorf("newCode() = %q, %v; want K7M", code, err)
+	}
+}
+
+func TestPickupCodePrivateChild(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/children/Anna", strings.NewReader(`{"private":true}`))
+	req.SetPathValue("id", "Anna")
+	rec := httptest.NewRecorder()
+	s.HandleEdit(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	code, ok := s.PickupCode("Anna")
+	if !ok || len(code) != codeLength {
+		t.Fatalf("PickupCode(Anna) = %q, %v", code, ok)
+	}
+	if _, ok := s.PickupCode("Ben"); ok {
+		t.Error("Ben is not private and must be shown by name")
+	}
+
+	// The code is persisted and stays the same after a reload.
+	s2, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error: %v", err)
+	}
+	if again, _ := s2.PickupCode("Anna"); again != code {
+		t.Errorf("code changed after reload: %q != %q", again, code)
+	}
+
+	rec = httptest.NewRecorder()
+	s2.HandleCodes(rec, httptest.NewRequest(http.MethodGet, "/children/codes", nil))
+	var codes map[string]string
+	json.NewDecoder(rec.Body).Decode(&codes)
+	if len(codes) != 1 || codes["Anna"] != code {
+		t.Errorf("unexpected codes %v", codes)
+	}
+}
+
+func TestSetPrivacyModeAssignsCodes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	if err := s.SetPrivacyMode(true); err != nil {
+		t.Fatalf("SetPrivacyMode() error: %v", err)
+	}
+
+	a, okA := s.PickupCode("Anna")
+	b, okB := s.PickupCode("Ben")
+	if !okA || !okB || a == b {
+		t.Errorf("expected distinct codes, got %q, %q", a, b)
+	}
+	if names := s.Names(); len(names) != 2 || names[0] != "Anna" {
+		t.Errorf("names must stay unchanged, got %v", names)
+	}
+	// Names that are not on the list have no code but must not be shown.
+	if code, hide := s.PickupCode("Clara"); !hide || code != "" {
+		t.Errorf("PickupCode(Clara) = %q, %v; want no code, hidden", code, hide)
+	}
+}
+
+func TestLookupCodeDoesNotAssign(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	// A manual edit marked Anna private without giving her a code.
+	os.WriteFile(path, []byte(`{"children":[{"name":"Anna","private":true}]}`), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+
+	if code, hide := s.LookupCode("Anna"); !hide || code != "" {
+		t.Errorf("LookupCode(Anna) = %q, %v; want no code, hidden", code, hide)
+	}
+	if s.data.Children[0].Code != "" {
+		t.Error("LookupCode assigned a code")
+	}
+	if code, hide := s.PickupCode("Anna"); !hide || code == "" {
+		t.Errorf("PickupCode(Anna) = %q, %v; want a code", code, hide)
+	}
+	if again, _ := s.LookupCode("Anna"); again != s.data.Children[0].Code {
+		t.Errorf("LookupCode(Anna) = %q, want the assigned code", again)
+	}
+}
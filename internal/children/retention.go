@@ -0,0 +1,290 @@
+package children
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+	"github.com/tafli/CallingParents/internal/auth"
+)
+
+// dayLayout is the format of Child.LastSeen. A day is precise enough for a
+// retention period in weeks and keeps saves to one per child and day.
+const dayLayout = "2006-01-02"
+
+// SetRetention sets how long a child may go without being called before
+// Prune removes it. Zero disables retention and stops tracking LastSeen.
+func (s *Store) SetRetention(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = d
+}
+
+// stampLastSeen sets LastSeen to today for children that have none, so the
+// retention period of children added before retention was enabled starts
+// now. The caller must hold s.mu.
+func (s *Store) stampLastSeen(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	today := now.Format(dayLayout)
+	for i := range s.data.Children {
+		if s.data.Children[i].LastSeen == "" {
+			s.data.Children[i].LastSeen = today
+		}
+	}
+}
+
+// lastSeenDelay is how long touch waits before saving new LastSeen days, so
+// all calls of a service end up in one save rather than one save (and one
+// backup generation) per child.
+const lastSeenDelay = 5 * time.Minute
+
+// touch records that the child at idx was active on the given day. The list
+// is saved by flushLastSeen after lastSeenDelay, unless another save comes
+// first. The caller must hold s.mu.
+func (s *Store) touch(idx int, at time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	day := at.Format(dayLayout)
+	if s.data.Children[idx].LastSeen >= day {
+		return
+	}
+	s.data.Children[idx].LastSeen = day
+	s.lastSeenDirty = true
+	if s.lastSeenTimer == nil {
+		s.lastSeenTimer = time.AfterFunc(lastSeenDelay, s.flushLastSeen)
+	}
+}
+
+// flushLastSeen saves the LastSeen days recorded by touch, if they have not
+// been saved yet. Close calls it too.
+func (s *Store) flushLastSeen() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastSeenTimer != nil {
+		s.lastSeenTimer.Stop()
+		s.lastSeenTimer = nil
+	}
+	if !s.lastSeenDirty {
+		return
+	}
+	if err := s.save(); err != nil {
+		log.Printf("WARNING: recording last call: %v", err)
+	}
+}
+
+// Prune removes children whose last call is longer ago than the retention
+// period and returns their names. It does nothing if no retention period is
+// set. If the change cannot be recorded in the history, the children are
+// still removed and returned with the error.
+func (s *Store) Prune(now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retention <= 0 {
+		return nil, nil
+	}
+
+	cutoff := now.Add(-s.retention).Format(dayLayout)
+	var removed []string
+	var kept []Child
+	for _, c := range s.data.Children {
+		if c.LastSeen != "" && c.LastSeen < cutoff {
+			removed = append(removed, c.Name)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	previous := s.data.clone()
+	s.data.Children = kept
+	s.data.dropEmptiedFamilies(previous)
+	if err := s.save(); err != nil {
+		s.data = previous
+		return nil, err
+	}
+	for _, name := range removed {
+		s.forget(name)
+	}
+	// Expired children must not live on in the history either.
+	if _, err := s.scrubHistory(removed...); err != nil {
+		log.Printf("WARNING: removing expired children from history: %v", err)
+	}
+	// Before equals After: the removed children are gone from the history,
+	// so an undo of this entry cannot bring them back.
+	err := s.record("server", changePrune, "", s.data.clone())
+	s.setDuplicates(findDuplicates(s.data.names()))
+	s.notify()
+	return removed, err
+}
+
+// forget drops in-memory data kept about a name outside the list. The caller
+// must hold s.mu.
+func (s *Store) forget(name string) {
+	delete(s.lastActive, searchKey(name))
+}
+
+// ErasureReport describes what POST /children/{id}/erase deleted.
+type ErasureReport struct {
+	Name string `json:"name"`
+	// Store is true if the child was removed from the current list.
+	Store bool `json:"store"`
+	// Backups lists the backup files the name was removed from.
+	Backups []string `json:"backups"`
+	// HistoryEntries is the number of change history entries the child was
+	// removed from.
+	HistoryEntries int `json:"historyEntries"`
+	// LogEntries is the number of activity log entries removed, including
+	// those logged under earlier names of the child.
+	LogEntries int `json:"logEntries"`
+	// Errors lists the steps that failed; the erasure is incomplete if it
+	// is not empty.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Erase removes every trace of a child: the entry in the list, the copies in
+// the backup files and the change history, and the activity log entries. It
+// continues after a failed step and lists the failure in the report. who is
+// recorded in the history.
+func (s *Store) Erase(name, who string) ErasureReport {
+	name = normalizeName(name)
+	report := ErasureReport{Name: name, Backups: []string{}}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idx := indexOf(s.data.Children, name); idx >= 0 {
+		report.Name = s.data.Children[idx].Name
+		previous := s.data.clone()
+		s.data.Children = slices.Delete(slices.Clone(previous.Children), idx, idx+1)
+		s.data.dropEmptiedFamilies(previous)
+		if err := s.save(); err != nil {
+			s.data = previous
+			report.Errors = append(report.Errors, fmt.Sprintf("store: %v", err))
+		} else {
+			report.Store = true
+			s.setDuplicates(findDuplicates(s.data.names()))
+			s.notify()
+		}
+	}
+	// Earlier names of the child are erased too; the activity log knows
+	// its renames.
+	names, err := s.logger.LinkedNames(report.Name)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("earlier names: %v", err))
+	}
+	if !slices.ContainsFunc(names, func(n string) bool { return foldKey(n) == foldKey(report.Name) }) {
+		names = append(names, report.Name)
+	}
+	for _, name := range names {
+		s.forget(name)
+	}
+
+	scrubbed, err := s.scrubHistory(names...)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("history: %v", err))
+	}
+	report.HistoryEntries = scrubbed
+	if report.Store {
+		// The entry does not name the child; Before equals After so an
+		// undo cannot bring it back.
+		if err := s.record(who, changeErase, "", s.data.clone()); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	// Backups are purged after the save, which rotated the old list (still
+	// containing the name) into the newest generation. Only file storage
+	// keeps backups.
+	for n := 1; s.filePath != "" && n <= backupGenerations; n++ {
+		path := backupPath(s.filePath, n)
+		purged, err := purgeBackup(path, names)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("backup %s: %v", path, err))
+		}
+		if purged {
+			report.Backups = append(report.Backups, path)
+		}
+	}
+
+	count, err := s.logger.Erase(report.Name)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("activity log: %v", err))
+	}
+	report.LogEntries = count
+	return report
+}
+
+// purgeBackup removes names from the backup file at path. A backup that
+// cannot be parsed but contains one of the names is deleted. It reports
+// whether the file was changed.
+func purgeBackup(path string, names []string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	c, err := parseContents(data)
+	if err != nil {
+		lower := bytes.ToLower(data)
+		if !slices.ContainsFunc(names, func(n string) bool { return bytes.Contains(lower, []byte(strings.ToLower(n))) }) {
+			return false, nil
+		}
+		return true, os.Remove(path)
+	}
+
+	before := len(c.Children)
+	for _, name := range names {
+		if idx := indexOf(c.Children, name); idx >= 0 {
+			c.Children = slices.Delete(c.Children, idx, idx+1)
+		}
+	}
+	if len(c.Children) == before {
+		return false, nil
+	}
+	out, err := c.encode()
+	if err != nil {
+		return false, err
+	}
+	return true, atomicfile.WriteFile(path, out, 0644)
+}
+
+// HandleErase handles POST /children/{id}/erase, where id is the child's
+// name. It erases the child from the list, the backups, the history and the
+// activity log and returns an ErasureReport. The status is 500 if any step failed.
+func (s *Store) HandleErase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := normalizeName(r.PathValue("id"))
+	if name == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	report := s.Erase(name, auth.RequestDevice(r))
+	status := http.StatusOK
+	if len(report.Errors) > 0 {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
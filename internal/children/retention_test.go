@@ -0,0 +1,178 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+)
+
+func TestPruneRemovesChildrenNotCalled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	old := time.Now().AddDate(0, 0, -60).Format(dayLayout)
+	os.WriteFile(path, []byte(`[{"name":"Anna","lastSeen":"`+old+`"},{"name":"Ben","lastSeen":"`+old+`"},"Clara"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	s.SetRetention(4 * 7 * 24 * time.Hour)
+	s.RecordActivity("Ben", time.Now())
+
+	removed, err := s.Prune(time.Now())
+	if err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "Anna" {
+		t.Errorf("expected Anna to be removed, got %v", removed)
+	}
+	// Clara had no LastSeen and starts her retention period now.
+	if names := s.Names(); len(names) != 2 || names[0] != "Ben" || names[1] != "Clara" {
+		t.Errorf("unexpected names after prune: %v", names)
+	}
+}
+
+func TestRecordActivityBatchesSaves(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "children.json")
+	old := time.Now().AddDate(0, 0, -3).Format(dayLayout)
+	os.WriteFile(path, []byte(`[{"name":"Anna","lastSeen":"`+old+`"},{"name":"Ben","lastSeen":"`+old+`"}]`), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	s.SetRetention(4 * 7 * 24 * time.Hour)
+
+	s.RecordActivity("Anna", time.Now())
+	s.RecordActivity("Ben", time.Now())
+	if _, err := os.Stat(backupPath(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected no save before the delay, got backup: %v", err)
+	}
+
+	s.flushLastSeen()
+	today := time.Now().Format(dayLayout)
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), today) != 2 {
+		t.Errorf("expected both days saved, got %s", data)
+	}
+	// One save, so one backup generation.
+	if _, err := os.Stat(backupPath(path, 2)); !os.IsNotExist(err) {
+		t.Errorf("expected a single save, got a second backup: %v", err)
+	}
+}
+
+func TestPruneWithoutRetentionKeepsEverything(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`[{"name":"Anna","lastSeen":"2000-01-01"}]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	if removed, _ := s.Prune(time.Now()); len(removed) != 0 {
+		t.Errorf("expected nothing removed, got %v", removed)
+	}
+}
+
+func TestHandleEraseRemovesAllTraces(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Anna","Ben"]`), 0644)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	logPath := filepath.Join(dir, "activity.jsonl")
+	logger, err := activitylog.New(logPath)
+	if err != nil {
+		t.Fatalf("activitylog.New() error: %v", err)
+	}
+	defer logger.Close()
+	s.SetLogger(logger)
+	logger.LogSend("Anna", "", "")
+	logger.LogSend("Ben", "", "")
+
+	// Create a backup generation that still contains Anna.
+	body, _ := json.Marshal(addRequest{Name: "Clara"})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/children", strings.NewReader(string(body))))
+
+	req := httptest.NewRequest(http.MethodPost, "/children/anna/erase", nil)
+	req.SetPathValue("id", "anna")
+	rec := httptest.NewRecorder()
+	s.HandleErase(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var report ErasureReport
+	json.NewDecoder(rec.Body).Decode(&report)
+	if !report.Store || report.Name != "Anna" || report.LogEntries != 1 || len(report.Backups) == 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	for _, p := range []string{path, backupPath(path, 1), backupPath(path, 2), logPath} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(data), "Anna") {
+			t.Errorf("%s still contains Anna: %s", filepath.Base(p), data)
+		}
+	}
+	if names := s.Names(); len(names) != 2 {
+		t.Errorf("expected Ben and Clara to remain, got %v", names)
+	}
+}
+
+func TestEraseRemovesEarlierNamesFromBackups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "children.json")
+	os.WriteFile(path, []byte(`["Ben","Jurgen"]`), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	logger, err := activitylog.New(filepath.Join(dir, "activity.jsonl"))
+	if err != nil {
+		t.Fatalf("activitylog.New() error: %v", err)
+	}
+	defer logger.Close()
+	s.SetLogger(logger)
+
+	// The rename rotates the list with "Jurgen" into a backup.
+	req := httptest.NewRequest(http.MethodPatch, "/children/Jurgen", strings.NewReader(`{"name":"Jürgen"}`))
+	req.SetPathValue("id", "Jurgen")
+	s.HandleEdit(httptest.NewRecorder(), req)
+
+	report := s.Erase("Jürgen", "test")
+	if len(report.Errors) > 0 {
+		t.Fatalf("Erase() errors: %v", report.Errors)
+	}
+	for _, p := range []string{path, backupPath(path, 1), backupPath(path, 2), historyPath(path)} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(data), "Jurgen") || strings.Contains(string(data), "Jürgen") {
+			t.Errorf("%s still contains the child: %s", filepath.Base(p), data)
+		}
+	}
+}
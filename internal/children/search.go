@@ -0,0 +1,286 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultSearchLimit is the number of results returned when the request
+// does not set ?limit=.
+const defaultSearchLimit = 20
+
+// recentWindow is how long after a call a child is ranked higher in search
+// results, so the child whose parents were just called is easy to find again.
+const recentWindow = 3 * time.Hour
+
+// Match scores, from best to worst. Typo matches score below matchTypo by the
+// number of edits.
+const (
+	matchExact      = 100
+	matchPrefix     = 90
+	matchWordPrefix = 80
+	matchSubstring  = 60
+	matchTypo       = 40
+	recentBoost     = 15
+)
+
+// stripMarks removes combining diacritical marks after canonical
+// decomposition, so "Jürgen" and "Jurgen" compare equal.
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// searchKey returns the diacritic-insensitive, case-folded form of a name
+// used for matching.
+func searchKey(name string) string {
+	key, _, err := transform.String(stripMarks, foldKey(name))
+	if err != nil {
+		return foldKey(name)
+	}
+	return key
+}
+
+// indexSearchKeys rebuilds the search keys for the current list. Search runs
+// on every keystroke, so keys are computed once per change of the list
+// rather than once per request; names that left the list are dropped, as
+// are calls too old to rank a child higher. The caller must hold s.mu for
+// writing.
+func (s *Store) indexSearchKeys() {
+	keys := make(map[string]string, len(s.data.Children))
+	for _, child := range s.data.Children {
+		keys[child.Name] = searchKey(child.Name)
+	}
+	s.searchKeys = keys
+	now := time.Now()
+	for key, at := range s.lastActive {
+		if now.Sub(at) >= recentWindow {
+			delete(s.lastActive, key)
+		}
+	}
+}
+
+// cachedSearchKey returns searchKey(name), from the index if name is on the
+// list. The caller must hold s.mu.
+func (s *Store) cachedSearchKey(name string) string {
+	if key, ok := s.searchKeys[name]; ok {
+		return key
+	}
+	return searchKey(name)
+}
+
+// RecordActivity notes that a child was called (or otherwise active) at the
+// given time. Recently active children are ranked higher by Search, and the
+// call restarts the child's retention period.
+func (s *Store) RecordActivity(name string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastActive == nil {
+		s.lastActive = make(map[string]time.Time)
+	}
+	s.lastActive[searchKey(name)] = at
+	if idx := indexOf(s.data.Children, normalizeName(name)); idx >= 0 {
+		s.touch(idx, at)
+	}
+}
+
+// Resolve returns the list name of the child meant by name: the child with
+// that name, ignoring case, or the only child whose name matches ignoring
+// diacritics ("Jurgen" for "Jürgen"), or the child with that pickup code.
+// ok is false if no child, or more than one, matches.
+func (s *Store) Resolve(name string) (canonical string, ok bool) {
+	name = normalizeName(name)
+	if name == "" {
+		return "", false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if idx := indexOf(s.data.Children, name); idx >= 0 {
+		return s.data.Children[idx].Name, true
+	}
+	key := searchKey(name)
+	code := strings.ToUpper(name)
+	for _, child := range s.data.Children {
+		if s.cachedSearchKey(child.Name) == key || (child.Code != "" && child.Code == code) {
+			if canonical != "" {
+				return "", false
+			}
+			canonical = child.Name
+		}
+	}
+	return canonical, canonical != ""
+}
+
+// scoreMatch rates how well the query key q matches the name key n. It
+// returns 0 if the name does not match.
+func scoreMatch(q, n string) int {
+	switch {
+	case n == q:
+		return matchExact
+	case strings.HasPrefix(n, q):
+		return matchPrefix
+	}
+
+	words := strings.Fields(n)
+	for _, w := range words {
+		if strings.HasPrefix(w, q) {
+			return matchWordPrefix
+		}
+	}
+	if strings.Contains(n, q) {
+		return matchSubstring
+	}
+
+	maxEdits := maxTypos(q)
+	if maxEdits == 0 {
+		return 0
+	}
+	rq := []rune(q)
+	best := maxEdits + 1
+	for _, c := range append(words, n) {
+		rc := []rune(c)
+		best = min(best, boundedDistance(rq, rc, maxEdits))
+		// Also compare against the start of the word, so a typo in a
+		// partly typed name ("Jrug" for "Jürgen") still matches.
+		if len(rc) > len(rq) {
+			best = min(best, boundedDistance(rq, rc[:len(rq)], maxEdits))
+		}
+	}
+	if best > maxEdits {
+		return 0
+	}
+	return matchTypo - 10*best
+}
+
+// maxTypos returns how many edits a query of this length may contain. Short
+// queries must match exactly, otherwise almost every name would match.
+func maxTypos(q string) int {
+	switch n := len([]rune(q)); {
+	case n < 3:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// boundedDistance returns runeDistance(a, b), or limit+1 if the lengths alone
+// show the distance must exceed limit. Most names fail this cheap check, which
+// keeps typo matching fast enough to run on every keystroke.
+func boundedDistance(a, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	return runeDistance(a, b)
+}
+
+// runeDistance returns the Damerau-Levenshtein distance (optimal string
+// alignment variant) between ra and rb: the number of insertions, deletions,
+// substitutions and transpositions of adjacent characters.
+func runeDistance(ra, rb []rune) int {
+	// Three rolling rows are enough for the transposition lookback.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// Search returns up to limit names matching query, best matches first.
+// Matching ignores case and diacritics and tolerates small typos; children
+// called within the last few hours are ranked higher. An empty query returns
+// the whole list with recently called children first.
+func (s *Store) Search(query string, limit int) []string {
+	q := searchKey(query)
+	now := time.Now()
+
+	type result struct {
+		name  string
+		score int
+	}
+
+	s.mu.RLock()
+	results := make([]result, 0, len(s.data.Children))
+	for _, child := range s.data.Children {
+		name := child.Name
+		key := s.cachedSearchKey(name)
+		score := matchExact
+		if q != "" {
+			score = scoreMatch(q, key)
+		}
+		if score == 0 {
+			continue
+		}
+		if at, ok := s.lastActive[key]; ok && now.Sub(at) < recentWindow {
+			score += recentBoost
+		}
+		results = append(results, result{name, score})
+	}
+	locale := s.locale
+	s.mu.RUnlock()
+
+	c := collate.New(locale, collate.Numeric)
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return c.CompareString(results[i].name, results[j].name) < 0
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	names := make([]string, len(results))
+	for i, r := range results {
+		names[i] = r.name
+	}
+	return names
+}
+
+// HandleSearch handles GET /children/search?q=...&limit=... and returns the
+// matching names as a JSON array, best matches first.
+func (s *Store) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Search(r.URL.Query().Get("q"), limit))
+}
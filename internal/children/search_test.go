@@ -0,0 +1,182 @@
+package children
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSearchStore(t *testing.T, names string) *Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "children.json")
+	os.WriteFile(path, []byte(names), 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error: %v", err)
+	}
+	return s
+}
+
+func TestSearchMatching(t *testing.T) {
+	t.Parallel()
+
+	s := newSearchStore(t, `["Anna Müller","Annika","Ben","Hannah","Jürgen","Johanna","Zoe"]`)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"ann", []string{"Anna Müller", "Annika", "Hannah", "Johanna"}},
+		{"mül", []string{"Anna Müller"}},
+		{"Jurgen", []string{"Jürgen"}},
+		{"jrügen", []string{"Jürgen"}}, // transposition
+		{"Hanah", []string{"Hannah"}},  // deletion
+		{"zo", []string{"Zoe"}},
+		{"xyz", []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			got := s.Search(tc.query, 0)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tc.query, got, tc.want)
+			}
+			for i := range tc.want {
+				if got[i] != tc.want[i] {
+					t.Errorf("Search(%q)[%d] = %q, want %q", tc.query, i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSearchBoostsRecentlyCalled(t *testing.T) {
+	t.Parallel()
+
+	s := newSearchStore(t, `["Anna","Annika","Antonia"]`)
+	s.RecordActivity("antonia", time.Now().Add(-10*time.Minute))
+	s.RecordActivity("Annika", time.Now().Add(-48*time.Hour))
+
+	got := s.Search("an", 0)
+	if len(got) != 3 || got[0] != "Antonia" {
+		t.Errorf("expected recently called Antonia first, got %v", got)
+	}
+}
+
+func TestSearchKeysFollowList(t *testing.T) {
+	t.Parallel()
+
+	s := newSearchStore(t, `["Anna","Jurgen"]`)
+	req := httptest.NewRequest(http.MethodPatch, "/children/Jurgen", strings.NewReader(`{"name":"Jürgen"}`))
+	req.SetPathValue("id", "Jurgen")
+	rec := httptest.NewRecorder()
+	s.HandleEdit(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename: expected 200, got %d", rec.Code)
+	}
+
+	// The renamed child's old name is no longer cached.
+	if len(s.searchKeys) != 2 || s.searchKeys["Jürgen"] != "jurgen" {
+		t.Errorf("searchKeys = %v", s.searchKeys)
+	}
+	if got := s.Search("jurg", 0); len(got) != 1 || got[0] != "Jürgen" {
+		t.Errorf("Search(jurg) = %v", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	s := newSearchStore(t, `{"children":[{"name":"Anna","private":true,"code":"K7M"},"Jürgen","Lena","Léna"]}`)
+
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{"Anna", "Anna", true},
+		{" anna ", "Anna", true},
+		{"Jurgen", "Jürgen", true},
+		{"k7m", "Anna", true},
+		{"Léna", "Léna", true},
+		{"Lèna", "", false}, // matches Lena and Léna
+		{"Frohe Ostern", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range tests {
+		got, ok := s.Resolve(tc.name)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("Resolve(%q) = %q, %v, want %q, %v", tc.name, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestRuneDistance(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"anna", "anna", 0},
+		{"anna", "ana", 1},
+		{"ben", "bne", 1},
+		{"jürgen", "jurgen", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tc := range tests {
+		if got := runeDistance([]rune(tc.a), []rune(tc.b)); got != tc.want {
+			t.Errorf("runeDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	t.Parallel()
+
+	s := newSearchStore(t, `["Anna","Annika","Ben"]`)
+
+	rec := httptest.NewRecorder()
+	s.HandleSearch(rec, httptest.NewRequest(http.MethodGet, "/children/search?q=ann&limit=1", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var names []string
+	json.NewDecoder(rec.Body).Decode(&names)
+	if len(names) != 1 || names[0] != "Anna" {
+		t.Errorf("expected [Anna], got %v", names)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleSearch(rec, httptest.NewRequest(http.MethodGet, "/children/search?q=a&limit=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid limit, got %d", rec.Code)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	names := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		names = append(names, "Kind "+string(rune('A'+i%26))+string(rune('a'+i/26%26))+"ürgen")
+	}
+	data, _ := json.Marshal(names)
+	path := filepath.Join(b.TempDir(), "children.json")
+	os.WriteFile(path, data, 0644)
+	s, err := NewStore(path)
+	if err != nil {
+		b.Fatalf("NewStore() error: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Search("jurgne", 20)
+	}
+}
@@ -0,0 +1,299 @@
+package children
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// debounceDelay is how long the watcher waits after the last file event
+// before reloading. Editors often save in several steps (write a temp file,
+// rename it, touch attributes), so reloading on the first event would read a
+// half-finished state.
+const debounceDelay = 250 * time.Millisecond
+
+// pollInterval is how often the polling fallback checks the file.
+const pollInterval = time.Second
+
+// keepAliveInterval is how often an idle event stream sends a comment line so
+// proxies and phones do not drop the connection.
+const keepAliveInterval = 30 * time.Second
+
+// Watch starts watching the children file for external changes and reloads
+// the store when it changes. It uses inotify where available and falls back
+// to polling the file's modification time. Call Close to stop watching.
+func (s *Store) Watch() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopWatch != nil {
+		return fmt.Errorf("already watching %q", s.filePath)
+	}
+	if s.filePath == "" {
+		// Other backends are only changed through the store.
+		s.watchMode = "none"
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	events, mode, err := watchFile(s.filePath, stop)
+	if err != nil {
+		log.Printf("File watching unavailable (%v), polling %s every %s", err, s.filePath, pollInterval)
+		events, mode = pollFile(s.filePath, stop), "polling"
+	}
+	s.stopWatch = stop
+	s.watchDone = done
+	s.watchMode = mode
+
+	go s.debounceReload(events, stop, done)
+	return nil
+}
+
+// Close stops the file watcher started by Watch, saves pending LastSeen
+// days and ends all event streams.
+func (s *Store) Close() error {
+	s.flushLastSeen()
+
+	s.mu.Lock()
+	stop, done := s.stopWatch, s.watchDone
+	s.stopWatch, s.watchDone = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.subMu.Lock()
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+	s.subMu.Unlock()
+	return nil
+}
+
+// debounceReload coalesces bursts of file events into a single reload.
+func (s *Store) debounceReload(events <-chan struct{}, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(debounceDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-events:
+			timer.Reset(debounceDelay)
+		case <-timer.C:
+			s.reload()
+		}
+	}
+}
+
+// reload re-reads the children file after an external change. Unlike load, a
+// syntax error keeps the last good list in memory and is only recorded, so a
+// half-edited file never empties the grid on every phone.
+func (s *Store) reload() {
+	c, err := readContents(s.filePath)
+
+	s.mu.Lock()
+	if err != nil {
+		if s.loadErr == nil || s.loadErr.Error() != err.Error() {
+			log.Printf("WARNING: %v — keeping the last good list of %d children", err, len(s.data.Children))
+		}
+		s.loadErr = err
+		s.mu.Unlock()
+		return
+	}
+	if s.loadErr != nil {
+		log.Printf("Children file %s is valid again", s.filePath)
+	}
+	s.loadErr = nil
+	before := s.data.clone()
+	s.setContents(c)
+	changed := s.etag() != computeETag(before)
+	if changed {
+		if err := s.record("file", changeEdit, "", before); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+	count := len(c.Children)
+	s.mu.Unlock()
+
+	if changed {
+		log.Printf("Reloaded %d children from %s", count, s.filePath)
+		s.notify()
+	}
+}
+
+// Subscribe returns a channel that receives a value whenever the children
+// list changes, and a function that ends the subscription. Notifications are
+// coalesced: a slow subscriber sees at most one pending change.
+func (s *Store) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.subMu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan struct{}]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	s.subMu.Unlock()
+
+	return ch, func() {
+		s.subMu.Lock()
+		defer s.subMu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify signals all subscribers that the list has changed.
+func (s *Store) notify() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// statusResponse is the JSON body returned by HandleStatus.
+type statusResponse struct {
+	File     string `json:"file"`
+	Count    int    `json:"count"`
+	LoadedAt string `json:"loadedAt,omitempty"`
+	Watcher  string `json:"watcher"`
+	Error    string `json:"error,omitempty"`
+	// Duplicates lists groups of names that only differ in case, spacing
+	// or Unicode normalisation.
+	Duplicates [][]string `json:"duplicates,omitempty"`
+}
+
+// HandleStatus reports the state of the children file for administrators:
+// how many names are loaded, when the file was last read successfully, the
+// syntax error of the current file, if any, and near-duplicate names.
+func (s *Store) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	resp := statusResponse{
+		File:    s.backend.String(),
+		Count:   len(s.data.Children),
+		Watcher: s.watchMode,
+	}
+	if resp.Watcher == "" {
+		resp.Watcher = "off"
+	}
+	if !s.loadedAt.IsZero() {
+		resp.LoadedAt = s.loadedAt.Format(time.RFC3339)
+	}
+	if s.loadErr != nil {
+		resp.Error = s.loadErr.Error()
+	}
+	resp.Duplicates = s.duplicates
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleEvents streams change notifications as server-sent events. Each
+// change sends a "children" event whose data is the current list of names.
+func (s *Store) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	changes, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(s.Names())
+			fmt.Fprintf(w, "event: children\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// pollFile reports changes to the file's size or modification time. It is
+// the fallback on platforms without a native file watcher.
+func pollFile(path string, stop <-chan struct{}) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	go func() {
+		last := fileStamp(path)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if cur := fileStamp(path); cur != last {
+					last = cur
+					signal(events)
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// fileStamp summarises the file's metadata for change detection.
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "missing"
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+}
+
+// signal does a non-blocking send on a coalescing event channel.
+func signal(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// isWatchedName reports whether a directory entry name refers to the
+// watched file.
+func isWatchedName(path, name string) bool {
+	return name == filepath.Base(path)
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package children
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile uses inotify to report changes to the file at path. It watches
+// the parent directory rather than the file itself, because many editors
+// replace the file by renaming a new one over it, which would orphan a
+// watch on the old inode.
+func watchFile(path string, stop <-chan struct{}) (<-chan struct{}, string, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, "", fmt.Errorf("inotify init: %w", err)
+	}
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_CREATE |
+		unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("inotify watch %q: %w", filepath.Dir(path), err)
+	}
+
+	// A non-blocking fd wrapped in os.File uses the runtime poller, so
+	// closing the file unblocks a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	events := make(chan struct{}, 1)
+
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + unix.SizeofInotifyEvent
+				nameEnd := nameStart + int(ev.Len)
+				if nameEnd > n {
+					break
+				}
+				name := string(buf[nameStart:nameEnd])
+				// The name is NUL-padded to an alignment boundary.
+				for i := 0; i < len(name); i++ {
+					if name[i] == 0 {
+						name = name[:i]
+						break
+					}
+				}
+				if isWatchedName(path, name) || ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+					signal(events)
+				}
+				offset = nameEnd
+			}
+		}
+	}()
+
+	return events, "inotify", nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package children
+
+import "errors"
+
+// watchFile is only implemented with inotify on Linux; other platforms use
+// the polling fallback.
+func watchFile(string, <-chan struct{}) (<-chan struct{}, string, error) {
+	return nil, "", errors.New("native file watching not supported on this platform")
+}
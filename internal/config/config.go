@@ -27,6 +27,33 @@ var allConfigBlocks = []configBlock{
 	{"auto_clear_seconds", "# Seconds after which a displayed message is automatically cleared.\n# Set to 0 to disable auto-clear.\nauto_clear_seconds = 30\n"},
 	{"activity_log", "# Path to activity log file (JSONL format, append-only).\n# Records send/clear events with timestamps. Leave empty to disable.\n# activity_log = \"activity.jsonl\"\n"},
 	{"auth_token", "# Bearer token for API authentication.\n# If not set, a random token is generated on each startup (printed in QR code).\n# Set this for a stable token that survives restarts.\n# auth_token = \"\"\n"},
+	{"locale", "# Locale used to sort children's names (e.g. \"de\" or \"en\").\n# Umlauts and accents sort next to their base letter.\nlocale = \"de\"\n"},
+	{"privacy_mode", "# Show pickup codes instead of children's names on the ProPresenter screen.\n# Workers still see the real names. Single children can also be marked\n# private in children.json.\nprivacy_mode = false\n"},
+	{"retention_weeks", "# Remove children who were not called for this many weeks (GDPR retention).\n# Set to 0 to keep children until they are deleted by hand.\nretention_weeks = 0\n"},
+	{"log_retention_months", "# Pseudonymise activity log entries older than this many months: names are\n# replaced so entries can still be counted but no longer identify a child.\n# Set to 0 to keep names in the log.\nlog_retention_months = 0\n"},
+	{"storage", "# Where children and activity data are stored: \"file\" uses children_file and\n# activity_log; \"bolt\" uses the embedded database at storage_path. Copy\n# existing files into the database with: calling-parents migrate [config.toml]\nstorage = \"file\"\n"},
+	{"storage_path", "# Database file used when storage = \"bolt\".\nstorage_path = \"calling-parents.db\"\n"},
+	{"strict_names", "# Only call children from the list: names typed into the send box must match\n# a child (ignoring case and accents) or a pickup code. Admins can still send\n# free text with admin_token.\nstrict_names = false\n"},
+	{"admin_token", "# Token with the admin role, sent as Bearer token or in the X-Admin-Token\n# header. Needed to change the children list, manage devices and send free\n# text while strict_names is on or text the word filter blocks. Leave empty to\n# disable it.\n# admin_token = \"\"\n"},
+	{"max_display_length", "# Maximum length of the text shown on screen, in characters. Longer names are\n# cut off. Emoji, control and zero-width characters are always removed.\n# Set to 0 for no limit.\nmax_display_length = 40\n"},
+	{"display_charset", "# Character set of the ProPresenter theme font: \"latin1\" or \"ascii\".\n# Other characters are transliterated (e.g. \"\u0141\" \u2192 \"L\", with ascii \"\u00fc\" \u2192 \"ue\")\n# or removed. Leave empty if the font covers all letters.\ndisplay_charset = \"\"\n"},
+	{"word_filter_dir", "# Directory with deny-lists, one file per language (de.txt, en.txt, ...).\n# Each line is a word or phrase, or a /regular expression/; # starts a comment.\n# Blocked sends are logged as \"rejected\". Leave empty to disable the filter.\nword_filter_dir = \"\"\n"},
+	{"devices_file", "# Enrolled devices and hashes of their tokens. Devices are enrolled with\n# one-time QR codes from POST /auth/enrollments (needs admin_token).\ndevices_file = \"devices.json\"\n"},
+	{"token_file", "# Token rotation: type \"rotate\" in the console or POST /auth/token/rotate\n# with the admin token to replace the shared token while the server runs.\n# If token_file is set and auth_token is not, the random token is saved\n# there so restarts do not force everyone to rescan.\ntoken_file = \"\"\n"},
+	{"token_grace_minutes", "# Minutes the previous token keeps working after a rotation, so phones in\n# use are not locked out mid-service.\ntoken_grace_minutes = 30\n"},
+	{"signing_key_file", "# Key for expiring tokens issued with POST /auth/tokens (admin), e.g. for\n# visiting volunteers. Generated on first start; delete it to revoke all\n# issued tokens.\nsigning_key_file = \"signing.key\"\n"},
+	{"lockout_attempts", "# Failed logins in a row after which a client address is locked out. The\n# first lockout lasts a minute and each further one twice as long, up to an\n# hour. Lockouts are recorded in the activity log. Set to 0 to disable.\nlockout_attempts = 5\n"},
+	{"rate_limits", "# Requests each token may make per route group, as \"/prefix=N/unit\" with\n# unit s, min or h; the longest matching prefix applies. All phones using the\n# shared token share its limits; enrolled devices each have their own.\n# Leave empty for no limits.\nrate_limits = \"/message/send=30/min, /message/=300/min, /children=300/min, /auth/=60/min\"\n"},
+	{"sessions_file", "# Session cookies: the PWA exchanges its token for an HttpOnly cookie via\n# POST /auth/session, so scripts cannot read it. Sessions are kept here (as\n# hashes) and end after session_days or when their token stops working.\n# Browsers only keep the cookie over HTTPS; otherwise the PWA keeps using\n# the token.\nsessions_file = \"sessions.json\"\n"},
+	{"session_days", "# Days a session cookie lasts before the device must scan a QR code again.\nsession_days = 30\n"},
+	{"tls_cert", "# HTTPS: service workers and session cookies need a secure context, and\n# tokens should not cross the Wi-Fi in cleartext. Set tls_cert and tls_key\n# to a certificate and key (PEM) to serve HTTPS on listen_addr.\ntls_cert = \"\"\n"},
+	{"tls_key", "tls_key = \"\"\n"},
+	{"tls_auto", "# Without tls_cert, tls_auto = true generates a local certificate authority\n# and a certificate for the server's LAN addresses in tls_dir. Install the\n# CA on each phone once from http://<server>/ca (see http_redirect_addr).\ntls_auto = false\n"},
+	{"tls_dir", "# Directory for the files generated by tls_auto. Keep ca-key.pem secret.\ntls_dir = \"tls\"\n"},
+	{"http_redirect_addr", "# With HTTPS, also listen for plain HTTP here (e.g. \":80\") and redirect to\n# HTTPS. It also serves the CA install page /ca, which phones need before\n# they trust the HTTPS server. Empty: no HTTP listener.\nhttp_redirect_addr = \"\"\n"},
+	{"tls_client_certs", "# With tls_auto, also accept client certificates (mTLS) from the local CA,\n# e.g. for wall-mounted tablets. Issue one with the \"cert\" command in the\n# server's terminal; revoking the device revokes its certificate.\ntls_client_certs = false\n"},
+	{"admin_pin_hash", "# Admin PIN: with a PIN, admin actions (changing the children list, device\n# management) also need the PIN, entered in the PWA's settings. Only its\n# hash is stored; create it with: calling-parents hash-pin\n# Empty: no PIN, admin actions only need the admin role.\nadmin_pin_hash = \"\"\n"},
+	{"elevation_minutes", "# Minutes admin actions stay unlocked after entering the admin PIN.\nelevation_minutes = 10\n"},
 }
 
 // generateDefaultConfig builds the full default config file content from allConfigBlocks.
@@ -71,6 +98,74 @@ type Config struct {
 	// ActivityLog is the path to the activity log JSONL file.
 	// If empty, activity logging is disabled.
 	ActivityLog string `toml:"activity_log"`
+	// Locale selects the collation rules for sorting children's names,
+	// e.g. "de" or "en".
+	Locale string `toml:"locale"`
+	// PrivacyMode shows pickup codes instead of names for every child.
+	PrivacyMode bool `toml:"privacy_mode"`
+	// RetentionWeeks removes children not called for this many weeks.
+	// 0 keeps them forever.
+	RetentionWeeks int `toml:"retention_weeks"`
+	// LogRetentionMonths pseudonymises activity log entries older than this
+	// many months. 0 keeps names in the log.
+	LogRetentionMonths int `toml:"log_retention_months"`
+	// Storage selects the storage backend: "file" (default) or "bolt".
+	Storage string `toml:"storage"`
+	// StoragePath is the database file used when Storage is "bolt".
+	StoragePath string `toml:"storage_path"`
+	// StrictNames rejects sends for names that are not in the children list.
+	StrictNames bool `toml:"strict_names"`
+	// AdminToken authorises admin-only endpoints. Empty disables them.
+	AdminToken string `toml:"admin_token"`
+	// MaxDisplayLength is the maximum number of characters shown on screen.
+	// 0 disables the limit.
+	MaxDisplayLength int `toml:"max_display_length"`
+	// DisplayCharset is the character set of the theme font: "latin1",
+	// "ascii" or empty for any.
+	DisplayCharset string `toml:"display_charset"`
+	// WordFilterDir holds the deny-list files. Empty disables the filter.
+	WordFilterDir string `toml:"word_filter_dir"`
+	// DevicesFile is where enrolled devices are stored.
+	DevicesFile string `toml:"devices_file"`
+	// TokenFile persists the random shared token across restarts. Empty
+	// generates a new token on each start.
+	TokenFile string `toml:"token_file"`
+	// TokenGraceMinutes is how long the previous token works after a
+	// rotation.
+	TokenGraceMinutes int `toml:"token_grace_minutes"`
+	// SigningKeyFile holds the key that signs expiring tokens.
+	SigningKeyFile string `toml:"signing_key_file"`
+	// LockoutAttempts is how many failed logins lock a client address out;
+	// 0 disables lockouts.
+	LockoutAttempts int `toml:"lockout_attempts"`
+	// RateLimits limits each token's requests per route group, e.g.
+	// "/message/send=30/min, /children=300/min".
+	RateLimits string `toml:"rate_limits"`
+	// SessionsFile holds the server-side records of session cookies.
+	SessionsFile string `toml:"sessions_file"`
+	// SessionDays is how long a session cookie lasts.
+	SessionDays int `toml:"session_days"`
+	// TLSCert is the PEM certificate file for HTTPS; with TLSKey it turns
+	// HTTPS on.
+	TLSCert string `toml:"tls_cert"`
+	// TLSKey is the PEM private key file for TLSCert.
+	TLSKey string `toml:"tls_key"`
+	// TLSAuto generates a local CA and server certificate for HTTPS when
+	// TLSCert is not set.
+	TLSAuto bool `toml:"tls_auto"`
+	// TLSDir holds the files generated by TLSAuto.
+	TLSDir string `toml:"tls_dir"`
+	// HTTPRedirectAddr is where plain HTTP is redirected to HTTPS. Empty
+	// disables it.
+	HTTPRedirectAddr string `toml:"http_redirect_addr"`
+	// TLSClientCerts accepts client certificates issued by the local CA of
+	// TLSAuto (mTLS).
+	TLSClientCerts bool `toml:"tls_client_certs"`
+	// AdminPINHash is the PBKDF2 hash of the admin PIN. Empty disables
+	// the PIN.
+	AdminPINHash string `toml:"admin_pin_hash"`
+	// ElevationMinutes is how long an admin PIN entry lasts.
+	ElevationMinutes int `toml:"elevation_minutes"`
 }
 
 // Load reads configuration from a TOML file, then applies environment variable
@@ -111,8 +206,9 @@ func Load(path string) (Config, LoadResult, error) {
 
 // mergeNewKeys checks for config keys that are not present in the user's file.
 // If any are found, it backs up the file and appends the missing blocks.
-// Keys that are commented out in the default template (activity_log, auth_token)
-// are detected by scanning the raw file content for both active and commented forms.
+// Keys that are commented out in the default template (activity_log,
+// auth_token, admin_token) are detected by scanning the raw file content for
+// both active and commented forms.
 func mergeNewKeys(path string, meta toml.MetaData) ([]string, string, error) {
 	// Build set of keys present in the decoded TOML.
 	defined := make(map[string]bool)
@@ -181,12 +277,25 @@ func mergeNewKeys(path string, meta toml.MetaData) ([]string, string, error) {
 // defaults returns a Config with sensible default values.
 func defaults() Config {
 	return Config{
-		ProPresenterHost: "localhost",
-		ProPresenterPort: "50001",
-		ListenAddr:       ":8080",
-		ChildrenFile:     "children.json",
-		MessageName:      "Eltern rufen",
-		AutoClearSeconds: 30,
+		ProPresenterHost:  "localhost",
+		ProPresenterPort:  "50001",
+		ListenAddr:        ":8080",
+		ChildrenFile:      "children.json",
+		MessageName:       "Eltern rufen",
+		AutoClearSeconds:  30,
+		Locale:            "de",
+		Storage:           "file",
+		StoragePath:       "calling-parents.db",
+		MaxDisplayLength:  40,
+		DevicesFile:       "devices.json",
+		TokenGraceMinutes: 30,
+		SigningKeyFile:    "signing.key",
+		LockoutAttempts:   5,
+		RateLimits:        "/message/send=30/min, /message/=300/min, /children=300/min, /auth/=60/min",
+		SessionsFile:      "sessions.json",
+		SessionDays:       30,
+		TLSDir:            "tls",
+		ElevationMinutes:  10,
 	}
 }
 
@@ -218,6 +327,109 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("ACTIVITY_LOG"); v != "" {
 		cfg.ActivityLog = v
 	}
+	if v := os.Getenv("LOCALE"); v != "" {
+		cfg.Locale = v
+	}
+	if v := os.Getenv("PRIVACY_MODE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.PrivacyMode = b
+		}
+	}
+	if v := os.Getenv("RETENTION_WEEKS"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.RetentionWeeks = i
+		}
+	}
+	if v := os.Getenv("LOG_RETENTION_MONTHS"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.LogRetentionMonths = i
+		}
+	}
+	if v := os.Getenv("STORAGE"); v != "" {
+		cfg.Storage = v
+	}
+	if v := os.Getenv("STORAGE_PATH"); v != "" {
+		cfg.StoragePath = v
+	}
+	if v := os.Getenv("STRICT_NAMES"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.StrictNames = b
+		}
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("MAX_DISPLAY_LENGTH"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.MaxDisplayLength = i
+		}
+	}
+	if v := os.Getenv("DISPLAY_CHARSET"); v != "" {
+		cfg.DisplayCharset = v
+	}
+	if v := os.Getenv("WORD_FILTER_DIR"); v != "" {
+		cfg.WordFilterDir = v
+	}
+	if v := os.Getenv("DEVICES_FILE"); v != "" {
+		cfg.DevicesFile = v
+	}
+	if v := os.Getenv("TOKEN_FILE"); v != "" {
+		cfg.TokenFile = v
+	}
+	if v := os.Getenv("TOKEN_GRACE_MINUTES"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.TokenGraceMinutes = i
+		}
+	}
+	if v := os.Getenv("SIGNING_KEY_FILE"); v != "" {
+		cfg.SigningKeyFile = v
+	}
+	if v := os.Getenv("LOCKOUT_ATTEMPTS"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.LockoutAttempts = i
+		}
+	}
+	if v := os.Getenv("RATE_LIMITS"); v != "" {
+		cfg.RateLimits = v
+	}
+	if v := os.Getenv("SESSIONS_FILE"); v != "" {
+		cfg.SessionsFile = v
+	}
+	if v := os.Getenv("SESSION_DAYS"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.SessionDays = i
+		}
+	}
+	if v := os.Getenv("TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("TLS_AUTO"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.TLSAuto = b
+		}
+	}
+	if v := os.Getenv("TLS_DIR"); v != "" {
+		cfg.TLSDir = v
+	}
+	if v := os.Getenv("HTTP_REDIRECT_ADDR"); v != "" {
+		cfg.HTTPRedirectAddr = v
+	}
+	if v := os.Getenv("TLS_CLIENT_CERTS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.TLSClientCerts = b
+		}
+	}
+	if v := os.Getenv("ADMIN_PIN_HASH"); v != "" {
+		cfg.AdminPINHash = v
+	}
+	if v := os.Getenv("ELEVATION_MINUTES"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			cfg.ElevationMinutes = i
+		}
+	}
 }
 
 // ProPresenterURL returns the base URL for the ProPresenter API.
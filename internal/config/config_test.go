@@ -14,7 +14,20 @@ func clearEnv(t *testing.T) {
 	for _, key := range []string{
 		"PROPRESENTER_HOST", "PROPRESENTER_PORT", "LISTEN_ADDR",
 		"CHILDREN_FILE", "AUTH_TOKEN", "MESSAGE_NAME",
-		"AUTO_CLEAR_SECONDS", "ACTIVITY_LOG",
+		"AUTO_CLEAR_SECONDS", "ACTIVITY_LOG", "LOCALE", "PRIVACY_MODE",
+		"RETENTION_WEEKS", "LOG_RETENTION_MONTHS", "STORAGE", "STORAGE_PATH",
+		"STRICT_NAMES", "ADMIN_TOKEN", "MAX_DISPLAY_LENGTH", "DISPLAY_CHARSET",
+		"WORD_FILTER_DIR", "DEVICES_FILE", "TOKEN_FILE", "TOKEN_GRACE_MINUTES",
+		"SIGNING_KEY_FILE", "LOCKOUT_ATTEMPTS", "RATE_LIMITS", "SESSIONS_FILE",
+		"SESSION_DAYS",
+		"TLS_CERT",
+		"TLS_KEY",
+		"TLS_AUTO",
+		"TLS_DIR",
+		"HTTP_REDIRECT_ADDR",
+		"TLS_CLIENT_CERTS",
+		"ADMIN_PIN_HASH",
+		"ELEVATION_MINUTES",
 	} {
 		t.Setenv(key, "")
 		os.Unsetenv(key)
@@ -56,6 +69,9 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.ActivityLog != "" {
 		t.Errorf("expected ActivityLog=empty, got %s", cfg.ActivityLog)
 	}
+	if cfg.Locale != "de" {
+		t.Errorf("expected Locale=de, got %s", cfg.Locale)
+	}
 }
 
 func TestLoadFromTOML(t *testing.T) {
@@ -250,7 +266,30 @@ propresenter_port = "9999"
 	// Should have merged the missing keys.
 	expected := []string{
 		"listen_addr", "children_file", "message_name",
-		"auto_clear_seconds", "activity_log", "auth_token",
+		"auto_clear_seconds", "activity_log", "auth_token", "locale",
+		"privacy_mode", "retention_weeks", "log_retention_months", "storage",
+		"storage_path",
+		"strict_names",
+		"admin_token",
+		"max_display_length",
+		"display_charset",
+		"word_filter_dir",
+		"devices_file",
+		"token_file",
+		"token_grace_minutes",
+		"signing_key_file",
+		"lockout_attempts",
+		"rate_limits",
+		"sessions_file",
+		"session_days",
+		"tls_cert",
+		"tls_key",
+		"tls_auto",
+		"tls_dir",
+		"http_redirect_addr",
+		"tls_client_certs",
+		"admin_pin_hash",
+		"elevation_minutes",
 	}
 	if len(result.MergedKeys) != len(expected) {
 		t.Fatalf("expected %d merged keys, got %d: %v", len(expected), len(result.MergedKeys), result.MergedKeys)
@@ -331,9 +370,9 @@ auto_clear_seconds = 99
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Only activity_log and auth_token should be merged (they were commented-out defaults).
-	if len(result.MergedKeys) != 2 {
-		t.Fatalf("expected 2 merged keys, got %d: %v", len(result.MergedKeys), result.MergedKeys)
+	// Only the keys missing from the file should be merged.
+	if len(result.MergedKeys) != 29 {
+		t.Fatalf("expected 13 merged keys, got %d: %v", len(result.MergedKeys), result.MergedKeys)
 	}
 
 	// All custom values must be preserved.
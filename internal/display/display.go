@@ -0,0 +1,23 @@
+// Package display formats children's names for the screen. It is shared by
+// the children store and the message handler, so neither depends on the
+// other for it.
+package display
+
+import "strings"
+
+// JoinNames combines first names for display: "Anna", "Anna & Ben",
+// "Anna, Ben & Clara". A non-empty surname is appended.
+func JoinNames(names []string, surname string) string {
+	var text string
+	switch len(names) {
+	case 0:
+	case 1:
+		text = names[0]
+	default:
+		text = strings.Join(names[:len(names)-1], ", ") + " & " + names[len(names)-1]
+	}
+	if surname != "" {
+		text = strings.TrimSpace(text + " " + surname)
+	}
+	return text
+}
@@ -0,0 +1,23 @@
+package display
+
+import "testing"
+
+func TestJoinNames(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		names   []string
+		surname string
+		want    string
+	}{
+		{[]string{"Anna"}, "", "Anna"},
+		{[]string{"Anna", "Ben"}, "", "Anna & Ben"},
+		{[]string{"Anna", "Ben", "Clara"}, "Müller", "Anna, Ben & Clara Müller"},
+		{nil, "Müller", "Müller"},
+	}
+	for _, tc := range tests {
+		if got := JoinNames(tc.names, tc.surname); got != tc.want {
+			t.Errorf("JoinNames(%v, %q) = %q, want %q", tc.names, tc.surname, got, tc.want)
+		}
+	}
+}
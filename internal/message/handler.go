@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tafli/CallingParents/internal/activitylog"
+	"github.com/tafli/CallingParents/internal/auth"
+	"github.com/tafli/CallingParents/internal/display"
+	"github.com/tafli/CallingParents/internal/sanitize"
+	"github.com/tafli/CallingParents/internal/wordfilter"
 )
 
 // Handler provides HTTP endpoints that proxy message operations to ProPresenter.
@@ -20,6 +25,62 @@ type Handler struct {
 	autoClearSeconds int
 	client           *http.Client
 	logger           *activitylog.Logger
+	children         Children
+	strictNames      bool
+	sanitizer        *sanitize.Sanitizer
+	filter           *wordfilter.Filter
+
+	// tpl caches the message template for HandlePreview; tplFetching is
+	// set while it is being fetched.
+	tplMu       sync.Mutex
+	tpl         *template
+	tplFetched  time.Time
+	tplFetching bool
+}
+
+// Children is the part of the children store the handler reports to.
+type Children interface {
+	// RecordActivity notes that a child was called at the given time.
+	RecordActivity(name string, at time.Time)
+	// Family returns the children of a family and its surname. ok is false
+	// if the family has no children.
+	Family(id string) (names []string, surname string, ok bool)
+	// PickupCode returns the code to show instead of the child's name.
+	// hide is true if the name must not be shown; code is empty if there
+	// is no code to show instead, e.g. for a name not on the list while
+	// privacy mode is on.
+	PickupCode(name string) (code string, hide bool)
+	// LookupCode is PickupCode for previews: it never assigns or saves a
+	// code, so a child without one yet has an empty code.
+	LookupCode(name string) (code string, hide bool)
+	// Resolve returns the list name of the child meant by name. ok is false
+	// if no single child matches.
+	Resolve(name string) (canonical string, ok bool)
+}
+
+// SetChildren sets the children store that is told about each successful
+// send, so recently called children rank higher in search. Nil disables it.
+func (h *Handler) SetChildren(c Children) {
+	h.children = c
+}
+
+// SetStrictNames turns strict mode on or off. In strict mode HandleSend only
+// calls children from the list; free text is rejected and can only be sent
+// through HandleSendText.
+func (h *Handler) SetStrictNames(on bool) {
+	h.strictNames = on
+}
+
+// SetSanitizer sets how the text is cleaned before it is sent to
+// ProPresenter. Nil sends it as typed.
+func (h *Handler) SetSanitizer(s *sanitize.Sanitizer) {
+	h.sanitizer = s
+}
+
+// SetWordFilter sets the deny-list HandleSend checks before contacting
+// ProPresenter. Nil allows any text.
+func (h *Handler) SetWordFilter(f *wordfilter.Filter) {
+	h.filter = f
 }
 
 // New creates a Handler that talks to ProPresenter at the given base URL
@@ -34,13 +95,34 @@ func New(proPresenterURL, messageName string, autoClearSeconds int, logger *acti
 	}
 }
 
-// sendRequest is the expected JSON body for POST /message/send.
+// sendRequest is the expected JSON body for POST /message/send. Either Name
+// or Family is set; Family calls the parents of all children in the family.
 type sendRequest struct {
-	Name string `json:"name"`
+	Name   string `json:"name"`
+	Family string `json:"family,omitempty"`
 }
 
-// HandleSend triggers the ProPresenter message with the given child's name.
+// HandleSend triggers the ProPresenter message with the given child's name,
+// or with the combined names of a family's children ("Anna & Ben Müller").
+// Private children are shown by their pickup code instead. Each child is
+// logged individually with its name and, if shown, its code. In strict mode
+// the name must belong to a child in the list. Text blocked by the word
+// filter is rejected and logged. The response is a
+// sanitize.Result with the text that went on screen and what was changed.
 func (h *Handler) HandleSend(w http.ResponseWriter, r *http.Request) {
+	h.send(w, r, false)
+}
+
+// HandleSendText is the privileged variant of HandleSend for admins: it
+// accepts the same body but shows any text, bypassing strict mode, the word
+// filter and the privacy mode check for names without a pickup code.
+func (h *Handler) HandleSendText(w http.ResponseWriter, r *http.Request) {
+	h.send(w, r, true)
+}
+
+// send implements HandleSend and HandleSendText. Unless privileged is set,
+// strict mode and the word filter apply.
+func (h *Handler) send(w http.ResponseWriter, r *http.Request, privileged bool) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -52,16 +134,23 @@ func (h *Handler) HandleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := strings.TrimSpace(req.Name)
-	if name == "" {
-		http.Error(w, "name must not be empty", http.StatusBadRequest)
+	c, cerr := h.prepare(req, privileged, false)
+	if cerr != nil {
+		http.Error(w, cerr.message, cerr.status)
 		return
 	}
+	if !privileged {
+		if rule, blocked := h.filter.Check(c.shown.Text); blocked {
+			h.logger.LogRejected(c.shown.Text, auth.RequestDevice(r), rule)
+			http.Error(w, "Der Text wurde vom Wortfilter blockiert", http.StatusForbidden)
+			return
+		}
+	}
 
 	msgID := url.PathEscape(h.messageName)
 	ppURL := fmt.Sprintf("%s/v1/message/%s/trigger", h.proPresenterURL, msgID)
 
-	body := fmt.Sprintf(`[{"name":"Name","text":{"text":"%s"}}]`, escapeJSON(name))
+	body := fmt.Sprintf(`[{"name":"%s","text":{"text":"%s"}}]`, nameToken, escapeJSON(c.shown.Text))
 
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
@@ -85,8 +174,15 @@ func (h *Handler) HandleSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Log("send", name)
-	w.WriteHeader(http.StatusNoContent)
+	now := time.Now()
+	for i, n := range c.names {
+		h.logger.LogSend(n, c.codes[i], auth.RequestDevice(r))
+		if h.children != nil {
+			h.children.RecordActivity(n, now)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.shown)
 }
 
 // HandleClear clears the ProPresenter message.
@@ -120,7 +216,7 @@ func (h *Handler) HandleClear(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Log("clear", "")
+	h.logger.LogClear(auth.RequestDevice(r))
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -165,6 +261,90 @@ func (h *Handler) HandleTest(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(messages)
 }
 
+// call is a send request resolved to the children it calls and the text
+// shown on screen.
+type call struct {
+	// names are the children to log, or the typed text for free text.
+	names []string
+	// codes holds the pickup code shown for each name, or "".
+	codes []string
+	shown sanitize.Result
+}
+
+// callError is a send request that cannot be shown, with its HTTP status.
+type callError struct {
+	status  int
+	message string
+}
+
+// prepare resolves a send request to a call: it looks up the family, checks
+// the name against the list in strict mode, replaces private children's
+// names with their codes and sanitises the text. privileged skips strict
+// mode and shows names that have no pickup code as typed, even in privacy
+// mode; otherwise such names are rejected. preview only looks up codes and
+// never assigns one.
+func (h *Handler) prepare(req sendRequest, privileged, preview bool) (call, *callError) {
+	strict := h.strictNames && !privileged
+	names := []string{strings.TrimSpace(req.Name)}
+	surname := ""
+	family := strings.TrimSpace(req.Family)
+	if family != "" {
+		if h.children == nil {
+			return call{}, &callError{http.StatusNotFound, "families are not available"}
+		}
+		members, familySurname, ok := h.children.Family(family)
+		if !ok {
+			return call{}, &callError{http.StatusNotFound, "unknown family"}
+		}
+		names, surname = members, familySurname
+	}
+	if names[0] == "" {
+		return call{}, &callError{http.StatusBadRequest, "name must not be empty"}
+	}
+	// Family members come from the list and need no check.
+	if strict && family == "" {
+		var canonical string
+		ok := false
+		if h.children != nil {
+			canonical, ok = h.children.Resolve(names[0])
+		}
+		if !ok {
+			return call{}, &callError{http.StatusUnprocessableEntity, fmt.Sprintf("Unbekanntes Kind %q: es können nur Kinder aus der Liste aufgerufen werden", names[0])}
+		}
+		names[0] = canonical
+	}
+
+	codes := make([]string, len(names))
+	shown := make([]string, len(names))
+	for i, n := range names {
+		shown[i] = n
+		if h.children == nil {
+			continue
+		}
+		lookup := h.children.PickupCode
+		if preview {
+			lookup = h.children.LookupCode
+		}
+		code, hide := lookup(n)
+		if !hide {
+			continue
+		}
+		if code == "" && !privileged {
+			return call{}, &callError{http.StatusUnprocessableEntity, fmt.Sprintf("Datenschutzmodus: %q hat keinen Abholcode; es können nur Kinder aus der Liste aufgerufen werden", n)}
+		}
+		if code != "" {
+			codes[i], shown[i] = code, code
+			// A surname next to a code would identify the family.
+			surname = ""
+		}
+	}
+	shownText := h.sanitizer.Clean(display.JoinNames(shown, surname))
+	if shownText.Text == "" {
+		return call{}, &callError{http.StatusBadRequest, "Der Text enthält keine anzeigbaren Zeichen"}
+	}
+	return call{names: names, codes: codes, shown: shownText}, nil
+}
+
 // escapeJSON escapes a string for safe embedding in a JSON string literal.
 func escapeJSON(s string) string {
 	b, _ := json.Marshal(s)
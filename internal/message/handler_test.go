@@ -2,10 +2,19 @@ package message
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/activitylog"
+	"github.com/tafli/CallingParents/internal/sanitize"
+	"github.com/tafli/CallingParents/internal/wordfilter"
 )
 
 func TestHandleSendSuccess(t *testing.T) {
@@ -30,8 +39,8 @@ func TestHandleSendSuccess(t *testing.T) {
 
 	h.HandleSend(rec, req)
 
-	if rec.Code != http.StatusNoContent {
-		t.Errorf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body.String())
 	}
 
 	expectedPath := "/v1/message/Eltern rufen/trigger"
@@ -254,3 +263,331 @@ func TestHandleConfigRejectsPost(t *testing.T) {
 		t.Errorf("expected 405, got %d", rec.Code)
 	}
 }
+
+type fakeChildren struct {
+	called   []string
+	families map[string][]string
+	codes    map[string]string
+	known    []string
+	// privacy hides every name, like the store's privacy mode.
+	privacy bool
+	// pickups counts PickupCode calls, which may assign and save codes.
+	pickups int
+}
+
+func (f *fakeChildren) RecordActivity(name string, _ time.Time) {
+	f.called = append(f.called, name)
+}
+
+func (f *fakeChildren) PickupCode(name string) (string, bool) {
+	f.pickups++
+	code, ok := f.codes[name]
+	return code, ok || f.privacy
+}
+
+func (f *fakeChildren) LookupCode(name string) (string, bool) {
+	code, ok := f.codes[name]
+	return code, ok || f.privacy
+}
+
+func (f *fakeChildren) Resolve(name string) (string, bool) {
+	for _, known := range f.known {
+		if strings.EqualFold(known, name) {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeChildren) Family(id string) ([]string, string, bool) {
+	names, ok := f.families[id]
+	return names, "Müller", ok
+}
+
+func TestHandleSendRecordsActivity(t *testing.T) {
+	t.Parallel()
+
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	children := &fakeChildren{}
+	h.SetChildren(children)
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Paul"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if len(children.called) != 1 || children.called[0] != "Paul" {
+		t.Errorf("expected activity for Paul, got %v", children.called)
+	}
+}
+
+func TestHandleSendFamily(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	children := &fakeChildren{families: map[string][]string{"mueller": {"Anna", "Ben"}}}
+	h.SetChildren(children)
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"family":"mueller"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var tokens []struct {
+		Text struct {
+			Text string `json:"text"`
+		} `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(received), &tokens); err != nil || len(tokens) != 1 {
+		t.Fatalf("unexpected ProPresenter body %s: %v", received, err)
+	}
+	if got := tokens[0].Text.Text; got != "Anna & Ben Müller" {
+		t.Errorf("expected combined names, got %q", got)
+	}
+	if len(children.called) != 2 || children.called[0] != "Anna" || children.called[1] != "Ben" {
+		t.Errorf("expected activity for each child, got %v", children.called)
+	}
+}
+
+func TestHandleSendUnknownFamily(t *testing.T) {
+	t.Parallel()
+
+	h := New("http://127.0.0.1:1", "Eltern rufen", 0, nil)
+	h.SetChildren(&fakeChildren{})
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"family":"nobody"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestHandleSendPrivateChildShowsCode(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	dir := t.TempDir()
+	logger, err := activitylog.New(filepath.Join(dir, "activity.jsonl"))
+	if err != nil {
+		t.Fatalf("activitylog.New() error: %v", err)
+	}
+	defer logger.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, logger)
+	h.SetChildren(&fakeChildren{codes: map[string]string{"Paul": "K7M"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Paul"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(received, "Paul") || !strings.Contains(received, "K7M") {
+		t.Errorf("expected only the code on screen, got %s", received)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "activity.jsonl"))
+	if !strings.Contains(string(data), `"name":"Paul"`) || !strings.Contains(string(data), `"code":"K7M"`) {
+		t.Errorf("expected name and code in activity log, got %s", data)
+	}
+}
+
+func TestHandleSendPrivacyModeRejectsNamesWithoutCode(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	h.SetChildren(&fakeChildren{codes: map[string]string{"Paul": "K7M"}, privacy: true})
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Lena Schmidt"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a name without code, got %d", rec.Code)
+	}
+	if received != "" {
+		t.Errorf("ProPresenter must not be contacted, got %q", received)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Paul"}`))
+	rec = httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(received, "K7M") {
+		t.Errorf("expected the code on screen, got %d %q", rec.Code, received)
+	}
+}
+
+func TestHandleSendStrictNames(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	h.SetChildren(&fakeChildren{known: []string{"Paul"}})
+	h.SetStrictNames(true)
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Frohe Ostern!"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for unknown name, got %d", rec.Code)
+	}
+	if received != "" {
+		t.Errorf("ProPresenter must not be contacted, got %q", received)
+	}
+
+	// Known names are shown as written in the list.
+	req = httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"paul"}`))
+	rec = httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(received, `"text":"Paul"`) {
+		t.Errorf("expected canonical name Paul, got %q", received)
+	}
+
+	// The privileged path still accepts free text.
+	req = httptest.NewRequest(http.MethodPost, "/message/send-text", strings.NewReader(`{"name":"Frohe Ostern!"}`))
+	rec = httptest.NewRecorder()
+	h.HandleSendText(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for free text, got %d", rec.Code)
+	}
+}
+
+func TestHandleSendSanitizes(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	san, err := sanitize.New(sanitize.Options{MaxLength: 10})
+	if err != nil {
+		t.Fatalf("sanitize.New() error: %v", err)
+	}
+	h.SetSanitizer(san)
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Maximilian-Alexander 🎉"}`))
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var res sanitize.Result
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Text != "Maximilian" || !slices.Equal(res.Changes, []string{sanitize.Symbols, sanitize.Truncated}) {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if !strings.Contains(received, `"text":"Maximilian"`) {
+		t.Errorf("expected sanitised text sent to ProPresenter, got %q", received)
+	}
+
+	// Nothing displayable is left.
+	req = httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"🎉"}`))
+	rec = httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for emoji-only text, got %d", rec.Code)
+	}
+}
+
+func TestHandleSendWordFilter(t *testing.T) {
+	t.Parallel()
+
+	var received string
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pp.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.txt"), []byte("frohe ostern\n"), 0644)
+	filter, err := wordfilter.Load(dir)
+	if err != nil {
+		t.Fatalf("wordfilter.Load() error: %v", err)
+	}
+	logger, err := activitylog.New(filepath.Join(dir, "activity.jsonl"))
+	if err != nil {
+		t.Fatalf("activitylog.New() error: %v", err)
+	}
+	defer logger.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, logger)
+	h.SetWordFilter(filter)
+
+	req := httptest.NewRequest(http.MethodPost, "/message/send", strings.NewReader(`{"name":"Frohe Ostern!"}`))
+	req.RemoteAddr = "192.168.1.23:51234"
+	rec := httptest.NewRecorder()
+	h.HandleSend(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+	if received != "" {
+		t.Errorf("ProPresenter must not be contacted, got %q", received)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "activity.jsonl"))
+	for _, want := range []string{`"action":"rejected"`, `"device":"192.168.1.23"`, `"reason":"de.txt:1"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %s in activity log, got %s", want, data)
+		}
+	}
+
+	// Admins can override the filter.
+	req = httptest.NewRequest(http.MethodPost, "/message/send-text", strings.NewReader(`{"name":"Frohe Ostern!"}`))
+	rec = httptest.NewRecorder()
+	h.HandleSendText(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for admin send, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,223 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/sanitize"
+)
+
+// nameToken is the ProPresenter message token that receives the child's
+// name.
+const nameToken = "Name"
+
+// templateTTL is how long a template fetched from ProPresenter is reused.
+// Templates rarely change during a service; previews run on every
+// keystroke.
+const templateTTL = time.Minute
+
+// tokenPattern matches a token placeholder such as {Name} in a template.
+var tokenPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// Warning codes returned by HandlePreview.
+const (
+	// warnUnknownToken: the template uses a token that has no text.
+	warnUnknownToken = "unknownToken"
+	// warnTooLong: the name was cut to max_display_length.
+	warnTooLong = "tooLong"
+	// warnUnknownChild: strict mode, or privacy mode for a name without a
+	// pickup code, would reject the name.
+	warnUnknownChild = "unknownChild"
+	// warnBlocked: the word filter would reject the text.
+	warnBlocked = "blocked"
+	// warnNoTemplate: the template could not be read from ProPresenter, so
+	// only the name is shown.
+	warnNoTemplate = "templateUnavailable"
+)
+
+// template is a ProPresenter message template.
+type template struct {
+	text string
+	// tokens maps token names to their text in the template. Timer and
+	// clock tokens are rendered by ProPresenter and have no entry.
+	tokens map[string]string
+}
+
+// ppMessage is one entry of ProPresenter's GET /v1/messages.
+type ppMessage struct {
+	ID struct {
+		Name string `json:"name"`
+	} `json:"id"`
+	Message string `json:"message"`
+	Tokens  []struct {
+		Name string `json:"name"`
+		Text *struct {
+			Text string `json:"text"`
+		} `json:"text"`
+	} `json:"tokens"`
+}
+
+// template returns the message template, from the cache if it is fresh. If
+// ProPresenter cannot be reached, or another preview is already fetching
+// the template, a stale cached template is used. The lock is not held while
+// fetching, so a slow ProPresenter does not hold up other previews.
+func (h *Handler) template(ctx context.Context) (*template, error) {
+	h.tplMu.Lock()
+	cached := h.tpl
+	if cached != nil && (time.Since(h.tplFetched) < templateTTL || h.tplFetching) {
+		h.tplMu.Unlock()
+		return cached, nil
+	}
+	h.tplFetching = true
+	h.tplMu.Unlock()
+
+	tpl, err := h.fetchTemplate(ctx)
+
+	h.tplMu.Lock()
+	defer h.tplMu.Unlock()
+	h.tplFetching = false
+	if err != nil {
+		if h.tpl != nil {
+			return h.tpl, nil
+		}
+		return nil, err
+	}
+	h.tpl, h.tplFetched = tpl, time.Now()
+	return tpl, nil
+}
+
+// fetchTemplate reads the configured message template from ProPresenter.
+func (h *Handler) fetchTemplate(ctx context.Context) (*template, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.proPresenterURL+"/v1/messages", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("ProPresenter returned %s", resp.Status)
+	}
+
+	var messages []ppMessage
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return nil, fmt.Errorf("decoding messages: %w", err)
+	}
+	for _, m := range messages {
+		if m.ID.Name != h.messageName {
+			continue
+		}
+		tpl := &template{text: m.Message, tokens: make(map[string]string)}
+		for _, t := range m.Tokens {
+			if t.Text != nil {
+				tpl.tokens[t.Name] = t.Text.Text
+			}
+		}
+		return tpl, nil
+	}
+	return nil, fmt.Errorf("message %q not found in ProPresenter", h.messageName)
+}
+
+// render substitutes the tokens of tpl, with name for the name token. It
+// returns the tokens that have no text; they are left in place.
+func (tpl *template) render(name string) (string, []string) {
+	var unknown []string
+	text := tokenPattern.ReplaceAllStringFunc(tpl.text, func(m string) string {
+		token := m[1 : len(m)-1]
+		if token == nameToken {
+			return name
+		}
+		if value, ok := tpl.tokens[token]; ok {
+			return value
+		}
+		if !slices.Contains(unknown, token) {
+			unknown = append(unknown, token)
+		}
+		return m
+	})
+	return text, unknown
+}
+
+// previewWarning is one problem found by HandlePreview.
+type previewWarning struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// previewResponse is the JSON body returned by HandlePreview.
+type previewResponse struct {
+	// Text is the full message as it will appear on screen.
+	Text string `json:"text"`
+	// Name is the sanitised value of the name token.
+	Name string `json:"name"`
+	// Changes lists the sanitize steps that changed the name.
+	Changes  []string         `json:"changes,omitempty"`
+	Warnings []previewWarning `json:"warnings"`
+}
+
+// HandlePreview handles POST /message/preview. It takes the same body as
+// HandleSend and returns the text that a send would put on screen, rendered
+// from the ProPresenter template, with warnings for anything that would
+// make the send fail or look wrong. Nothing is shown, logged or saved; a
+// child that would be given a pickup code by the send is previewed without
+// one.
+func (h *Handler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	warnings := []previewWarning{}
+	c, cerr := h.prepare(req, false, true)
+	if cerr != nil && cerr.status == http.StatusUnprocessableEntity {
+		// Show what the text would look like and why it would be rejected.
+		warnings = append(warnings, previewWarning{Code: warnUnknownChild, Detail: req.Name})
+		c, cerr = h.prepare(req, true, true)
+	}
+	if cerr != nil {
+		http.Error(w, cerr.message, cerr.status)
+		return
+	}
+
+	if slices.Contains(c.shown.Changes, sanitize.Truncated) {
+		warnings = append(warnings, previewWarning{Code: warnTooLong})
+	}
+	if rule, blocked := h.filter.Check(c.shown.Text); blocked {
+		warnings = append(warnings, previewWarning{Code: warnBlocked, Detail: rule})
+	}
+
+	text := c.shown.Text
+	if tpl, err := h.template(r.Context()); err != nil {
+		warnings = append(warnings, previewWarning{Code: warnNoTemplate, Detail: err.Error()})
+	} else {
+		var unknown []string
+		text, unknown = tpl.render(c.shown.Text)
+		for _, token := range unknown {
+			warnings = append(warnings, previewWarning{Code: warnUnknownToken, Detail: token})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previewResponse{
+		Text:     text,
+		Name:     c.shown.Text,
+		Changes:  c.shown.Changes,
+		Warnings: warnings,
+	})
+}
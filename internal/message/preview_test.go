@@ -0,0 +1,180 @@
+package message
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/sanitize"
+	"github.com/tafli/CallingParents/internal/wordfilter"
+)
+
+// messagesJSON is a GET /v1/messages response with the "Eltern rufen"
+// template.
+const messagesJSON = `[
+	{"id":{"name":"Andere","uuid":"1","index":0},"message":"{Name} bitte","tokens":[]},
+	{"id":{"name":"Eltern rufen","uuid":"2","index":1},"message":"Eltern von {Name} {Raum}{Zeit}",
+	 "tokens":[{"name":"Name","text":{"text":""}},{"name":"Raum","text":{"text":"(Saal)"}},{"name":"Uhr","clock":{}}]}
+]`
+
+func preview(t *testing.T, h *Handler, body string) (int, previewResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/message/preview", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.HandlePreview(rec, req)
+	var res previewResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	return rec.Code, res
+}
+
+func TestHandlePreviewRendersTemplate(t *testing.T) {
+	t.Parallel()
+
+	var fetches atomic.Int32
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("preview must not call %s", r.URL.Path)
+		}
+		fetches.Add(1)
+		w.Write([]byte(messagesJSON))
+	}))
+	defer pp.Close()
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+
+	code, res := preview(t, h, `{"name":"Paul"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if res.Text != "Eltern von Paul (Saal){Zeit}" || res.Name != "Paul" {
+		t.Errorf("unexpected preview %+v", res)
+	}
+	if len(res.Warnings) != 1 || res.Warnings[0] != (previewWarning{Code: warnUnknownToken, Detail: "Zeit"}) {
+		t.Errorf("expected unknown token warning, got %+v", res.Warnings)
+	}
+
+	// The template is cached.
+	preview(t, h, `{"name":"Anna"}`)
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected 1 template fetch, got %d", n)
+	}
+}
+
+func TestHandlePreviewWarnings(t *testing.T) {
+	t.Parallel()
+
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":{"name":"Eltern rufen"},"message":"Eltern von {Name}","tokens":[]}]`))
+	}))
+	defer pp.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.txt"), []byte("maximilian\n"), 0644)
+	filter, err := wordfilter.Load(dir)
+	if err != nil {
+		t.Fatalf("wordfilter.Load() error: %v", err)
+	}
+	san, err := sanitize.New(sanitize.Options{MaxLength: 10})
+	if err != nil {
+		t.Fatalf("sanitize.New() error: %v", err)
+	}
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	h.SetSanitizer(san)
+	h.SetWordFilter(filter)
+	h.SetChildren(&fakeChildren{known: []string{"Paul"}})
+	h.SetStrictNames(true)
+
+	code, res := preview(t, h, `{"name":"Maximilian-Alexander"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if res.Text != "Eltern von Maximilian" {
+		t.Errorf("unexpected text %q", res.Text)
+	}
+	var codes []string
+	for _, w := range res.Warnings {
+		codes = append(codes, w.Code)
+	}
+	want := []string{warnUnknownChild, warnTooLong, warnBlocked}
+	if strings.Join(codes, ",") != strings.Join(want, ",") {
+		t.Errorf("warnings = %v, want %v", codes, want)
+	}
+}
+
+func TestHandlePreviewWithoutProPresenter(t *testing.T) {
+	t.Parallel()
+
+	h := New("http://127.0.0.1:1", "Eltern rufen", 0, nil)
+
+	code, res := preview(t, h, `{"name":"Paul"}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if res.Text != "Paul" || len(res.Warnings) != 1 || res.Warnings[0].Code != warnNoTemplate {
+		t.Errorf("unexpected preview %+v", res)
+	}
+
+	code, _ = preview(t, h, `{"name":" "}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("expected 400 for empty name, got %d", code)
+	}
+}
+
+func TestHandlePreviewDoesNotAssignCodes(t *testing.T) {
+	t.Parallel()
+
+	h := New("http://127.0.0.1:1", "Eltern rufen", 0, nil)
+	children := &fakeChildren{known: []string{"Anna"}, codes: map[string]string{"Anna": "K7M"}}
+	h.SetChildren(children)
+
+	code, res := preview(t, h, `{"name":"Anna"}`)
+	if code != http.StatusOK || res.Name != "K7M" {
+		t.Fatalf("unexpected preview %d %+v", code, res)
+	}
+	if children.pickups != 0 {
+		t.Errorf("preview called PickupCode %d times; it may assign and save codes", children.pickups)
+	}
+}
+
+func TestHandlePreviewUsesStaleTemplateWhileFetching(t *testing.T) {
+	t.Parallel()
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	pp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fetching)
+		<-release
+		w.Write([]byte(messagesJSON))
+	}))
+	defer pp.Close()
+	defer close(release)
+
+	h := New(pp.URL, "Eltern rufen", 0, nil)
+	h.tpl = &template{text: "Alt: {Name}", tokens: map[string]string{}}
+	h.tplFetched = time.Now().Add(-2 * templateTTL)
+
+	go preview(t, h, `{"name":"Paul"}`)
+	<-fetching
+
+	// The first preview is stuck fetching; this one must not wait for it.
+	done := make(chan previewResponse)
+	go func() {
+		_, res := preview(t, h, `{"name":"Anna"}`)
+		done <- res
+	}()
+	select {
+	case res := <-done:
+		if res.Text != "Alt: Anna" {
+			t.Errorf("expected the stale template, got %q", res.Text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("preview waited for another preview's template fetch")
+	}
+}
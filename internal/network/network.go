@@ -12,20 +12,35 @@ import (
 // listenAddr is in the format accepted by net.Listen, e.g. ":8080" or "0.0.0.0:8080".
 // If no suitable LAN IP is found, it falls back to "localhost".
 func LanURL(listenAddr string) string {
-	ip := lanIP()
+	return lanURL("http", listenAddr)
+}
+
+// SecureLanURL is LanURL for a server that listens with TLS.
+func SecureLanURL(listenAddr string) string {
+	return lanURL("https", listenAddr)
+}
+
+func lanURL(scheme, listenAddr string) string {
+	ip := "localhost"
+	if ips := LanIPs(); len(ips) > 0 {
+		ip = ips[0].String()
+	}
 	_, port, err := net.SplitHostPort(listenAddr)
 	if err != nil {
 		port = "8080"
 	}
-	return fmt.Sprintf("http://%s:%s", ip, port)
+	return fmt.Sprintf("%s://%s:%s", scheme, ip, port)
 }
 
-func lanIP() string {
+// LanIPs returns the IPv4 addresses of all network interfaces that are up,
+// except loopback, in interface order.
+func LanIPs() []net.IP {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "localhost"
+		return nil
 	}
 
+	var ips []net.IP
 	for _, iface := range ifaces {
 		// Skip loopback and down interfaces.
 		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
@@ -46,9 +61,8 @@ func lanIP() string {
 			if ip == nil {
 				continue // skip IPv6
 			}
-			return ip.String()
+			ips = append(ips, ip)
 		}
 	}
-
-	return "localhost"
+	return ips
 }
@@ -65,3 +65,22 @@ func TestLanURLReturnsValidURL(t *testing.T) {
 		t.Fatal("host part is empty")
 	}
 }
+
+func TestSecureLanURL(t *testing.T) {
+	t.Parallel()
+
+	url := SecureLanURL(":8443")
+	if !strings.HasPrefix(url, "https://") || !strings.HasSuffix(url, ":8443") {
+		t.Errorf("SecureLanURL(%q) = %q", ":8443", url)
+	}
+}
+
+func TestLanIPsSkipsLoopback(t *testing.T) {
+	t.Parallel()
+
+	for _, ip := range LanIPs() {
+		if ip.IsLoopback() || ip.To4() == nil {
+			t.Errorf("unexpected address %s", ip)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package sanitize cleans text before it is shown on the audience screen.
+// Names are typed on phones and pasted from spreadsheets; emoji, control and
+// zero-width characters or very long strings break the ProPresenter theme.
+package sanitize
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Change codes reported in Result.Changes, each at most once.
+const (
+	// Invisible means control, format or zero-width characters were removed.
+	Invisible = "invisible"
+	// Symbols means emoji or other symbols were removed.
+	Symbols = "symbols"
+	// Whitespace means runs of whitespace were collapsed or trimmed.
+	Whitespace = "whitespace"
+	// Transliterated means characters outside the display charset were
+	// replaced, e.g. "ł" with "l".
+	Transliterated = "transliterated"
+	// Unsupported means characters outside the display charset that have no
+	// replacement were removed.
+	Unsupported = "unsupported"
+	// Truncated means the text was cut to the maximum length.
+	Truncated = "truncated"
+)
+
+// Options configures a Sanitizer.
+type Options struct {
+	// MaxLength is the maximum number of characters, counting a letter with
+	// its accents as one. 0 disables the limit.
+	MaxLength int
+	// Charset is the character set of the theme font: "latin1", "ascii" or
+	// empty to allow any letter.
+	Charset string
+}
+
+// Sanitizer cleans display text. A nil *Sanitizer returns text unchanged.
+type Sanitizer struct {
+	maxLength int
+	charset   string
+	inCharset func(rune) bool
+}
+
+// New returns a Sanitizer for the given options.
+func New(opts Options) (*Sanitizer, error) {
+	if opts.MaxLength < 0 {
+		return nil, fmt.Errorf("maximum length must not be negative, got %d", opts.MaxLength)
+	}
+	s := &Sanitizer{maxLength: opts.MaxLength, charset: opts.Charset}
+	switch opts.Charset {
+	case "":
+	case "latin1":
+		s.inCharset = func(r rune) bool { return r <= 0xFF }
+	case "ascii":
+		s.inCharset = func(r rune) bool { return r < 0x80 }
+	default:
+		return nil, fmt.Errorf("unknown display charset %q (want latin1 or ascii)", opts.Charset)
+	}
+	return s, nil
+}
+
+// Result is the cleaned text and what was changed to get there.
+type Result struct {
+	Text string `json:"text"`
+	// Changes lists the change codes that applied, e.g. ["symbols"].
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Changed reports whether the text was modified.
+func (r Result) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+// Clean removes control, invisible and symbol characters, collapses
+// whitespace, transliterates to the display charset and enforces the
+// maximum length, in that order.
+func (s *Sanitizer) Clean(text string) Result {
+	if s == nil {
+		return Result{Text: text}
+	}
+
+	var res Result
+	note := func(change string) {
+		if !slices.Contains(res.Changes, change) {
+			res.Changes = append(res.Changes, change)
+		}
+	}
+
+	text = norm.NFC.String(text)
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case isInvisible(r):
+			note(Invisible)
+		case isSymbol(r):
+			note(Symbols)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	// Only whitespace in the input is reported, not gaps left behind by
+	// removed characters.
+	if collapseSpace(text) != text {
+		note(Whitespace)
+	}
+	out := collapseSpace(b.String())
+
+	if s.inCharset != nil {
+		b.Reset()
+		for _, r := range out {
+			if s.inCharset(r) {
+				b.WriteRune(r)
+				continue
+			}
+			if repl, ok := s.transliterate(r); ok {
+				b.WriteString(repl)
+				note(Transliterated)
+			} else {
+				note(Unsupported)
+			}
+		}
+		out = collapseSpace(b.String())
+	}
+
+	if s.maxLength > 0 {
+		if cut, ok := truncate(out, s.maxLength); ok {
+			out = cut
+			note(Truncated)
+		}
+	}
+
+	res.Text = out
+	return res
+}
+
+// collapseSpace trims s and replaces each run of whitespace with one space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// isInvisible reports whether r is a control or format character (e.g.
+// zero-width space, joiner, direction marks) or a variation selector.
+func isInvisible(r rune) bool {
+	if unicode.IsSpace(r) {
+		return false
+	}
+	return unicode.In(r, unicode.Cc, unicode.Cf) ||
+		unicode.Is(unicode.Variation_Selector, r)
+}
+
+// isSymbol reports whether r is an emoji or another pictographic symbol,
+// including emoji skin tone modifiers, keycaps and private use characters.
+// Currency and math symbols are kept.
+func isSymbol(r rune) bool {
+	return unicode.In(r, unicode.So, unicode.Co, unicode.Cs) ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) || // skin tone modifiers (Sk)
+		r == 0x20E3 // combining enclosing keycap
+}
+
+// extendsCluster reports whether r belongs to the grapheme cluster of the
+// character before it: a combining mark, or a letter such as the halfwidth
+// katakana voiced sound mark that Unicode treats as one.
+func extendsCluster(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Other_Grapheme_Extend)
+}
+
+// truncate cuts s after limit characters. It only cuts between grapheme
+// clusters: combining marks and other grapheme extenders stay with their
+// base letter and do not count, so a cut never leaves a stray accent. s is
+// in NFC, so most accents are already part of their letter. It reports
+// whether s was cut.
+func truncate(s string, limit int) (string, bool) {
+	n := 0
+	for i, r := range s {
+		if extendsCluster(r) {
+			continue
+		}
+		if n == limit {
+			return strings.TrimRightFunc(s[:i], unicode.IsSpace), true
+		}
+		n++
+	}
+	return s, false
+}
@@ -0,0 +1,73 @@
+package sanitize
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    Options
+		input   string
+		want    string
+		changes []string
+	}{
+		{"unchanged", Options{MaxLength: 40}, "Anna Müller", "Anna Müller", nil},
+		{"emoji", Options{}, "Paul 😀", "Paul", []string{Symbols}},
+		{"emoji sequence", Options{}, "Lea 👩🏽‍💻!", "Lea !", []string{Symbols, Invisible}},
+		{"zero width", Options{}, "Pa\u200bul", "Paul", []string{Invisible}},
+		{"control", Options{}, "Paul\x07", "Paul", []string{Invisible}},
+		{"whitespace", Options{}, "  Anna \t Ben\n", "Anna Ben", []string{Whitespace}},
+		{"decomposed umlaut", Options{}, "Ju\u0308rgen", "Jürgen", nil},
+		{"truncated", Options{MaxLength: 5}, "Maximilian", "Maxim", []string{Truncated}},
+		{"truncated at space", Options{MaxLength: 5}, "Anna Lena", "Anna", []string{Truncated}},
+		{"accents count once", Options{MaxLength: 3}, "Aq\u0307a", "Aq\u0307a", nil},
+		{"decomposed accent at the cut", Options{MaxLength: 4}, "Rene\u0301e", "René", []string{Truncated}},
+		{"uncomposable accent at the cut", Options{MaxLength: 2}, "Xq\u0307\u0323b", "Xq\u0323\u0307", []string{Truncated}},
+		{"grapheme extender at the cut", Options{MaxLength: 1}, "\uff76\uff9e\uff77", "\uff76\uff9e", []string{Truncated}},
+		{"latin1 keeps umlauts", Options{Charset: "latin1"}, "Jürgen", "Jürgen", nil},
+		{"latin1", Options{Charset: "latin1"}, "Łukasz Świątek", "Lukasz Swiatek", []string{Transliterated}},
+		{"ascii umlauts", Options{Charset: "ascii"}, "Jürgen Groß", "Juergen Gross", []string{Transliterated}},
+		{"ascii quotes", Options{Charset: "ascii"}, "Anna „Anni“", "Anna \"Anni\"", []string{Transliterated}},
+		{"unsupported", Options{Charset: "latin1"}, "Anna 李", "Anna", []string{Unsupported}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := New(tc.opts)
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			got := s.Clean(tc.input)
+			if got.Text != tc.want {
+				t.Errorf("Clean(%q).Text = %q, want %q", tc.input, got.Text, tc.want)
+			}
+			if !slices.Equal(got.Changes, tc.changes) {
+				t.Errorf("Clean(%q).Changes = %v, want %v", tc.input, got.Changes, tc.changes)
+			}
+		})
+	}
+}
+
+func TestNilSanitizer(t *testing.T) {
+	t.Parallel()
+
+	var s *Sanitizer
+	if got := s.Clean(" Paul 😀 "); got.Text != " Paul 😀 " || got.Changed() {
+		t.Errorf("nil Sanitizer changed text: %+v", got)
+	}
+}
+
+func TestNewRejectsUnknownCharset(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(Options{Charset: "ebcdic"}); err == nil {
+		t.Error("expected error for unknown charset")
+	}
+	if _, err := New(Options{MaxLength: -1}); err == nil {
+		t.Error("expected error for negative length")
+	}
+}
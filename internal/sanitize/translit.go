@@ -0,0 +1,60 @@
+package sanitize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// germanASCII spells umlauts the German way when the font has no umlauts,
+// "Jürgen" → "Juergen" rather than "Jurgen".
+var germanASCII = map[rune]string{
+	'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss",
+}
+
+// letters replaces letters and punctuation that do not decompose into a
+// base letter plus accents.
+var letters = map[rune]string{
+	'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Ø': "O", 'ø': "o",
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Þ': "Th", 'þ': "th",
+	'Ð': "D", 'ð': "d", 'ı': "i", 'ẞ': "SS", 'ß': "ss",
+	'‘': "'", '’': "'", '‚': "'", '“': "\"", '”': "\"", '„': "\"",
+	'–': "-", '—': "-", '…': "...", '«': "\"", '»': "\"",
+}
+
+// transliterate returns a replacement for r made of characters in the
+// display charset. ok is false if there is none.
+func (s *Sanitizer) transliterate(r rune) (string, bool) {
+	if s.charset == "ascii" {
+		if repl, ok := germanASCII[r]; ok {
+			return repl, true
+		}
+	}
+	if repl, ok := letters[r]; ok && s.fits(repl) {
+		return repl, true
+	}
+
+	// Drop accents: "ł" has its own entry above, "ś" decomposes to "s" + "´".
+	var b strings.Builder
+	for _, d := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, d) {
+			continue
+		}
+		b.WriteRune(d)
+	}
+	if repl := norm.NFC.String(b.String()); repl != "" && repl != string(r) && s.fits(repl) {
+		return repl, true
+	}
+	return "", false
+}
+
+// fits reports whether every character of text is in the display charset.
+func (s *Sanitizer) fits(text string) bool {
+	for _, r := range text {
+		if !s.inCharset(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package tlscert
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// caFileName is the name the CA is downloaded as. Android and iOS both
+// offer to install a .crt file.
+const caFileName = "calling-parents-ca.crt"
+
+// HandleCA handles GET /ca.crt: it serves the CA certificate for
+// installing on a phone.
+func (l *Local) HandleCA(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+caFileName+`"`)
+	w.Write(l.caPEM)
+}
+
+// pageText is the text of the install page in one language.
+type pageText struct {
+	Lang, Title, Intro, Download, Android, IOS, Fingerprint, Open string
+}
+
+// pageTexts are the install page texts by language; German is the default,
+// like in the PWA.
+var pageTexts = map[string]pageText{
+	"de": {
+		Lang:        "de",
+		Title:       "Zertifikat installieren",
+		Intro:       "Damit das Handy der verschlüsselten Verbindung zu Calling Parents vertraut, einmal dieses Zertifikat installieren. Es wurde von diesem Server erzeugt und gilt nur hier im lokalen Netz.",
+		Download:    "Zertifikat herunterladen",
+		Android:     "Android: Zertifikat herunterladen, dann Einstellungen → Sicherheit → Weitere Sicherheitseinstellungen → Verschlüsselung & Anmeldedaten → Zertifikat installieren → CA-Zertifikat und die heruntergeladene Datei wählen.",
+		IOS:         "iPhone/iPad: In Safari herunterladen und „Zulassen“ tippen. Dann Einstellungen → Profil geladen → Installieren. Zum Schluss Einstellungen → Allgemein → Info → Zertifikatsvertrauenseinstellungen und das Zertifikat einschalten.",
+		Fingerprint: "SHA-256-Fingerabdruck zum Vergleichen:",
+		Open:        "Calling Parents öffnen",
+	},
+	"en": {
+		Lang:        "en",
+		Title:       "Install certificate",
+		Intro:       "Install this certificate once so the phone trusts the encrypted connection to Calling Parents. It was created by this server and is only valid here on the local network.",
+		Download:    "Download certificate",
+		Android:     "Android: download the certificate, then Settings → Security → More security settings → Encryption & credentials → Install a certificate → CA certificate, and pick the downloaded file.",
+		IOS:         "iPhone/iPad: download it in Safari and tap \"Allow\". Then Settings → Profile Downloaded → Install. Finally turn the certificate on under Settings → General → About → Certificate Trust Settings.",
+		Fingerprint: "SHA-256 fingerprint to compare:",
+		Open:        "Open Calling Parents",
+	},
+}
+
+var pageTmpl = template.Must(template.New("ca").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 36rem; margin: 0 auto; padding: 1rem; line-height: 1.5; }
+.button { display: block; padding: 0.8rem; margin: 1rem 0; border-radius: 0.5rem; background: #2563eb; color: #fff; text-align: center; text-decoration: none; }
+.button.secondary { background: #e5e7eb; color: #111827; }
+code { display: block; word-break: break-all; font-size: 0.85rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Intro}}</p>
+<a class="button" href="/ca.crt">{{.Download}}</a>
+<p>{{.Android}}</p>
+<p>{{.IOS}}</p>
+<p>{{.Fingerprint}}<code>{{.FingerprintValue}}</code></p>
+<a class="button secondary" href="/">{{.Open}}</a>
+</body>
+</html>
+`))
+
+// HandlePage handles GET /ca: a page explaining how to install the CA on
+// Android and iOS, in German or English depending on Accept-Language.
+func (l *Local) HandlePage(w http.ResponseWriter, r *http.Request) {
+	text := pageTexts[pageLang(r.Header.Get("Accept-Language"))]
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pageTmpl.Execute(w, struct {
+		pageText
+		FingerprintValue string
+	}{text, l.Fingerprint})
+}
+
+// pageLang returns the first language in an Accept-Language header that
+// the page is available in, or "de".
+func pageLang(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := pageTexts[lang]; ok {
+			return lang
+		}
+	}
+	return "de"
+}
+
+// Redirect returns the handler for the plain HTTP listener. It redirects
+// every request to HTTPS on port, except the install page and CA download,
+// which a phone needs before it trusts the HTTPS server. l is nil when the
+// certificate was not generated; then everything is redirected.
+func Redirect(port string, l *Local) http.Handler {
+	mux := http.NewServeMux()
+	if l != nil {
+		mux.HandleFunc("GET /ca", l.HandlePage)
+		mux.HandleFunc("GET /ca.crt", l.HandleCA)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, httpsURL(r.Host, port)+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+	return mux
+}
+
+// httpsURL returns the HTTPS URL of host, a request's Host header, on port.
+func httpsURL(host, port string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if port == "443" {
+		if strings.Contains(host, ":") {
+			return "https://[" + host + "]"
+		}
+		return "https://" + host
+	}
+	return "https://" + net.JoinHostPort(host, port)
+}
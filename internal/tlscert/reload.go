@@ -0,0 +1,79 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval is how often Reloader checks the files for changes.
+const reloadInterval = 10 * time.Second
+
+// Reloader serves a certificate and key from files and reloads them when
+// they change, so a long-running server uses a renewed certificate (from
+// Ensure or e.g. certbot) without a restart. It is safe for concurrent use.
+type Reloader struct {
+	certFile, keyFile string
+	now               func() time.Time
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // newest modification time of the loaded files
+	checked time.Time
+}
+
+// NewReloader loads the certificate and key from certFile and keyFile.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	modTime, err := r.modTimeOfFiles()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.modTime, r.checked = &cert, modTime, r.now()
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for
+// tls.Config.GetCertificate. If the files changed since they were loaded,
+// they are loaded again; while they do not form a valid pair (e.g. halfway
+// through a renewal), the previous certificate is kept.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) < reloadInterval {
+		return r.cert, nil
+	}
+	r.checked = now
+	modTime, err := r.modTimeOfFiles()
+	if err != nil || !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.cert, nil
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// modTimeOfFiles returns the newer modification time of the two files.
+func (r *Reloader) modTimeOfFiles() (time.Time, error) {
+	var newest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,78 @@
+package tlscert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloaderPicksUpRenewedCertificate(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "tls")
+	l, err := Ensure(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	r, err := NewReloader(l.CertFile, l.KeyFile)
+	if err != nil {
+		t.Fatalf("NewReloader() error: %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	first, _ := r.GetCertificate(nil)
+
+	// A new address renews the certificate while the server runs.
+	if _, err := Ensure(dir, []string{"localhost", "10.0.0.5"}); err != nil {
+		t.Fatalf("second Ensure() error: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(l.CertFile, later, later)
+	os.Chtimes(l.KeyFile, later, later)
+
+	if cert, _ := r.GetCertificate(nil); !bytes.Equal(cert.Certificate[0], first.Certificate[0]) {
+		t.Error("reloaded before reloadInterval passed")
+	}
+	now = now.Add(reloadInterval)
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error: %v", err)
+	}
+	if bytes.Equal(cert.Certificate[0], first.Certificate[0]) {
+		t.Error("renewed certificate was not picked up")
+	}
+
+	// A half-written pair keeps the last good certificate.
+	os.WriteFile(l.KeyFile, []byte("not a key"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(l.KeyFile, later, later)
+	now = now.Add(reloadInterval)
+	if again, err := r.GetCertificate(nil); err != nil || again != cert {
+		t.Errorf("GetCertificate() = %v, %v; want the last good certificate", again, err)
+	}
+}
+
+func TestEnsureReplacesMismatchedKey(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "tls")
+	l, err := Ensure(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	// A crash between writing the key and the certificate.
+	os.WriteFile(l.KeyFile, []byte("not a key"), 0600)
+
+	again, err := Ensure(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatalf("second Ensure() error: %v", err)
+	}
+	if !again.Renewed {
+		t.Error("expected a new server certificate for a key that does not fit")
+	}
+	if _, err := NewReloader(again.CertFile, again.KeyFile); err != nil {
+		t.Errorf("NewReloader() error: %v", err)
+	}
+}
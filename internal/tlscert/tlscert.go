@@ -0,0 +1,290 @@
+// Package tlscert creates the local certificate authority (CA) and server
+// certificate used with tls_auto, so phones on the LAN can reach the server
+// over HTTPS without a public domain. Each phone installs the CA once; the
+// server certificate is renewed from it when the LAN addresses change.
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tafli/CallingParents/internal/atomicfile"
+)
+
+// File names inside the TLS directory.
+const (
+	CAFile     = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+	CertFile   = "server.pem"
+	KeyFile    = "server-key.pem"
+	caValidity = 10 * 365 * 24 * time.Hour
+	// certValidity stays below the 825 days iOS accepts for server
+	// certificates. Client certificates last as long.
+	certValidity = 800 * 24 * time.Hour
+	// renewBefore is how long before expiry the server certificate is
+	// replaced.
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// Local is a local CA and a server certificate signed by it.
+type Local struct {
+	// CertFile and KeyFile are the paths of the server certificate and key,
+	// for NewReloader.
+	CertFile string
+	KeyFile  string
+	// Fingerprint is the SHA-256 fingerprint of the CA certificate, so it
+	// can be compared on the phone after installing.
+	Fingerprint string
+	// Renewed reports whether Ensure created a new server certificate.
+	Renewed bool
+
+	caPEM []byte
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+}
+
+// Ensure makes sure dir holds a local CA and a server certificate for hosts,
+// which are IP addresses or DNS names. Existing files are reused; the server
+// certificate is replaced when it does not cover every host, was not signed
+// by the CA, or expires within 30 days. The CA is only created when missing,
+// so phones keep trusting it.
+func Ensure(dir string, hosts []string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ca, caKey, caPEM, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Local{
+		CertFile:    filepath.Join(dir, CertFile),
+		KeyFile:     filepath.Join(dir, KeyFile),
+		Fingerprint: Fingerprint(ca),
+		caPEM:       caPEM,
+		ca:          ca,
+		caKey:       caKey,
+	}
+	// The key must match too: the two files cannot be replaced together, so
+	// a crash between the writes can leave a pair that does not fit.
+	if cert, err := loadCert(l.CertFile); err == nil && covers(cert, hosts) &&
+		cert.CheckSignatureFrom(ca) == nil && time.Until(cert.NotAfter) > renewBefore {
+		if _, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile); err == nil {
+			return l, nil
+		}
+	}
+	if err := createServerCert(l.CertFile, l.KeyFile, ca, caKey, hosts); err != nil {
+		return nil, err
+	}
+	l.Renewed = true
+	return l, nil
+}
+
+// ClientCAs returns a pool with the CA, for verifying client certificates
+// (mTLS).
+func (l *Local) ClientCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(l.ca)
+	return pool
+}
+
+// IssueClient creates a client certificate for subject, signed by the CA,
+// and returns it and its key in PEM format.
+func (l *Local) IssueClient(subject pkix.Name) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		Subject:     subject,
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(certValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := sign(tmpl, l.ca, &key.PublicKey, l.caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert as colon-separated
+// hex, the way phones show it.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// loadOrCreateCA loads the CA from dir, or creates one if there is none.
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, []byte, error) {
+	certPath, keyPath := filepath.Join(dir, CAFile), filepath.Join(dir, caKeyFile)
+	certPEM, err := os.ReadFile(certPath)
+	if err == nil {
+		cert, err := parseCert(certPEM)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("parsing %s: %w", certPath, err)
+		}
+		key, err := loadKey(keyPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return cert, key, certPEM, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Calling Parents local CA " + host, Organization: []string{"Calling Parents"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := sign(tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// The certificate is written last: without it, the next start creates
+	// a new CA instead of loading a key without its certificate.
+	if err := writeKey(keyPath, key); err != nil {
+		return nil, nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := atomicfile.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, key, certPEM, nil
+}
+
+// createServerCert writes a server certificate for hosts, signed by ca.
+func createServerCert(certPath, keyPath string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Calling Parents", Organization: []string{"Calling Parents"}},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(certValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := sign(tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	if err := writeKey(keyPath, key); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// sign creates the certificate tmpl, signed by parent's key, with a random
+// serial number.
+func sign(tmpl, parent *x509.Certificate, pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.SerialNumber = serial
+	return x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+}
+
+// covers reports whether cert is valid for every host.
+func covers(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if h != "" && !slices.Contains(cert.DNSNames, h) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCert(data)
+}
+
+func parseCert(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("parsing %s: no PEM key", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// writeKey writes key to path, readable only by the owner.
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,187 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// verify checks that the server certificate in l is valid for host when
+// the CA in dir is trusted.
+func verify(t *testing.T, dir string, l *Local, host string) error {
+	t.Helper()
+	ca, err := loadCert(filepath.Join(dir, CAFile))
+	if err != nil {
+		t.Fatalf("loading CA: %v", err)
+	}
+	cert, err := loadCert(l.CertFile)
+	if err != nil {
+		t.Fatalf("loading server certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+	return err
+}
+
+func TestEnsure(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "tls")
+	l, err := Ensure(dir, []string{"192.168.1.20", "localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	if !l.Renewed {
+		t.Error("expected a new server certificate")
+	}
+	for _, host := range []string{"192.168.1.20", "localhost"} {
+		if err := verify(t, dir, l, host); err != nil {
+			t.Errorf("verify %s: %v", host, err)
+		}
+	}
+	if err := verify(t, dir, l, "192.168.1.21"); err == nil {
+		t.Error("expected the certificate to be invalid for another address")
+	}
+	info, err := os.Stat(filepath.Join(dir, caKeyFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("CA key: %v, mode %v", err, info.Mode().Perm())
+	}
+
+	// The same hosts reuse the certificate.
+	again, err := Ensure(dir, []string{"localhost", "192.168.1.20"})
+	if err != nil {
+		t.Fatalf("second Ensure() error: %v", err)
+	}
+	if again.Renewed || again.Fingerprint != l.Fingerprint {
+		t.Errorf("expected the certificate to be reused, got %+v", again)
+	}
+
+	// A new LAN address renews the server certificate, but keeps the CA.
+	moved, err := Ensure(dir, []string{"10.0.0.5", "localhost"})
+	if err != nil {
+		t.Fatalf("third Ensure() error: %v", err)
+	}
+	if !moved.Renewed || moved.Fingerprint != l.Fingerprint {
+		t.Errorf("expected a new server certificate from the same CA, got %+v", moved)
+	}
+	if err := verify(t, dir, moved, "10.0.0.5"); err != nil {
+		t.Errorf("verify new address: %v", err)
+	}
+}
+
+func TestHandleCA(t *testing.T) {
+	t.Parallel()
+
+	l, err := Ensure(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	l.HandleCA(rec, httptest.NewRequest(http.MethodGet, "/ca.crt", nil))
+	if got := rec.Header().Get("Content-Type"); got != "application/x-x509-ca-cert" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	ca, err := parseCert(rec.Body.Bytes())
+	if err != nil || !ca.IsCA || Fingerprint(ca) != l.Fingerprint {
+		t.Errorf("unexpected CA download: %v", err)
+	}
+}
+
+func TestHandlePageLanguage(t *testing.T) {
+	t.Parallel()
+
+	l, err := Ensure(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "Zertifikat installieren"},
+		{"en-US,en;q=0.9", "Install certificate"},
+		{"fr-FR, en;q=0.5", "Install certificate"},
+		{"de-DE", "Zertifikat installieren"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ca", nil)
+		req.Header.Set("Accept-Language", tc.header)
+		rec := httptest.NewRecorder()
+		l.HandlePage(rec, req)
+		body := rec.Body.String()
+		if !strings.Contains(body, tc.want) || !strings.Contains(body, l.Fingerprint) {
+			t.Errorf("Accept-Language %q: page lacks %q or the fingerprint", tc.header, tc.want)
+		}
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	t.Parallel()
+
+	l, err := Ensure(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		port     string
+		l        *Local
+		target   string
+		wantCode int
+		wantLoc  string
+	}{
+		{"app", "8443", l, "http://192.168.1.20:8080/index.html?x=1", http.StatusPermanentRedirect, "https://192.168.1.20:8443/index.html?x=1"},
+		{"default port", "443", l, "http://192.168.1.20/", http.StatusPermanentRedirect, "https://192.168.1.20/"},
+		{"api", "8443", l, "http://host.local:8080/message/send", http.StatusPermanentRedirect, "https://host.local:8443/message/send"},
+		{"install page", "8443", l, "http://192.168.1.20:8080/ca", http.StatusOK, ""},
+		{"CA download", "8443", l, "http://192.168.1.20:8080/ca.crt", http.StatusOK, ""},
+		{"no local CA", "8443", nil, "http://192.168.1.20:8080/ca", http.StatusPermanentRedirect, "https://192.168.1.20:8443/ca"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Redirect(tc.port, tc.l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if rec.Code != tc.wantCode || rec.Header().Get("Location") != tc.wantLoc {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Header().Get("Location"), tc.wantCode, tc.wantLoc)
+			}
+		})
+	}
+}
+
+func TestIssueClient(t *testing.T) {
+	t.Parallel()
+
+	l, err := Ensure(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatalf("Ensure() error: %v", err)
+	}
+	certPEM, keyPEM, err := l.IssueClient(pkix.Name{CommonName: "Gruppenraum 1", SerialNumber: "ab12cd34"})
+	if err != nil {
+		t.Fatalf("IssueClient() error: %v", err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	if cert.Subject.SerialNumber != "ab12cd34" {
+		t.Errorf("subject = %v", cert.Subject)
+	}
+	opts := x509.VerifyOptions{Roots: l.ClientCAs(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Errorf("verify as client certificate: %v", err)
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Error("client certificate must not work as a server certificate")
+	}
+}
@@ -0,0 +1,146 @@
+// Package wordfilter blocks unwanted text before it reaches the audience
+// screen. The deny-lists are plain text files, one per language, kept
+// outside the binary so each congregation can maintain its own.
+package wordfilter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// stripMarks removes diacritics, so "hölle" also matches "holle".
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// rule is one line of a list file.
+type rule struct {
+	// source is "file:line", reported when the rule matches.
+	source string
+	// words is the folded word or phrase, matched as whole words.
+	words string
+	// pattern is set for /regex/ lines instead of words.
+	pattern *regexp.Regexp
+}
+
+// Filter checks text against the rules of all loaded lists. A nil *Filter
+// allows everything.
+type Filter struct {
+	rules []rule
+	files []string
+}
+
+// Load reads every *.txt file in dir, e.g. de.txt and en.txt. Each line is a
+// word or phrase that is blocked when it appears as whole words, ignoring
+// case and accents, or a regular expression between slashes
+// (/sp[a4]m+/), matched case-insensitively against the text. Empty lines
+// and lines starting with # are ignored.
+func Load(dir string) (*Filter, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	f := &Filter{}
+	for _, path := range paths {
+		if err := f.loadFile(path); err != nil {
+			return nil, err
+		}
+		f.files = append(f.files, filepath.Base(path))
+	}
+	return f, nil
+}
+
+func (f *Filter) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(path)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := rule{source: fmt.Sprintf("%s:%d", name, n)}
+		if len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+			re, err := regexp.Compile("(?i)" + line[1:len(line)-1])
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern: %w", r.source, err)
+			}
+			r.pattern = re
+		} else {
+			r.words = fold(line)
+			if r.words == "" {
+				continue
+			}
+		}
+		f.rules = append(f.rules, r)
+	}
+	return scanner.Err()
+}
+
+// Files returns the names of the loaded list files.
+func (f *Filter) Files() []string {
+	if f == nil {
+		return nil
+	}
+	return f.files
+}
+
+// Len returns the number of rules.
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.rules)
+}
+
+// Check reports whether text is blocked and, if so, which rule matched as
+// "file:line".
+func (f *Filter) Check(text string) (source string, blocked bool) {
+	if f == nil || len(f.rules) == 0 {
+		return "", false
+	}
+	// Pad with spaces so phrases only match whole words.
+	folded := " " + fold(text) + " "
+	for _, r := range f.rules {
+		if r.pattern != nil {
+			if r.pattern.MatchString(text) {
+				return r.source, true
+			}
+		} else if strings.Contains(folded, " "+r.words+" ") {
+			return r.source, true
+		}
+	}
+	return "", false
+}
+
+// fold returns the words of s, case-folded and without accents, joined by
+// single spaces. Punctuation separates words, so "Spam!" yields "spam".
+func fold(s string) string {
+	s, _, err := transform.String(stripMarks, cases.Fold().String(s))
+	if err != nil {
+		return ""
+	}
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
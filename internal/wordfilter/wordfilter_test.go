@@ -0,0 +1,67 @@
+package wordfilter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.txt"), []byte("# Beispiel\n\nhölle\nfrohe ostern\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "en.txt"), []byte("/sp[a4]m+/\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.md"), []byte("paul\n"), 0644)
+
+	f, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if f.Len() != 3 {
+		t.Errorf("expected 3 rules, got %d", f.Len())
+	}
+
+	tests := []struct {
+		text    string
+		source  string
+		blocked bool
+	}{
+		{"Paul", "", false},
+		{"Zur Hölle!", "de.txt:3", true},
+		{"zur HOLLE", "de.txt:3", true},
+		{"Höllenberg", "", false}, // only whole words
+		{"Frohe   Ostern", "de.txt:4", true},
+		{"Ostern", "", false},
+		{"SP4MMM", "en.txt:1", true},
+	}
+	for _, tc := range tests {
+		source, blocked := f.Check(tc.text)
+		if source != tc.source || blocked != tc.blocked {
+			t.Errorf("Check(%q) = %q, %v, want %q, %v", tc.text, source, blocked, tc.source, tc.blocked)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "en.txt"), []byte("/[unclosed/\n"), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestNilFilterAllows(t *testing.T) {
+	t.Parallel()
+
+	var f *Filter
+	if _, blocked := f.Check("anything"); blocked {
+		t.Error("nil filter must not block")
+	}
+}